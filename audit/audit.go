@@ -0,0 +1,288 @@
+// Package audit records the column changes of entities to a history table or a pluggable sink.
+//
+// The history table used by TableSink, for example in PostgreSQL:
+//
+//	CREATE TABLE audit_logs (
+//		id bigserial PRIMARY KEY,
+//		table_name varchar(255) NOT NULL,
+//		entity_key varchar(255) NOT NULL,
+//		action varchar(32) NOT NULL,
+//		actor varchar(255) NOT NULL DEFAULT '',
+//		changes text NOT NULL,
+//		created_at timestamptz NOT NULL
+//	);
+//
+// Every Update, Upsert and Delete of an Auditable entity costs an extra SELECT from the primary,
+// and a transaction is begun for it by entity.Operation.BeginTx unless the database of the operation is already a transaction,
+// the middleware registered before it write with the same transaction, such as the outbox.
+// The statements prepared by PrepareUpdate on a non-transactional database are prepared again on that transaction
+// for every write, prepare them on a transaction of the caller to avoid it.
+package audit
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx/reflectx"
+	"github.com/joyparty/entity"
+	"github.com/joyparty/entity/internal/field"
+)
+
+var mapper = reflectx.NewMapper("db")
+
+type actorKey struct{}
+
+// Auditable is implemented by entities opting in the audit log.
+type Auditable interface {
+	entity.Entity
+
+	// AuditExclude returns the columns excluded from the audit log, such as update time.
+	AuditExclude() []string
+}
+
+// Change is the values of a column before and after the operation, nil means NULL or absent.
+type Change struct {
+	Column string `json:"column"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+// Record is a change history of an entity.
+type Record struct {
+	Table string
+	// Key is the primary key values of the entity, joined by comma for composite keys.
+	Key       string
+	Action    entity.OperationKind
+	Actor     string
+	Changes   []Change
+	CreatedAt time.Time
+}
+
+// Sink writes audit records, db is the database of the entity operation,
+// so the records are written in the same transaction as the entity.
+type Sink interface {
+	Write(ctx context.Context, db entity.DB, records []Record) error
+}
+
+// WithActor returns a copy of ctx carrying the actor of the following operations.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor carried by ctx.
+func ActorFromContext(ctx context.Context) (string, bool) {
+	actor, ok := ctx.Value(actorKey{}).(string)
+	return actor, ok
+}
+
+// Option configures the audit middleware.
+type Option func(*options)
+
+type options struct {
+	sink Sink
+}
+
+// WithSink sets the sink of audit records, TableSink with DefaultTable is used by default.
+func WithSink(sink Sink) Option {
+	return func(o *options) {
+		o.sink = sink
+	}
+}
+
+// Middleware returns an entity.Middleware recording the column changes of Auditable entities
+// on Update, Upsert and Delete.
+//
+// The current row is loaded from the primary before the operation to compute the changes,
+// the loading, the operation and the writing of records run in the same transaction,
+// a transaction is begun if the database of the operation is not one, and it is committed
+// after the outer middleware return.
+//
+// Example:
+//
+//	entity.Use(audit.Middleware())
+func Middleware(opts ...Option) entity.Middleware {
+	o := &options{sink: TableSink(DefaultTable)}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return func(next entity.Handler) entity.Handler {
+		return func(ctx context.Context, op *entity.Operation) error {
+			ent, ok := op.Entity.(Auditable)
+			if !ok {
+				return next(ctx, op)
+			}
+
+			switch op.Kind {
+			case entity.OperationUpdate, entity.OperationUpsert, entity.OperationDelete:
+			default:
+				return next(ctx, op)
+			}
+
+			if err := op.BeginTx(ctx); err != nil {
+				return fmt.Errorf("audit, %w", err)
+			}
+
+			before, err := loadCurrent(ctx, op)
+			if err != nil {
+				return fmt.Errorf("audit load, %w", err)
+			}
+
+			if err := next(ctx, op); err != nil {
+				return err
+			}
+
+			var after entity.Entity
+			if op.Kind != entity.OperationDelete {
+				after = op.Entity
+			}
+
+			changes := diff(op, before, after, ent.AuditExclude())
+			if len(changes) == 0 {
+				return nil
+			}
+
+			record := Record{
+				Table:     op.Metadata.TableName,
+				Key:       entityKey(op.Metadata, op.Entity),
+				Action:    op.Kind,
+				Changes:   changes,
+				CreatedAt: time.Now(),
+			}
+			record.Actor, _ = ActorFromContext(ctx)
+
+			if err := o.sink.Write(ctx, op.DB, []Record{record}); err != nil {
+				return fmt.Errorf("audit write, %w", err)
+			}
+			return nil
+		}
+	}
+}
+
+// loadCurrent loads the row of the entity from the primary, nil if not exists.
+func loadCurrent(ctx context.Context, op *entity.Operation) (entity.Entity, error) {
+	md := op.Metadata
+
+	columns := make([]any, 0, len(md.Columns))
+	for _, col := range md.Columns {
+		columns = append(columns, goqu.C(col.DBField))
+	}
+
+	stmt := entity.Dialect(op.DB).
+		From(entity.TableIdentifier(md.TableName)).
+		Select(columns...).
+		Where(keyCondition(md, op.Entity))
+
+	current := reflect.New(md.Type).Interface()
+	if err := entity.GetRecord(entity.ForcePrimary(ctx), current, op.DB, stmt); err != nil {
+		if entity.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return current.(entity.Entity), nil
+}
+
+// keyCondition matches the row of the entity by primary keys and tenant column like the statements of entity,
+// the tenant of entity has been set from the context before the middleware.
+func keyCondition(md *entity.Metadata, ent entity.Entity) goqu.Ex {
+	rv := reflect.ValueOf(ent)
+
+	cond := goqu.Ex{}
+	for _, col := range md.Columns {
+		if col.PrimaryKey || col.Tenant {
+			cond[col.DBField] = columnValue(field.ByColumn(mapper, rv, col.DBField))
+		}
+	}
+	return cond
+}
+
+func entityKey(md *entity.Metadata, ent entity.Entity) string {
+	rv := reflect.ValueOf(ent)
+
+	values := make([]string, 0, len(md.PrimaryKeys))
+	for _, col := range md.PrimaryKeys {
+		values = append(values, fmt.Sprint(columnValue(field.ByColumn(mapper, rv, col.DBField))))
+	}
+	return strings.Join(values, ",")
+}
+
+// diff compares the columns written by the operation, before or after is nil if the row is absent.
+func diff(op *entity.Operation, before, after entity.Entity, exclude []string) []Change {
+	excluded := map[string]bool{}
+	for _, col := range exclude {
+		excluded[col] = true
+	}
+
+	var changes []Change
+	for _, col := range op.Metadata.Columns {
+		if excluded[col.DBField] {
+			continue
+		} else if op.Kind == entity.OperationUpdate && col.RefuseUpdate && !col.PrimaryKey {
+			// refused columns are not written by update, the values of the entity may be stale
+			continue
+		}
+
+		change := Change{Column: col.DBField}
+		if before != nil {
+			change.Before = columnValue(field.ByColumn(mapper, reflect.ValueOf(before), col.DBField))
+		}
+		if after != nil {
+			change.After = columnValue(field.ByColumn(mapper, reflect.ValueOf(after), col.DBField))
+		}
+
+		if !equal(change.Before, change.After) {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+func equal(a, b any) bool {
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			return ta.Equal(tb)
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// columnValue returns the value of a field as written to the database.
+func columnValue(v reflect.Value) any {
+	if !v.IsValid() {
+		return nil
+	}
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+
+		if valuer, ok := v.Interface().(driver.Valuer); ok {
+			return valuerValue(valuer)
+		}
+		v = v.Elem()
+	}
+
+	if valuer, ok := v.Interface().(driver.Valuer); ok {
+		return valuerValue(valuer)
+	} else if v.CanAddr() {
+		if valuer, ok := v.Addr().Interface().(driver.Valuer); ok {
+			return valuerValue(valuer)
+		}
+	}
+	return v.Interface()
+}
+
+func valuerValue(valuer driver.Valuer) any {
+	value, err := valuer.Value()
+	if err != nil {
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,211 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/joyparty/entity"
+	"github.com/joyparty/entity/outbox"
+)
+
+type account struct {
+	ID       int64          `db:"id,primaryKey"`
+	Name     string         `db:"name"`
+	Email    sql.NullString `db:"email"`
+	Nickname *string        `db:"nickname"`
+	CreateAt int64          `db:"create_at,refuseUpdate"`
+	UpdateAt int64          `db:"update_at"`
+}
+
+func (a *account) TableName() string {
+	return "accounts"
+}
+
+func (a *account) AuditExclude() []string {
+	return []string{"update_at"}
+}
+
+func TestDiff(t *testing.T) {
+	md, err := entity.NewMetadata(&account{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := &account{ID: 1, Name: "a", CreateAt: 1, UpdateAt: 1}
+	after := &account{
+		ID:       1,
+		Name:     "b",
+		Email:    sql.NullString{String: "b@c.d", Valid: true},
+		CreateAt: 2,
+		UpdateAt: 2,
+	}
+
+	t.Run("update", func(t *testing.T) {
+		op := &entity.Operation{Kind: entity.OperationUpdate, Metadata: md, Entity: after}
+
+		expected := []Change{
+			{Column: "name", Before: "a", After: "b"},
+			{Column: "email", Before: nil, After: "b@c.d"},
+		}
+		if changes := diff(op, before, after, after.AuditExclude()); !reflect.DeepEqual(changes, expected) {
+			t.Fatalf("update changes, Expected=%v, Actual=%v", expected, changes)
+		} else if after.Nickname != nil {
+			t.Fatal("nil pointer field should not be allocated")
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		op := &entity.Operation{Kind: entity.OperationDelete, Metadata: md, Entity: before}
+
+		expected := []Change{
+			{Column: "id", Before: int64(1)},
+			{Column: "name", Before: "a"},
+			{Column: "create_at", Before: int64(1)},
+		}
+		if changes := diff(op, before, nil, before.AuditExclude()); !reflect.DeepEqual(changes, expected) {
+			t.Fatalf("delete changes, Expected=%v, Actual=%v", expected, changes)
+		}
+	})
+
+	if key := entityKey(md, after); key != "1" {
+		t.Fatalf("entity key, Expected=1, Actual=%s", key)
+	}
+}
+
+// recordConnector is a driver recording the statements, queries return no rows.
+type recordConnector struct {
+	statements *[]string
+}
+
+func (c recordConnector) Connect(context.Context) (driver.Conn, error) {
+	return recordConn(c), nil
+}
+
+func (c recordConnector) Driver() driver.Driver {
+	return c
+}
+
+func (c recordConnector) Open(string) (driver.Conn, error) {
+	return recordConn(c), nil
+}
+
+type recordConn recordConnector
+
+func (c recordConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c recordConn) Close() error {
+	return nil
+}
+
+func (c recordConn) Begin() (driver.Tx, error) {
+	*c.statements = append(*c.statements, "BEGIN")
+	return recordTx(c), nil
+}
+
+func (c recordConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	*c.statements = append(*c.statements, query)
+	return emptyRows{}, nil
+}
+
+func (c recordConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	*c.statements = append(*c.statements, query)
+	return driver.RowsAffected(1), nil
+}
+
+type recordTx recordConn
+
+func (tx recordTx) Commit() error {
+	*tx.statements = append(*tx.statements, "COMMIT")
+	return nil
+}
+
+func (tx recordTx) Rollback() error {
+	*tx.statements = append(*tx.statements, "ROLLBACK")
+	return nil
+}
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string {
+	return nil
+}
+
+func (emptyRows) Close() error {
+	return nil
+}
+
+func (emptyRows) Next([]driver.Value) error {
+	return io.EOF
+}
+
+type outboxAccount struct {
+	account
+}
+
+func (a *outboxAccount) OutboxEvents(context.Context, entity.OperationKind) ([]outbox.Event, error) {
+	return []outbox.Event{{Topic: "account", Payload: []byte("{}")}}, nil
+}
+
+func TestMiddleware(t *testing.T) {
+	var statements []string
+	db := sqlx.NewDb(sql.OpenDB(recordConnector{statements: &statements}), "postgres")
+	defer db.Close()
+
+	// outbox is outside of audit, the events are written in the transaction begun by audit
+	entity.Use(outbox.Middleware(), Middleware())
+
+	if err := entity.Update(context.Background(), &outboxAccount{account{ID: 1, Name: "a"}}, db); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"BEGIN",
+		`SELECT`,
+		`UPDATE "accounts"`,
+		`INSERT INTO "audit_logs"`,
+		`INSERT INTO "outbox_messages"`,
+		"COMMIT",
+	}
+	if len(statements) != len(expected) {
+		t.Fatalf("statements, Expected=%v, Actual=%v", expected, statements)
+	}
+	for i, stmt := range statements {
+		if !strings.HasPrefix(stmt, expected[i]) {
+			t.Fatalf("statements, Expected=%v, Actual=%v", expected, statements)
+		}
+	}
+}
+
+type tenantAccount struct {
+	ID       int64 `db:"id,primaryKey"`
+	TenantID int64 `db:"tenant_id,tenant"`
+}
+
+func (ta *tenantAccount) TableName() string {
+	return "tenant_accounts"
+}
+
+func (ta *tenantAccount) AuditExclude() []string {
+	return nil
+}
+
+func TestKeyCondition(t *testing.T) {
+	md, err := entity.NewMetadata(&tenantAccount{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]any{"id": int64(1), "tenant_id": int64(2)}
+	if cond := keyCondition(md, &tenantAccount{ID: 1, TenantID: 2}); !reflect.DeepEqual(map[string]any(cond), expected) {
+		t.Fatalf("key condition, Expected=%v, Actual=%v", expected, cond)
+	}
+}
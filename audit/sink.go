@@ -0,0 +1,52 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/joyparty/entity"
+)
+
+// DefaultTable is the default name of the history table.
+const DefaultTable = "audit_logs"
+
+// SinkFunc is an adapter to allow the use of ordinary functions as Sink.
+type SinkFunc func(ctx context.Context, db entity.DB, records []Record) error
+
+// Write calls f(ctx, db, records).
+func (f SinkFunc) Write(ctx context.Context, db entity.DB, records []Record) error {
+	return f(ctx, db, records)
+}
+
+// TableSink writes audit records to a history table, the changes are encoded as JSON.
+type TableSink string
+
+// Write inserts the records into the table.
+func (ts TableSink) Write(ctx context.Context, db entity.DB, records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	rows := make([]any, 0, len(records))
+	for _, record := range records {
+		changes, err := json.Marshal(record.Changes)
+		if err != nil {
+			return fmt.Errorf("encode changes, %w", err)
+		}
+
+		rows = append(rows, goqu.Record{
+			"table_name": record.Table,
+			"entity_key": record.Key,
+			"action":     record.Action.String(),
+			"actor":      record.Actor,
+			"changes":    string(changes),
+			"created_at": record.CreatedAt,
+		})
+	}
+
+	stmt := entity.Dialect(db).Insert(entity.TableIdentifier(string(ts))).Rows(rows...)
+	_, err := entity.ExecInsert(ctx, db, stmt)
+	return err
+}
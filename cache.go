@@ -40,8 +40,29 @@ type CacheOption struct {
 	RecursiveDecode []string
 }
 
-func loadCache(ctx context.Context, ent Cacheable) (bool, error) {
-	opt, err := getCacheOption(ent)
+func loadCache(ctx context.Context, ent Cacheable) (loaded bool, err error) {
+	var table string
+	if v, ok := ent.(Entity); ok {
+		table = v.TableName()
+	}
+
+	ctx, span := startSpan(ctx, SpanCache, table)
+	defer func() {
+		if err == nil {
+			if loaded {
+				observeCache(ctx, CacheHit)
+			} else {
+				observeCache(ctx, CacheMiss)
+			}
+		}
+		endSpan(ctx, span, err)
+	}()
+
+	return doLoadCache(ctx, ent)
+}
+
+func doLoadCache(ctx context.Context, ent Cacheable) (bool, error) {
+	opt, err := getCacheOption(ctx, ent)
 	if err != nil {
 		return false, fmt.Errorf("get option, %w", err)
 	}
@@ -84,7 +105,7 @@ func loadCache(ctx context.Context, ent Cacheable) (bool, error) {
 
 // SaveCache saves an entity to the cache.
 func SaveCache(ctx context.Context, ent Cacheable) error {
-	opt, err := getCacheOption(ent)
+	opt, err := getCacheOption(ctx, ent)
 	if err != nil {
 		return fmt.Errorf("get option, %w", err)
 	} else if opt.Disable {
@@ -113,7 +134,7 @@ func SaveCache(ctx context.Context, ent Cacheable) error {
 
 // DeleteCache removes an entity from the cache.
 func DeleteCache(ctx context.Context, ent Cacheable) error {
-	opt, err := getCacheOption(ent)
+	opt, err := getCacheOption(ctx, ent)
 	if err != nil {
 		return fmt.Errorf("get option, %w", err)
 	}
@@ -121,7 +142,7 @@ func DeleteCache(ctx context.Context, ent Cacheable) error {
 	return opt.Cacher.Delete(ctx, opt.Key)
 }
 
-func getCacheOption(ent Cacheable) (CacheOption, error) {
+func getCacheOption(ctx context.Context, ent Cacheable) (CacheOption, error) {
 	opt := ent.CacheOption()
 
 	if opt.Cacher == nil {
@@ -135,6 +156,12 @@ func getCacheOption(ent Cacheable) (CacheOption, error) {
 	if opt.Key == "" {
 		return opt, fmt.Errorf("empty cache key")
 	}
+	if tenant, ok := cacheTenant(ent); ok {
+		opt.Key = fmt.Sprintf("tenant:%v:%s", tenant, opt.Key)
+	}
+	if ns := cacheNamespace(ctx, ent); ns != "" {
+		opt.Key = ns + ":" + opt.Key
+	}
 
 	if opt.Expiration == 0 {
 		opt.Expiration = 5 * time.Minute
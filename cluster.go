@@ -0,0 +1,467 @@
+package entity
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	// interface assert
+	_ DB              = (*ClusterDB)(nil)
+	_ TxInitiator[Tx] = (*ClusterDB)(nil)
+)
+
+// Replica is a read replica of ClusterDB.
+type Replica struct {
+	db DB
+
+	// unhealthy is 1 if the last health check failed
+	unhealthy int32
+	// latency is the moving average of the durations of reads and health checks, in nanoseconds
+	latency int64
+}
+
+// DB returns the database of replica.
+func (r *Replica) DB() DB {
+	return r.db
+}
+
+// Healthy reports whether the last health check succeeded, replicas are healthy until checked.
+func (r *Replica) Healthy() bool {
+	return atomic.LoadInt32(&r.unhealthy) == 0
+}
+
+// Latency returns the moving average latency of replica, zero if not measured yet.
+func (r *Replica) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&r.latency))
+}
+
+func (r *Replica) observeLatency(d time.Duration) {
+	for {
+		old := atomic.LoadInt64(&r.latency)
+		v := int64(d)
+		if old > 0 {
+			// exponentially weighted moving average
+			v = old + (int64(d)-old)/5
+		}
+		if atomic.CompareAndSwapInt64(&r.latency, old, v) {
+			return
+		}
+	}
+}
+
+// ReplicaBalancer picks a replica from the healthy replicas for a read, replicas is never empty.
+type ReplicaBalancer func(replicas []*Replica) *Replica
+
+// RoundRobin picks the replicas in turn.
+func RoundRobin() ReplicaBalancer {
+	var next uint32
+	return func(replicas []*Replica) *Replica {
+		i := atomic.AddUint32(&next, 1) - 1
+		return replicas[int(i%uint32(len(replicas)))]
+	}
+}
+
+// LeastLatency picks the replica with the least moving average latency, the unmeasured ones are picked first.
+func LeastLatency() ReplicaBalancer {
+	return func(replicas []*Replica) *Replica {
+		picked := replicas[0]
+		for _, r := range replicas[1:] {
+			if r.Latency() < picked.Latency() {
+				picked = r
+			}
+		}
+		return picked
+	}
+}
+
+// ClusterOption is an option for NewClusterDB.
+type ClusterOption func(*ClusterDB)
+
+// WithReplicaBalancer sets the balancer of replicas, default RoundRobin.
+func WithReplicaBalancer(balancer ReplicaBalancer) ClusterOption {
+	return func(c *ClusterDB) {
+		c.balancer = balancer
+	}
+}
+
+// WithReadYourWritesWindow sets how long the reads go to the primary after a write
+// in a context returned by WithReadYourWrites, default 1 second. It should cover the replication lag.
+func WithReadYourWritesWindow(d time.Duration) ClusterOption {
+	return func(c *ClusterDB) {
+		c.readYourWritesWindow = d
+	}
+}
+
+// WithHealthCheck sets the function checking the health of replicas, default pinging the database.
+func WithHealthCheck(check func(ctx context.Context, db DB) error) ClusterOption {
+	return func(c *ClusterDB) {
+		c.healthCheck = check
+	}
+}
+
+// ClusterDB is a DB routing reads to replicas and everything else to the primary.
+//
+// Only SELECT statements without locking clauses, executed by the Query*, Get*, Select* and NamedQuery methods,
+// are routed to replicas, so Load, GetRecord(s), QueryBy and Repository reads go to replicas,
+// while writes, including the ones with RETURNING, prepared statements and transactions go to the primary.
+// Reads go to the primary too, if the context is marked by ForcePrimary, or there is a write in the
+// read-your-writes window of the context returned by WithReadYourWrites, or no replica is healthy.
+type ClusterDB struct {
+	primary  DB
+	replicas []*Replica
+
+	balancer             ReplicaBalancer
+	readYourWritesWindow time.Duration
+	healthCheck          func(ctx context.Context, db DB) error
+}
+
+// NewClusterDB creates a ClusterDB, primary should be a pool implementing TxInitiator to begin transactions.
+func NewClusterDB(primary DB, replicas []DB, opts ...ClusterOption) *ClusterDB {
+	c := &ClusterDB{
+		primary:              primary,
+		balancer:             RoundRobin(),
+		readYourWritesWindow: time.Second,
+		healthCheck:          ping,
+	}
+	for _, db := range replicas {
+		c.replicas = append(c.replicas, &Replica{db: db})
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Primary returns the primary database.
+func (c *ClusterDB) Primary() DB {
+	return c.primary
+}
+
+// Replicas returns the replicas.
+func (c *ClusterDB) Replicas() []*Replica {
+	return c.replicas
+}
+
+// RunHealthCheck checks the health of replicas every interval, until ctx is done.
+func (c *ClusterDB) RunHealthCheck(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.CheckHealth(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckHealth checks the health of all the replicas once.
+func (c *ClusterDB) CheckHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, r := range c.replicas {
+		wg.Add(1)
+		go func(r *Replica) {
+			defer wg.Done()
+
+			start := time.Now()
+			if err := c.healthCheck(ctx, r.db); err != nil {
+				atomic.StoreInt32(&r.unhealthy, 1)
+				return
+			}
+
+			r.observeLatency(time.Since(start))
+			atomic.StoreInt32(&r.unhealthy, 0)
+		}(r)
+	}
+	wg.Wait()
+}
+
+func ping(ctx context.Context, db DB) error {
+	if v, ok := db.(interface{ PingContext(context.Context) error }); ok {
+		return v.PingContext(ctx)
+	}
+
+	var n int
+	return db.QueryRowxContext(ctx, "SELECT 1").Scan(&n)
+}
+
+type forcePrimaryKey struct{}
+
+// ForcePrimary returns a copy of ctx with which ClusterDB reads from the primary.
+func ForcePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcePrimaryKey{}, true)
+}
+
+type readYourWritesKey struct{}
+
+type writeTracker struct {
+	// lastWrite is the unix nano time of the last write
+	lastWrite int64
+}
+
+// WithReadYourWrites returns a copy of ctx tracking the writes through ClusterDB, usually for a request,
+// the reads with it go to the primary during the read-your-writes window after a write.
+func WithReadYourWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readYourWritesKey{}, &writeTracker{})
+}
+
+// wrote records a write in the context.
+func wrote(ctx context.Context) {
+	if t, ok := ctx.Value(readYourWritesKey{}).(*writeTracker); ok {
+		atomic.StoreInt64(&t.lastWrite, time.Now().UnixNano())
+	}
+}
+
+// reader returns the database to read query with, the replica is nil if it's the primary.
+func (c *ClusterDB) reader(ctx context.Context, query string) (DB, *Replica) {
+	if len(c.replicas) == 0 || !isReadStatement(query) {
+		return c.primary, nil
+	} else if v, _ := ctx.Value(forcePrimaryKey{}).(bool); v {
+		return c.primary, nil
+	} else if t, ok := ctx.Value(readYourWritesKey{}).(*writeTracker); ok {
+		if last := atomic.LoadInt64(&t.lastWrite); last > 0 && time.Since(time.Unix(0, last)) < c.readYourWritesWindow {
+			return c.primary, nil
+		}
+	}
+
+	healthy := make([]*Replica, 0, len(c.replicas))
+	for _, r := range c.replicas {
+		if r.Healthy() {
+			healthy = append(healthy, r)
+		}
+	}
+	if len(healthy) == 0 {
+		return c.primary, nil
+	}
+
+	r := c.balancer(healthy)
+	return r.db, r
+}
+
+// read routes the query and measures the latency of replica,
+// the query not reading only, such as INSERT ... RETURNING, is recorded as a write of ctx.
+func (c *ClusterDB) read(ctx context.Context, query string, fn func(db DB) error) error {
+	if !isReadStatement(query) {
+		defer wrote(ctx)
+	}
+
+	db, replica := c.reader(ctx, query)
+	if replica == nil {
+		return fn(db)
+	}
+
+	start := time.Now()
+	err := fn(db)
+	if err == nil {
+		replica.observeLatency(time.Since(start))
+	}
+	return err
+}
+
+// isReadStatement reports whether the statement is a SELECT without locking clauses.
+func isReadStatement(query string) bool {
+	q := strings.TrimSpace(query)
+	for {
+		if strings.HasPrefix(q, "/*") {
+			i := strings.Index(q, "*/")
+			if i < 0 {
+				return false
+			}
+			q = strings.TrimSpace(q[i+2:])
+		} else if strings.HasPrefix(q, "--") {
+			i := strings.Index(q, "\n")
+			if i < 0 {
+				return false
+			}
+			q = strings.TrimSpace(q[i+1:])
+		} else {
+			break
+		}
+	}
+
+	if len(q) < 6 || !strings.EqualFold(q[:6], "SELECT") {
+		return false
+	}
+
+	upper := strings.ToUpper(q)
+	for _, lock := range []string{"FOR UPDATE", "FOR SHARE", "FOR NO KEY UPDATE", "FOR KEY SHARE", "LOCK IN SHARE MODE"} {
+		if strings.Contains(upper, lock) {
+			return false
+		}
+	}
+	return true
+}
+
+// Query implements DB.
+func (c *ClusterDB) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.QueryContext(context.Background(), query, args...)
+}
+
+// QueryContext implements DB.
+func (c *ClusterDB) QueryContext(ctx context.Context, query string, args ...any) (rows *sql.Rows, err error) {
+	err = c.read(ctx, query, func(db DB) error {
+		rows, err = db.QueryContext(ctx, query, args...)
+		return err
+	})
+	return
+}
+
+// Queryx implements DB.
+func (c *ClusterDB) Queryx(query string, args ...any) (*sqlx.Rows, error) {
+	return c.QueryxContext(context.Background(), query, args...)
+}
+
+// QueryxContext implements DB.
+func (c *ClusterDB) QueryxContext(ctx context.Context, query string, args ...any) (rows *sqlx.Rows, err error) {
+	err = c.read(ctx, query, func(db DB) error {
+		rows, err = db.QueryxContext(ctx, query, args...)
+		return err
+	})
+	return
+}
+
+// QueryRowx implements DB.
+func (c *ClusterDB) QueryRowx(query string, args ...any) *sqlx.Row {
+	return c.QueryRowxContext(context.Background(), query, args...)
+}
+
+// QueryRowxContext implements DB.
+func (c *ClusterDB) QueryRowxContext(ctx context.Context, query string, args ...any) (row *sqlx.Row) {
+	_ = c.read(ctx, query, func(db DB) error {
+		row = db.QueryRowxContext(ctx, query, args...)
+		return row.Err()
+	})
+	return
+}
+
+// Get implements DB.
+func (c *ClusterDB) Get(dest any, query string, args ...any) error {
+	return c.GetContext(context.Background(), dest, query, args...)
+}
+
+// GetContext implements DB.
+func (c *ClusterDB) GetContext(ctx context.Context, dest any, query string, args ...any) error {
+	return c.read(ctx, query, func(db DB) error {
+		return db.GetContext(ctx, dest, query, args...)
+	})
+}
+
+// Select implements DB.
+func (c *ClusterDB) Select(dest any, query string, args ...any) error {
+	return c.SelectContext(context.Background(), dest, query, args...)
+}
+
+// SelectContext implements DB.
+func (c *ClusterDB) SelectContext(ctx context.Context, dest any, query string, args ...any) error {
+	return c.read(ctx, query, func(db DB) error {
+		return db.SelectContext(ctx, dest, query, args...)
+	})
+}
+
+// NamedQuery implements DB.
+func (c *ClusterDB) NamedQuery(query string, arg any) (rows *sqlx.Rows, err error) {
+	err = c.read(context.Background(), query, func(db DB) error {
+		rows, err = db.NamedQuery(query, arg)
+		return err
+	})
+	return
+}
+
+// Exec implements DB.
+func (c *ClusterDB) Exec(query string, args ...any) (sql.Result, error) {
+	return c.primary.Exec(query, args...)
+}
+
+// ExecContext implements DB.
+func (c *ClusterDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	defer wrote(ctx)
+	return c.primary.ExecContext(ctx, query, args...)
+}
+
+// NamedExec implements DB.
+func (c *ClusterDB) NamedExec(query string, arg any) (sql.Result, error) {
+	return c.primary.NamedExec(query, arg)
+}
+
+// NamedExecContext implements DB.
+func (c *ClusterDB) NamedExecContext(ctx context.Context, query string, arg any) (sql.Result, error) {
+	defer wrote(ctx)
+	return c.primary.NamedExecContext(ctx, query, arg)
+}
+
+// Prepare implements DB.
+func (c *ClusterDB) Prepare(query string) (*sql.Stmt, error) {
+	return c.primary.Prepare(query)
+}
+
+// PrepareContext implements DB.
+func (c *ClusterDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return c.primary.PrepareContext(ctx, query)
+}
+
+// Preparex implements DB.
+func (c *ClusterDB) Preparex(query string) (*sqlx.Stmt, error) {
+	return c.primary.Preparex(query)
+}
+
+// PreparexContext implements DB.
+func (c *ClusterDB) PreparexContext(ctx context.Context, query string) (*sqlx.Stmt, error) {
+	return c.primary.PreparexContext(ctx, query)
+}
+
+// PrepareNamed implements DB.
+func (c *ClusterDB) PrepareNamed(query string) (*sqlx.NamedStmt, error) {
+	return c.primary.PrepareNamed(query)
+}
+
+// PrepareNamedContext implements DB.
+func (c *ClusterDB) PrepareNamedContext(ctx context.Context, query string) (*sqlx.NamedStmt, error) {
+	return c.primary.PrepareNamedContext(ctx, query)
+}
+
+// DriverName implements DB.
+func (c *ClusterDB) DriverName() string {
+	return c.primary.DriverName()
+}
+
+// Rebind implements DB.
+func (c *ClusterDB) Rebind(query string) string {
+	return c.primary.Rebind(query)
+}
+
+// BindNamed implements DB.
+func (c *ClusterDB) BindNamed(query string, arg any) (string, []any, error) {
+	return c.primary.BindNamed(query, arg)
+}
+
+// BeginTxx begins a transaction on the primary, the commit is recorded as a write of ctx.
+func (c *ClusterDB) BeginTxx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	tx, err := beginTx(ctx, c.primary, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &clusterTx{Tx: tx, ctx: ctx}, nil
+}
+
+type clusterTx struct {
+	Tx
+
+	ctx context.Context
+}
+
+func (t *clusterTx) Commit() error {
+	defer wrote(t.ctx)
+	return t.Tx.Commit()
+}
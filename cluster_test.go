@@ -0,0 +1,118 @@
+package entity
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestIsReadStatement(t *testing.T) {
+	cases := map[string]bool{
+		`SELECT * FROM users WHERE id = $1`:                  true,
+		` select id from users`:                              true,
+		`/*app='api'*/ SELECT 1`:                             true,
+		"-- comment\nSELECT 1":                               true,
+		`SELECT * FROM users WHERE id = $1 FOR UPDATE`:       false,
+		`SELECT * FROM users FOR SHARE`:                      false,
+		`SELECT * FROM users LOCK IN SHARE MODE`:             false,
+		`INSERT INTO users (name) VALUES ($1) RETURNING id`:  false,
+		`UPDATE users SET name = $1`:                         false,
+		`WITH t AS (DELETE FROM users RETURNING *) SELECT 1`: false,
+		`/* unclosed SELECT 1`:                               false,
+	}
+
+	for stmt, expected := range cases {
+		if actual := isReadStatement(stmt); actual != expected {
+			t.Fatalf("isReadStatement(%q), Expected=%v, Actual=%v", stmt, expected, actual)
+		}
+	}
+}
+
+func TestReplicaBalancer(t *testing.T) {
+	replicas := []*Replica{{}, {}, {}}
+
+	rr := RoundRobin()
+	for i := 0; i < 6; i++ {
+		if r := rr(replicas); r != replicas[i%3] {
+			t.Fatalf("round robin %d, Expected=%p, Actual=%p", i, replicas[i%3], r)
+		}
+	}
+
+	replicas[0].observeLatency(30 * time.Millisecond)
+	replicas[1].observeLatency(10 * time.Millisecond)
+	replicas[2].observeLatency(20 * time.Millisecond)
+	if r := LeastLatency()(replicas); r != replicas[1] {
+		t.Fatalf("least latency, Expected=%v, Actual=%v", replicas[1].Latency(), r.Latency())
+	}
+}
+
+func TestClusterDB(t *testing.T) {
+	errPrimary := errors.New("primary")
+	errReplica := errors.New("replica")
+
+	primary := sqlx.NewDb(sql.OpenDB(errConnector{err: errPrimary}), driverPostgres)
+	defer primary.Close()
+	replica := sqlx.NewDb(sql.OpenDB(errConnector{err: errReplica}), driverPostgres)
+	defer replica.Close()
+
+	var healthy int32 = 1
+	db := NewClusterDB(primary, []DB{replica},
+		WithReadYourWritesWindow(time.Hour),
+		WithHealthCheck(func(context.Context, DB) error {
+			if atomic.LoadInt32(&healthy) == 0 {
+				return errReplica
+			}
+			return nil
+		}),
+	)
+
+	routed := func(ctx context.Context, query string) error {
+		var id int
+		return db.GetContext(ctx, &id, query)
+	}
+	expect := func(name string, err, expected error) {
+		t.Helper()
+		if !errors.Is(err, expected) {
+			t.Fatalf("%s, Expected=%v, Actual=%v", name, expected, err)
+		}
+	}
+
+	ctx := context.Background()
+	expect("read", routed(ctx, "SELECT 1"), errReplica)
+	expect("row read", db.QueryRowxContext(ctx, "SELECT 1").Err(), errReplica)
+	expect("locking read", routed(ctx, "SELECT 1 FOR UPDATE"), errPrimary)
+	expect("force primary", routed(ForcePrimary(ctx), "SELECT 1"), errPrimary)
+
+	_, err := db.QueryxContext(ctx, "INSERT INTO users (name) VALUES ('a') RETURNING id")
+	expect("insert returning", err, errPrimary)
+	_, err = db.ExecContext(ctx, "DELETE FROM users")
+	expect("exec", err, errPrimary)
+	_, err = db.BeginTxx(ctx, nil)
+	expect("begin", err, errPrimary)
+
+	rywCtx := WithReadYourWrites(ctx)
+	expect("read before write", routed(rywCtx, "SELECT 1"), errReplica)
+	_, _ = db.ExecContext(rywCtx, "DELETE FROM users")
+	expect("read after write", routed(rywCtx, "SELECT 1"), errPrimary)
+	expect("read of other request", routed(ctx, "SELECT 1"), errReplica)
+
+	getCtx := WithReadYourWrites(ctx)
+	expect("insert returning by get", routed(getCtx, "INSERT INTO users (name) VALUES ('a') RETURNING id"), errPrimary)
+	expect("read after insert returning", routed(getCtx, "SELECT 1"), errPrimary)
+
+	atomic.StoreInt32(&healthy, 0)
+	db.CheckHealth(ctx)
+	if db.Replicas()[0].Healthy() {
+		t.Fatal("replica should be unhealthy")
+	}
+	expect("read without healthy replica", routed(ctx, "SELECT 1"), errPrimary)
+
+	atomic.StoreInt32(&healthy, 1)
+	db.CheckHealth(ctx)
+	expect("read after recovery", routed(ctx, "SELECT 1"), errReplica)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"reflect"
 	"strings"
 	"sync"
 
@@ -11,11 +12,12 @@ import (
 )
 
 const (
-	commandSelect = "select"
-	commandInsert = "insert"
-	commandUpdate = "update"
-	commandUpsert = "upsert"
-	commandDelete = "delete"
+	commandSelect       = "select"
+	commandInsert       = "insert"
+	commandInsertIgnore = "insert_ignore"
+	commandUpdate       = "update"
+	commandUpsert       = "upsert"
+	commandDelete       = "delete"
 
 	driverMysql    = "mysql"
 	driverPostgres = "postgres"
@@ -95,22 +97,30 @@ func isConflictError(err error, driver string) bool {
 	return false
 }
 
-func doLoad(ctx context.Context, ent Entity, db DB) error {
-	md, err := getMetadata(ent)
+func doLoad(ctx context.Context, ent Entity, db DB) (err error) {
+	md, err := getTableMetadata(ctx, ent)
 	if err != nil {
 		return fmt.Errorf("get metadata, %w", err)
 	}
 
 	stmt := getStatement(commandSelect, md, dbDriver(db))
-	rows, err := sqlx.NamedQueryContext(ctx, db, stmt, ent)
+	observeStatement(ctx, stmt)
+	ctx = startStatementLog(ctx, stmt, namedArgs(md, stmt, ent))
+	defer func() {
+		endStatementLog(ctx, err)
+	}()
+
+	rows, err := sqlx.NamedQueryContext(ctx, db, commentStatement(ctx, stmt), ent)
 	if err != nil {
 		return err
 	}
 	defer rows.Close()
 
 	if !rows.Next() {
+		observeRows(ctx, 0)
 		return sql.ErrNoRows
 	}
+	observeRows(ctx, 1)
 
 	if err := rows.StructScan(ent); err != nil {
 		return fmt.Errorf("scan struct, %w", err)
@@ -119,23 +129,30 @@ func doLoad(ctx context.Context, ent Entity, db DB) error {
 	return rows.Err()
 }
 
-func doInsert(ctx context.Context, ent Entity, db DB) (int64, error) {
-	md, err := getMetadata(ent)
+func doInsert(ctx context.Context, ent Entity, db DB) (lastID int64, err error) {
+	md, err := getTableMetadata(ctx, ent)
 	if err != nil {
 		return 0, fmt.Errorf("get metadata, %w", err)
 	}
 
 	stmt := getStatement(commandInsert, md, dbDriver(db))
+	observeStatement(ctx, stmt)
+	ctx = startStatementLog(ctx, stmt, namedArgs(md, stmt, ent))
+	defer func() {
+		endStatementLog(ctx, err)
+	}()
 	if md.hasReturningInsert {
-		rows, err := sqlx.NamedQueryContext(ctx, db, stmt, ent)
+		rows, err := sqlx.NamedQueryContext(ctx, db, commentStatement(ctx, stmt), ent)
 		if err != nil {
 			return 0, err
 		}
 		defer rows.Close()
 
 		if !rows.Next() {
+			observeRows(ctx, 0)
 			return 0, sql.ErrNoRows
 		}
+		observeRows(ctx, 1)
 
 		if err := rows.StructScan(ent); err != nil {
 			return 0, fmt.Errorf("scan struct, %w", err)
@@ -144,40 +161,97 @@ func doInsert(ctx context.Context, ent Entity, db DB) (int64, error) {
 		return 0, rows.Err()
 	}
 
-	result, err := db.NamedExecContext(ctx, stmt, ent)
+	result, err := db.NamedExecContext(ctx, commentStatement(ctx, stmt), ent)
 	if err != nil {
 		return 0, err
 	}
+	observeResult(ctx, result)
 
 	// PostgreSQL does not support the LastInsertId feature.
 	if dbDriver(db) == driverPostgres {
 		return 0, nil
 	}
 
-	lastID, err := result.LastInsertId()
+	lastID, err = result.LastInsertId()
 	if err != nil {
 		return 0, fmt.Errorf("get last insert id, %w", err)
 	}
 	return lastID, nil
 }
 
-func doUpdate(ctx context.Context, ent Entity, db DB) error {
-	md, err := getMetadata(ent)
+func doInsertIgnore(ctx context.Context, ent Entity, db DB) (inserted bool, err error) {
+	md, err := getTableMetadata(ctx, ent)
+	if err != nil {
+		return false, fmt.Errorf("get metadata, %w", err)
+	}
+
+	stmt := getStatement(commandInsertIgnore, md, dbDriver(db))
+	observeStatement(ctx, stmt)
+	ctx = startStatementLog(ctx, stmt, namedArgs(md, stmt, ent))
+	defer func() {
+		endStatementLog(ctx, err)
+	}()
+	if md.hasReturningInsert {
+		rows, err := sqlx.NamedQueryContext(ctx, db, commentStatement(ctx, stmt), ent)
+		if err != nil {
+			return false, err
+		}
+		defer rows.Close()
+
+		// nothing returned when the record already exists
+		if !rows.Next() {
+			observeRows(ctx, 0)
+			return false, rows.Err()
+		}
+		observeRows(ctx, 1)
+
+		if err := rows.StructScan(ent); err != nil {
+			return false, fmt.Errorf("scan struct, %w", err)
+		}
+
+		return true, rows.Err()
+	}
+
+	result, err := db.NamedExecContext(ctx, commentStatement(ctx, stmt), ent)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("get affected rows, %w", err)
+	}
+	observeRows(ctx, n)
+	return n > 0, nil
+}
+
+func doUpdate(ctx context.Context, ent Entity, db DB) (err error) {
+	md, err := getTableMetadata(ctx, ent)
 	if err != nil {
 		return fmt.Errorf("get metadata, %w", err)
 	}
 
 	stmt := getStatement(commandUpdate, md, dbDriver(db))
+	observeStatement(ctx, stmt)
+	ctx = startStatementLog(ctx, stmt, namedArgs(md, stmt, ent))
+	defer func() {
+		endStatementLog(ctx, err)
+	}()
 	if md.hasReturningUpdate {
-		rows, err := sqlx.NamedQueryContext(ctx, db, stmt, ent)
+		rows, err := sqlx.NamedQueryContext(ctx, db, commentStatement(ctx, stmt), ent)
 		if err != nil {
 			return err
 		}
 		defer rows.Close()
 
 		if !rows.Next() {
+			observeRows(ctx, 0)
+			if md.version != nil {
+				return ErrConflict
+			}
 			return sql.ErrNoRows
 		}
+		observeRows(ctx, 1)
 
 		if err := rows.StructScan(ent); err != nil {
 			return fmt.Errorf("scan struct, %w", err)
@@ -186,12 +260,47 @@ func doUpdate(ctx context.Context, ent Entity, db DB) error {
 		return rows.Err()
 	}
 
-	_, err = db.NamedExecContext(ctx, stmt, ent)
-	return err
+	result, err := db.NamedExecContext(ctx, commentStatement(ctx, stmt), ent)
+	if err != nil {
+		return err
+	}
+
+	observeResult(ctx, result)
+	if md.version == nil {
+		return nil
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("get affected rows, %w", err)
+	} else if n == 0 {
+		return ErrConflict
+	}
+	increaseVersion(md, ent)
+	return nil
+}
+
+// increaseVersion increases the version of entity after updated without RETURNING,
+// the version is returned with the other columns otherwise.
+func increaseVersion(md *Metadata, ent Entity) {
+	if md.version == nil || md.version.ReturningUpdate {
+		return
+	}
+
+	v, valid := indirectValue(fieldByColumn(reflect.ValueOf(ent), md.version.DBField))
+	if !valid || !v.CanSet() {
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(v.Int() + 1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(v.Uint() + 1)
+	}
 }
 
-func doUpsert(ctx context.Context, ent Entity, db DB) error {
-	md, err := getMetadata(ent)
+func doUpsert(ctx context.Context, ent Entity, db DB) (err error) {
+	md, err := getTableMetadata(ctx, ent)
 	if err != nil {
 		return fmt.Errorf("get metadata, %w", err)
 	}
@@ -202,21 +311,47 @@ func doUpsert(ctx context.Context, ent Entity, db DB) error {
 		}
 	}
 
-	stmt := getStatement(commandUpsert, md, dbDriver(db))
+	driver := dbDriver(db)
+	stmt := getStatement(commandUpsert, md, driver)
+	observeStatement(ctx, stmt)
+	ctx = startStatementLog(ctx, stmt, namedArgs(md, stmt, ent))
+	defer func() {
+		endStatementLog(ctx, err)
+	}()
 	if !md.hasReturningInsert && !md.hasReturningUpdate {
-		_, err := db.NamedExecContext(ctx, stmt, ent)
-		return err
+		result, err := db.NamedExecContext(ctx, commentStatement(ctx, stmt), ent)
+		if err != nil {
+			return err
+		}
+		observeResult(ctx, result)
+
+		if tenantGuarded(md, driver) {
+			n, err := result.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("get affected rows, %w", err)
+			} else if n == 0 {
+				return ErrTenantConflict
+			}
+		}
+		return nil
 	}
 
-	rows, err := sqlx.NamedQueryContext(ctx, db, stmt, ent)
+	rows, err := sqlx.NamedQueryContext(ctx, db, commentStatement(ctx, stmt), ent)
 	if err != nil {
 		return err
 	}
 	defer rows.Close()
 
 	if !rows.Next() {
+		observeRows(ctx, 0)
+		if err := rows.Err(); err != nil {
+			return err
+		} else if tenantGuarded(md, driver) {
+			return ErrTenantConflict
+		}
 		return sql.ErrNoRows
 	}
+	observeRows(ctx, 1)
 
 	if err := rows.StructScan(ent); err != nil {
 		return fmt.Errorf("scan struct, %w", err)
@@ -225,23 +360,43 @@ func doUpsert(ctx context.Context, ent Entity, db DB) error {
 	return rows.Err()
 }
 
-func doDelete(ctx context.Context, ent Entity, db DB) error {
-	md, err := getMetadata(ent)
+func doDelete(ctx context.Context, ent Entity, db DB) (err error) {
+	md, err := getTableMetadata(ctx, ent)
 	if err != nil {
 		return fmt.Errorf("get metadata, %w", err)
 	}
 
 	stmt := getStatement(commandDelete, md, dbDriver(db))
-	_, err = db.NamedExecContext(ctx, stmt, ent)
-	return err
+	observeStatement(ctx, stmt)
+	ctx = startStatementLog(ctx, stmt, namedArgs(md, stmt, ent))
+	defer func() {
+		endStatementLog(ctx, err)
+	}()
+
+	result, err := db.NamedExecContext(ctx, commentStatement(ctx, stmt), ent)
+	if err != nil {
+		return err
+	}
+
+	observeResult(ctx, result)
+	return nil
 }
 
+// tablePlaceholder is the table name of the cached statements, it's replaced by the table of entity when used,
+// so the entities of dynamic table name, such as the ones of schema per tenant, share the statements of type.
+const tablePlaceholder = "\x00table\x00"
+
 func getStatement(cmd string, md *Metadata, driver string) string {
 	key := fmt.Sprintf("%s(%s.%s@%s)", cmd, md.Type.PkgPath(), md.Type.Name(), driver)
-	if v, ok := statements.Load(key); ok {
-		return v.(string)
+	v, ok := statements.Load(key)
+	if !ok {
+		v = newStatement(cmd, md, driver)
+		statements.Store(key, v)
 	}
+	return strings.ReplaceAll(v.(string), quoteIdentifier(tablePlaceholder, driver), quoteIdentifier(md.TableName, driver))
+}
 
+func newStatement(cmd string, md *Metadata, driver string) string {
 	var fn func(*Metadata, string) string
 
 	switch cmd {
@@ -249,6 +404,8 @@ func getStatement(cmd string, md *Metadata, driver string) string {
 		fn = newSelectStatement
 	case commandInsert:
 		fn = newInsertStatement
+	case commandInsertIgnore:
+		fn = newInsertIgnoreStatement
 	case commandUpdate:
 		fn = newUpdateStatement
 	case commandUpsert:
@@ -259,9 +416,9 @@ func getStatement(cmd string, md *Metadata, driver string) string {
 		panic(fmt.Errorf("unimplemented command %q", cmd))
 	}
 
-	stmt := fn(md, driver)
-	statements.Store(key, stmt)
-	return stmt
+	v := *md
+	v.TableName = tablePlaceholder
+	return fn(&v, driver)
 }
 
 func newSelectStatement(md *Metadata, driver string) string {
@@ -278,12 +435,22 @@ func newSelectStatement(md *Metadata, driver string) string {
 			stmt += fmt.Sprintf(" AND %s = :%s", quoteColumn(col.DBField, driver), col.DBField)
 		}
 	}
+	stmt += tenantWhere(md, driver)
 	stmt += " LIMIT 1"
 
 	return stmt
 }
 
 func newInsertStatement(md *Metadata, driver string) string {
+	return buildInsertStatement(md, driver, false)
+}
+
+// newInsertIgnoreStatement builds an insert statement that does nothing when the record already exists.
+func newInsertIgnoreStatement(md *Metadata, driver string) string {
+	return buildInsertStatement(md, driver, true)
+}
+
+func buildInsertStatement(md *Metadata, driver string, ignore bool) string {
 	columns := []string{}
 	returnings := []string{}
 	placeholder := []string{}
@@ -305,6 +472,17 @@ func newInsertStatement(md *Metadata, driver string) string {
 		strings.Join(placeholder, ", "),
 	)
 
+	if ignore {
+		if driver == driverMysql {
+			// INSERT IGNORE downgrades the errors of NOT NULL, truncation and foreign key to warnings,
+			// a no-op update only ignores the duplicate key, and reports 0 affected rows without CLIENT_FOUND_ROWS
+			pk := quoteColumn(md.PrimaryKeys[0].DBField, driver)
+			stmt += fmt.Sprintf(" ON DUPLICATE KEY UPDATE %s = %s", pk, pk)
+		} else {
+			stmt += " ON CONFLICT DO NOTHING"
+		}
+	}
+
 	if len(returnings) > 0 {
 		stmt += fmt.Sprintf(" RETURNING %s", strings.Join(returnings, ", "))
 	}
@@ -330,6 +508,20 @@ func newUpdateStatement(md *Metadata, driver string) string {
 		}
 	}
 
+	if v := md.version; v != nil {
+		column := quoteColumn(v.DBField, driver)
+		if set {
+			stmt += fmt.Sprintf(", %s = %s + 1", column, column)
+		} else {
+			stmt += fmt.Sprintf(" %s = %s + 1", column, column)
+		}
+
+		// the returned row is scanned instead of increasing the version of entity
+		if len(returnings) > 0 && !v.ReturningUpdate {
+			returnings = append(returnings, column)
+		}
+	}
+
 	for i, col := range md.PrimaryKeys {
 		if i == 0 {
 			stmt += fmt.Sprintf(" WHERE %s = :%s", quoteColumn(col.DBField, driver), col.DBField)
@@ -338,6 +530,11 @@ func newUpdateStatement(md *Metadata, driver string) string {
 		}
 	}
 
+	if v := md.version; v != nil {
+		stmt += fmt.Sprintf(" AND %s = :%s", quoteColumn(v.DBField, driver), v.DBField)
+	}
+	stmt += tenantWhere(md, driver)
+
 	if len(returnings) > 0 {
 		stmt += fmt.Sprintf(" RETURNING %s", strings.Join(returnings, ", "))
 	}
@@ -351,6 +548,15 @@ func newUpsertStatement(md *Metadata, driver string) string {
 	updateStmt := []string{}
 	returningColumns := []string{}
 
+	// the rows of other tenants are not updated on conflict,
+	// mysql doesn't support the WHERE of update, so every column keeps its value
+	guard := func(column, value string) string {
+		if md.tenant == nil || md.tenant.PrimaryKey || driver != driverMysql {
+			return value
+		}
+		return fmt.Sprintf("IF(%s = :%s, %s, %s)", quoteColumn(md.tenant.DBField, driver), md.tenant.DBField, value, column)
+	}
+
 	for _, v := range md.Columns {
 		column := quoteColumn(v.DBField, driver)
 		placeholder := fmt.Sprintf(":%s", v.DBField)
@@ -361,7 +567,9 @@ func newUpsertStatement(md *Metadata, driver string) string {
 		}
 
 		if !v.PrimaryKey && !v.RefuseUpdate && !v.ReturningUpdate {
-			updateStmt = append(updateStmt, fmt.Sprintf("%s = %s", column, placeholder))
+			updateStmt = append(updateStmt, fmt.Sprintf("%s = %s", column, guard(column, placeholder)))
+		} else if v.Version {
+			updateStmt = append(updateStmt, fmt.Sprintf("%s = %s", column, guard(column, column+" + 1")))
 		}
 
 		if v.ReturningInsert || v.ReturningUpdate {
@@ -385,6 +593,11 @@ func newUpsertStatement(md *Metadata, driver string) string {
 		}
 
 		stmt += fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(target, ", "), strings.Join(updateStmt, ", "))
+
+		if t := md.tenant; t != nil && !t.PrimaryKey {
+			column := quoteColumn(t.DBField, driver)
+			stmt += fmt.Sprintf(" WHERE %s.%s = EXCLUDED.%s", quoteIdentifier(md.TableName, driver), column, column)
+		}
 	}
 
 	if len(returningColumns) > 0 {
@@ -403,10 +616,19 @@ func newDeleteStatement(md *Metadata, driver string) string {
 			stmt += fmt.Sprintf(" AND %s = :%s", quoteColumn(col.DBField, driver), col.DBField)
 		}
 	}
+	stmt += tenantWhere(md, driver)
 
 	return stmt
 }
 
+// tenantWhere returns the condition of tenant column appended to the WHERE of primary keys.
+func tenantWhere(md *Metadata, driver string) string {
+	if md.tenant == nil || md.tenant.PrimaryKey {
+		return ""
+	}
+	return fmt.Sprintf(" AND %s = :%s", quoteColumn(md.tenant.DBField, driver), md.tenant.DBField)
+}
+
 func quoteColumn(name string, driver string) string {
 	if driver == driverMysql {
 		return fmt.Sprintf("`%s`", name)
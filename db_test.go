@@ -3,6 +3,7 @@ package entity
 import (
 	"sort"
 	"testing"
+	"time"
 )
 
 func TestStatement(t *testing.T) {
@@ -39,6 +40,22 @@ func TestStatement(t *testing.T) {
 			}
 		})
 
+		t.Run("insertIgnore", func(t *testing.T) {
+			md, _ := newTestMetadata(&GenernalEntity{})
+
+			stmt := newInsertIgnoreStatement(md, driverMysql)
+			expected := "INSERT INTO `genernal` (`extra`, `id2`, `name`) VALUES (:extra, :id2, :name) ON DUPLICATE KEY UPDATE `id` = `id` RETURNING `create_at`, `version`"
+			if stmt != expected {
+				t.Fatalf("GenernalEntity, Expected=%s, Actual=%s", expected, stmt)
+			}
+
+			stmt = newInsertIgnoreStatement(md, driverPostgres)
+			expected = `INSERT INTO "genernal" ("extra", "id2", "name") VALUES (:extra, :id2, :name) ON CONFLICT DO NOTHING RETURNING "create_at", "version"`
+			if stmt != expected {
+				t.Fatalf("GenernalEntity, Expected=%s, Actual=%s", expected, stmt)
+			}
+		})
+
 		t.Run("update", func(t *testing.T) {
 			md, _ := newTestMetadata(&GenernalEntity{})
 
@@ -71,6 +88,34 @@ func TestStatement(t *testing.T) {
 			}
 		})
 
+		t.Run("version", func(t *testing.T) {
+			md, _ := newTestMetadata(&versionedEntity{})
+
+			stmt := newUpdateStatement(md, driverPostgres)
+			expected := `UPDATE "versioned" SET "name" = :name, "version" = "version" + 1 WHERE "id" = :id AND "version" = :version`
+			if stmt != expected {
+				t.Fatalf("versionedEntity, Expected=%s, Actual=%s", expected, stmt)
+			}
+
+			stmt = newUpsertStatement(md, driverPostgres)
+			expected = `INSERT INTO "versioned" ("id", "name", "version") VALUES (:id, :name, :version) ON CONFLICT ("id") DO UPDATE SET "name" = :name, "version" = "version" + 1`
+			if stmt != expected {
+				t.Fatalf("versionedEntity, Expected=%s, Actual=%s", expected, stmt)
+			}
+
+			ent := &versionedEntity{Version: 1}
+			if increaseVersion(md, ent); ent.Version != 2 {
+				t.Fatalf("increase version, Expected=2, Actual=%d", ent.Version)
+			}
+
+			md, _ = newTestMetadata(&returningVersionedEntity{})
+			stmt = newUpdateStatement(md, driverPostgres)
+			expected = `UPDATE "versioned" SET "name" = :name, "version" = "version" + 1 WHERE "id" = :id AND "version" = :version RETURNING "update_at", "version"`
+			if stmt != expected {
+				t.Fatalf("returningVersionedEntity, Expected=%s, Actual=%s", expected, stmt)
+			}
+		})
+
 		t.Run("delete", func(t *testing.T) {
 			md, _ := newTestMetadata(&GenernalEntity{})
 
@@ -91,7 +136,7 @@ func TestStatement(t *testing.T) {
 	t.Run("getStatement", func(t *testing.T) {
 		md, _ := getMetadata(&GenernalEntity{})
 
-		for _, cmd := range []string{commandSelect, commandInsert, commandUpdate, commandDelete, commandUpsert} {
+		for _, cmd := range []string{commandSelect, commandInsert, commandInsertIgnore, commandUpdate, commandDelete, commandUpsert} {
 			stmt1 := getStatement(cmd, md, driverPostgres)
 			stmt2 := getStatement(cmd, md, driverPostgres)
 
@@ -186,3 +231,18 @@ func newTestMetadata(ent Entity) (*Metadata, error) {
 
 	return md, nil
 }
+
+type versionedEntity struct {
+	ID      int64  `db:"id,primaryKey"`
+	Name    string `db:"name"`
+	Version int    `db:"version,version"`
+}
+
+func (ve *versionedEntity) TableName() string {
+	return "versioned"
+}
+
+type returningVersionedEntity struct {
+	versionedEntity
+	UpdateAt time.Time `db:"update_at,returningUpdate"`
+}
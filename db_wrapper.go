@@ -0,0 +1,312 @@
+package entity
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	// interface assert
+	_ DB              = (*wrappedDB)(nil)
+	_ TxInitiator[Tx] = (*wrappedPool)(nil)
+	_ Tx              = (*wrappedTx)(nil)
+)
+
+// DBCall describes a call of the DB returned by WrapDB.
+type DBCall struct {
+	// Method is the name of the called method, such as "QueryxContext", "NamedExecContext", "BeginTxx" and "Commit".
+	Method string
+	// Query is empty for BeginTxx, Commit and Rollback.
+	Query string
+	// Args are the arguments of query, for the Named* methods it's the single named argument.
+	Args []any
+
+	// The fields below are set before After is called.
+
+	// Result is set for the Exec* methods.
+	Result   sql.Result
+	Err      error
+	Duration time.Duration
+}
+
+// DBHooks are called around the calls of the DB returned by WrapDB.
+type DBHooks struct {
+	// Before is called before the call, the returned context is used by the call and passed to After.
+	// If it returns an error, the call is aborted with the error and After is not called,
+	// which can be used for fault injection.
+	Before func(ctx context.Context, call *DBCall) (context.Context, error)
+	// After is called after the call.
+	After func(ctx context.Context, call *DBCall)
+}
+
+// WrapDB returns a DB calling the hooks around the queries, executions, preparations and transactions of db,
+// so logging, tracing, fault injection and query counting work for everything taking DB.
+//
+// If db is a pool implementing TxInitiator, the returned DB implements TxInitiator[Tx],
+// and the transactions are wrapped too. If db is a Tx, the returned DB implements Tx.
+// It doesn't implement TxInitiator[*sqlx.Tx] even if db is *sqlx.DB, because *sqlx.Tx can't be wrapped,
+// TryTransactionX[*sqlx.Tx] and TryTransactionWithOptionsX[*sqlx.Tx] fall back to TxInitiator[Tx] for it.
+// The methods without context are called with context.Background().
+//
+// The executions of the statements prepared by the Prepare* methods are not hooked.
+func WrapDB(db DB, hooks DBHooks) DB {
+	w := &wrappedDB{db: db, hooks: hooks}
+
+	switch v := db.(type) {
+	case Tx:
+		return &wrappedTx{wrappedDB: w, tx: v}
+	case TxInitiator[*sqlx.Tx]:
+		return &wrappedPool{
+			wrappedDB: w,
+			begin: func(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+				return v.BeginTxx(ctx, opts)
+			},
+		}
+	case TxInitiator[Tx]:
+		return &wrappedPool{wrappedDB: w, begin: v.BeginTxx}
+	}
+	return w
+}
+
+type wrappedDB struct {
+	db    DB
+	hooks DBHooks
+}
+
+// call runs fn between the hooks.
+func (w *wrappedDB) call(ctx context.Context, call *DBCall, fn func(ctx context.Context) error) error {
+	if w.hooks.Before != nil {
+		var err error
+		if ctx, err = w.hooks.Before(ctx, call); err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+	err := fn(ctx)
+
+	if w.hooks.After != nil {
+		call.Err = err
+		call.Duration = time.Since(start)
+		w.hooks.After(ctx, call)
+	}
+	return err
+}
+
+func (w *wrappedDB) Query(query string, args ...any) (*sql.Rows, error) {
+	return w.QueryContext(context.Background(), query, args...)
+}
+
+func (w *wrappedDB) QueryContext(ctx context.Context, query string, args ...any) (rows *sql.Rows, err error) {
+	err = w.call(ctx, &DBCall{Method: "QueryContext", Query: query, Args: args}, func(ctx context.Context) error {
+		rows, err = w.db.QueryContext(ctx, query, args...)
+		return err
+	})
+	return
+}
+
+func (w *wrappedDB) Queryx(query string, args ...any) (*sqlx.Rows, error) {
+	return w.QueryxContext(context.Background(), query, args...)
+}
+
+func (w *wrappedDB) QueryxContext(ctx context.Context, query string, args ...any) (rows *sqlx.Rows, err error) {
+	err = w.call(ctx, &DBCall{Method: "QueryxContext", Query: query, Args: args}, func(ctx context.Context) error {
+		rows, err = w.db.QueryxContext(ctx, query, args...)
+		return err
+	})
+	return
+}
+
+func (w *wrappedDB) QueryRowx(query string, args ...any) *sqlx.Row {
+	return w.QueryRowxContext(context.Background(), query, args...)
+}
+
+func (w *wrappedDB) QueryRowxContext(ctx context.Context, query string, args ...any) (row *sqlx.Row) {
+	err := w.call(ctx, &DBCall{Method: "QueryRowxContext", Query: query, Args: args}, func(ctx context.Context) error {
+		row = w.db.QueryRowxContext(ctx, query, args...)
+		return row.Err()
+	})
+	if row == nil {
+		// aborted by the before hook
+		return errRow(err)
+	}
+	return row
+}
+
+func (w *wrappedDB) Exec(query string, args ...any) (sql.Result, error) {
+	return w.ExecContext(context.Background(), query, args...)
+}
+
+func (w *wrappedDB) ExecContext(ctx context.Context, query string, args ...any) (result sql.Result, err error) {
+	call := &DBCall{Method: "ExecContext", Query: query, Args: args}
+	err = w.call(ctx, call, func(ctx context.Context) error {
+		result, err = w.db.ExecContext(ctx, query, args...)
+		call.Result = result
+		return err
+	})
+	return
+}
+
+func (w *wrappedDB) Prepare(query string) (*sql.Stmt, error) {
+	return w.PrepareContext(context.Background(), query)
+}
+
+func (w *wrappedDB) PrepareContext(ctx context.Context, query string) (stmt *sql.Stmt, err error) {
+	err = w.call(ctx, &DBCall{Method: "PrepareContext", Query: query}, func(ctx context.Context) error {
+		stmt, err = w.db.PrepareContext(ctx, query)
+		return err
+	})
+	return
+}
+
+func (w *wrappedDB) Preparex(query string) (*sqlx.Stmt, error) {
+	return w.PreparexContext(context.Background(), query)
+}
+
+func (w *wrappedDB) PreparexContext(ctx context.Context, query string) (stmt *sqlx.Stmt, err error) {
+	err = w.call(ctx, &DBCall{Method: "PreparexContext", Query: query}, func(ctx context.Context) error {
+		stmt, err = w.db.PreparexContext(ctx, query)
+		return err
+	})
+	return
+}
+
+func (w *wrappedDB) PrepareNamed(query string) (*sqlx.NamedStmt, error) {
+	return w.PrepareNamedContext(context.Background(), query)
+}
+
+func (w *wrappedDB) PrepareNamedContext(ctx context.Context, query string) (stmt *sqlx.NamedStmt, err error) {
+	err = w.call(ctx, &DBCall{Method: "PrepareNamedContext", Query: query}, func(ctx context.Context) error {
+		stmt, err = w.db.PrepareNamedContext(ctx, query)
+		return err
+	})
+	return
+}
+
+func (w *wrappedDB) Get(dest any, query string, args ...any) error {
+	return w.GetContext(context.Background(), dest, query, args...)
+}
+
+func (w *wrappedDB) GetContext(ctx context.Context, dest any, query string, args ...any) error {
+	return w.call(ctx, &DBCall{Method: "GetContext", Query: query, Args: args}, func(ctx context.Context) error {
+		return w.db.GetContext(ctx, dest, query, args...)
+	})
+}
+
+func (w *wrappedDB) Select(dest any, query string, args ...any) error {
+	return w.SelectContext(context.Background(), dest, query, args...)
+}
+
+func (w *wrappedDB) SelectContext(ctx context.Context, dest any, query string, args ...any) error {
+	return w.call(ctx, &DBCall{Method: "SelectContext", Query: query, Args: args}, func(ctx context.Context) error {
+		return w.db.SelectContext(ctx, dest, query, args...)
+	})
+}
+
+func (w *wrappedDB) NamedExec(query string, arg any) (sql.Result, error) {
+	return w.NamedExecContext(context.Background(), query, arg)
+}
+
+func (w *wrappedDB) NamedExecContext(ctx context.Context, query string, arg any) (result sql.Result, err error) {
+	call := &DBCall{Method: "NamedExecContext", Query: query, Args: []any{arg}}
+	err = w.call(ctx, call, func(ctx context.Context) error {
+		result, err = w.db.NamedExecContext(ctx, query, arg)
+		call.Result = result
+		return err
+	})
+	return
+}
+
+func (w *wrappedDB) NamedQuery(query string, arg any) (rows *sqlx.Rows, err error) {
+	err = w.call(context.Background(), &DBCall{Method: "NamedQuery", Query: query, Args: []any{arg}}, func(context.Context) error {
+		rows, err = w.db.NamedQuery(query, arg)
+		return err
+	})
+	return
+}
+
+func (w *wrappedDB) DriverName() string {
+	return w.db.DriverName()
+}
+
+func (w *wrappedDB) Rebind(query string) string {
+	return w.db.Rebind(query)
+}
+
+func (w *wrappedDB) BindNamed(query string, arg any) (string, []any, error) {
+	return w.db.BindNamed(query, arg)
+}
+
+// wrappedPool is a wrapped DB that can begin transactions.
+type wrappedPool struct {
+	*wrappedDB
+
+	begin func(ctx context.Context, opts *sql.TxOptions) (Tx, error)
+}
+
+func (w *wrappedPool) BeginTxx(ctx context.Context, opts *sql.TxOptions) (tx Tx, err error) {
+	err = w.call(ctx, &DBCall{Method: "BeginTxx"}, func(ctx context.Context) error {
+		tx, err = w.begin(ctx, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &wrappedTx{
+		wrappedDB: &wrappedDB{db: tx, hooks: w.hooks},
+		tx:        tx,
+	}, nil
+}
+
+type wrappedTx struct {
+	*wrappedDB
+
+	tx Tx
+}
+
+func (w *wrappedTx) Commit() error {
+	return w.call(context.Background(), &DBCall{Method: "Commit"}, func(context.Context) error {
+		return w.tx.Commit()
+	})
+}
+
+func (w *wrappedTx) Rollback() error {
+	return w.call(context.Background(), &DBCall{Method: "Rollback"}, func(context.Context) error {
+		return w.tx.Rollback()
+	})
+}
+
+// errRow returns a row returning err on scan, sqlx.Row keeps the error unexported and the DB interface returns it,
+// so it's queried from a shared database failing to connect with the error carried by the context.
+func errRow(err error) *sqlx.Row {
+	return errRowDB.QueryRowxContext(context.WithValue(context.Background(), errRowKey{}, err), "")
+}
+
+var errRowDB = sqlx.NewDb(sql.OpenDB(errConnector{}), "")
+
+type errRowKey struct{}
+
+// errConnector fails to connect with err, or the error of context for errRowDB.
+type errConnector struct {
+	err error
+}
+
+func (c errConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err, ok := ctx.Value(errRowKey{}).(error); ok {
+		return nil, err
+	}
+	return nil, c.err
+}
+
+func (c errConnector) Driver() driver.Driver {
+	return c
+}
+
+func (c errConnector) Open(string) (driver.Conn, error) {
+	return nil, c.err
+}
@@ -0,0 +1,104 @@
+package entity
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestWrapDB(t *testing.T) {
+	errConnect := errors.New("connect failed")
+	errInjected := errors.New("injected")
+
+	pool := sqlx.NewDb(sql.OpenDB(errConnector{err: errConnect}), driverPostgres)
+	defer pool.Close()
+
+	var calls []string
+	db := WrapDB(pool, DBHooks{
+		Before: func(ctx context.Context, call *DBCall) (context.Context, error) {
+			if call.Query == "fail" {
+				return ctx, errInjected
+			}
+			return ctx, nil
+		},
+		After: func(_ context.Context, call *DBCall) {
+			if !errors.Is(call.Err, errConnect) {
+				t.Fatalf("%s error, Expected=%v, Actual=%v", call.Method, errConnect, call.Err)
+			}
+			calls = append(calls, call.Method)
+		},
+	})
+
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "DELETE FROM users"); !errors.Is(err, errConnect) {
+		t.Fatalf("exec error, Expected=%v, Actual=%v", errConnect, err)
+	}
+	if _, err := db.NamedExecContext(ctx, "DELETE FROM users WHERE id = :id", map[string]any{"id": 1}); !errors.Is(err, errConnect) {
+		t.Fatalf("named exec error, Expected=%v, Actual=%v", errConnect, err)
+	}
+
+	var id int
+	if err := db.GetContext(ctx, &id, "fail"); !errors.Is(err, errInjected) {
+		t.Fatalf("injected error, Expected=%v, Actual=%v", errInjected, err)
+	} else if err := db.QueryRowxContext(ctx, "fail").Scan(&id); !errors.Is(err, errInjected) {
+		t.Fatalf("injected row error, Expected=%v, Actual=%v", errInjected, err)
+	}
+
+	initiator, ok := db.(TxInitiator[Tx])
+	if !ok {
+		t.Fatal("wrapped pool should be TxInitiator[Tx]")
+	} else if _, err := initiator.BeginTxx(ctx, nil); !errors.Is(err, errConnect) {
+		t.Fatalf("begin error, Expected=%v, Actual=%v", errConnect, err)
+	}
+
+	expected := []string{"ExecContext", "NamedExecContext", "BeginTxx"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("hooked calls, Expected=%v, Actual=%v", expected, calls)
+	}
+
+	if dbDriver(db) != driverPostgres {
+		t.Fatalf("driver name, Expected=%s, Actual=%s", driverPostgres, dbDriver(db))
+	}
+}
+
+func TestWrapDBSqlxTransaction(t *testing.T) {
+	var calls []string
+	db := WrapDB(newSQLiteDB(t, sqliteUserTable), DBHooks{
+		After: func(_ context.Context, call *DBCall) {
+			calls = append(calls, call.Method)
+		},
+	})
+
+	if _, ok := db.(TxInitiator[*sqlx.Tx]); ok {
+		t.Fatal("wrapped *sqlx.DB should not be TxInitiator[*sqlx.Tx]")
+	}
+
+	// the callers of *sqlx.Tx keep working, the transaction is wrapped
+	ctx := context.Background()
+	err := TryTransactionX[*sqlx.Tx](ctx, db, func(tx DB) error {
+		return TryTransactionX[*sqlx.Tx](ctx, tx, func(tx DB) error {
+			_, err := tx.ExecContext(ctx, "INSERT INTO users (id, name) VALUES (1, 'foo')")
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"BeginTxx", "ExecContext", "Commit"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("hooked calls, Expected=%v, Actual=%v", expected, calls)
+	}
+
+	errA, errB := errors.New("a"), errors.New("b")
+	var id int
+	if err := errRow(errA).Scan(&id); !errors.Is(err, errA) {
+		t.Fatalf("row error, Expected=%v, Actual=%v", errA, err)
+	} else if err := errRow(errB).Scan(&id); !errors.Is(err, errB) {
+		t.Fatalf("row error, Expected=%v, Actual=%v", errB, err)
+	}
+}
@@ -0,0 +1,164 @@
+package entity
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// DomainEventSource is implemented by domain objects recording domain events, EventRecorder can be embedded
+// to implement it.
+//
+// DomainObjectRepository reads the events after the domain object is written successfully,
+// and clears them after they are dispatched in transaction.
+type DomainEventSource interface {
+	DomainEvents() []any
+	ClearDomainEvents()
+}
+
+// EventRecorder records domain events, it should be embedded into domain objects used as pointers.
+//
+// Example:
+//
+//	type Order struct {
+//		entity.EventRecorder
+//		ID int64
+//	}
+//
+//	func (o *Order) Pay() {
+//		o.Raise(OrderPaid{ID: o.ID})
+//	}
+type EventRecorder struct {
+	events []any
+}
+
+// Raise records a domain event.
+func (er *EventRecorder) Raise(event any) {
+	er.events = append(er.events, event)
+}
+
+// DomainEvents returns the recorded domain events.
+func (er *EventRecorder) DomainEvents() []any {
+	return er.events
+}
+
+// ClearDomainEvents removes all the recorded domain events.
+func (er *EventRecorder) ClearDomainEvents() {
+	er.events = nil
+}
+
+// PullEvents returns and removes all the recorded domain events.
+func (er *EventRecorder) PullEvents() []any {
+	events := er.events
+	er.events = nil
+	return events
+}
+
+// DomainEventHandler handles a domain event after the transaction is committed.
+type DomainEventHandler func(ctx context.Context, event any) error
+
+// TransactionalDomainEventHandler handles a domain event inside the transaction of the write,
+// db is the transaction, returning an error rolls back the write.
+type TransactionalDomainEventHandler func(ctx context.Context, db DB, event any) error
+
+// HandleEvent adapts a function handling events of type E to DomainEventHandler, other events are ignored.
+func HandleEvent[E any](fn func(ctx context.Context, event E) error) DomainEventHandler {
+	return func(ctx context.Context, event any) error {
+		if v, ok := event.(E); ok {
+			return fn(ctx, v)
+		}
+		return nil
+	}
+}
+
+// HandleEventInTransaction adapts a function handling events of type E to TransactionalDomainEventHandler,
+// other events are ignored.
+func HandleEventInTransaction[E any](fn func(ctx context.Context, db DB, event E) error) TransactionalDomainEventHandler {
+	return func(ctx context.Context, db DB, event any) error {
+		if v, ok := event.(E); ok {
+			return fn(ctx, db, v)
+		}
+		return nil
+	}
+}
+
+// DomainEventBus dispatches the domain events pulled by DomainObjectRepository to the subscribed handlers.
+type DomainEventBus struct {
+	mu            sync.RWMutex
+	inTransaction []TransactionalDomainEventHandler
+	afterCommit   []DomainEventHandler
+}
+
+// NewDomainEventBus creates a new DomainEventBus.
+func NewDomainEventBus() *DomainEventBus {
+	return &DomainEventBus{}
+}
+
+// Subscribe registers handlers called after the transaction is committed.
+//
+// The write has been committed when they are called, so their errors are returned
+// but do not roll back anything. If the repository is bound to a transaction of the caller,
+// they are called after the write, before the caller commits.
+func (b *DomainEventBus) Subscribe(handlers ...DomainEventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.afterCommit = append(b.afterCommit, handlers...)
+}
+
+// SubscribeInTransaction registers handlers called inside the transaction of the write,
+// the write is executed in a transaction if there is any of them.
+func (b *DomainEventBus) SubscribeInTransaction(handlers ...TransactionalDomainEventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.inTransaction = append(b.inTransaction, handlers...)
+}
+
+// transactional reports whether there is any handler called in transaction.
+func (b *DomainEventBus) transactional() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.inTransaction) > 0
+}
+
+// transaction runs fn in a transaction if there is any handler called in transaction.
+func (b *DomainEventBus) transaction(ctx context.Context, db DB, fn func(db DB) error) error {
+	if !b.transactional() {
+		return fn(db)
+	}
+	return tryTransaction(ctx, db, fn)
+}
+
+func (b *DomainEventBus) dispatchInTransaction(ctx context.Context, db DB, events []any) error {
+	b.mu.RLock()
+	handlers := b.inTransaction
+	b.mu.RUnlock()
+
+	for _, event := range events {
+		for _, handler := range handlers {
+			if err := handler(ctx, db, event); err != nil {
+				return fmt.Errorf("handle %T, %w", event, err)
+			}
+		}
+	}
+	return nil
+}
+
+// dispatchAfterCommit calls all the handlers for every event, and returns the first error.
+func (b *DomainEventBus) dispatchAfterCommit(ctx context.Context, events []any) error {
+	b.mu.RLock()
+	handlers := b.afterCommit
+	b.mu.RUnlock()
+
+	var firstErr error
+	for _, event := range events {
+		for _, handler := range handlers {
+			if err := handler(ctx, event); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("handle %T after commit, %w", event, err)
+			}
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,98 @@
+package entity
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type orderPaid struct {
+	ID int64
+}
+
+type orderShipped struct {
+	ID int64
+}
+
+func TestEventRecorder(t *testing.T) {
+	er := &EventRecorder{}
+	er.Raise(orderPaid{ID: 1})
+	er.Raise(orderShipped{ID: 1})
+
+	expected := []any{orderPaid{ID: 1}, orderShipped{ID: 1}}
+	if events := er.DomainEvents(); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("domain events, Expected=%v, Actual=%v", expected, events)
+	}
+
+	if events := er.PullEvents(); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("pull events, Expected=%v, Actual=%v", expected, events)
+	} else if len(er.DomainEvents()) != 0 {
+		t.Fatal("events should be removed after pulled")
+	}
+
+	var _ DomainEventSource = er
+}
+
+func TestDomainEventBus(t *testing.T) {
+	ctx := context.Background()
+	events := []any{orderPaid{ID: 1}, orderShipped{ID: 1}}
+
+	t.Run("in transaction", func(t *testing.T) {
+		bus := NewDomainEventBus()
+
+		var handled []any
+		bus.SubscribeInTransaction(HandleEventInTransaction(func(ctx context.Context, db DB, event orderPaid) error {
+			handled = append(handled, event)
+			return nil
+		}))
+
+		if err := bus.dispatchInTransaction(ctx, nil, events); err != nil {
+			t.Fatal(err)
+		} else if expected := []any{orderPaid{ID: 1}}; !reflect.DeepEqual(handled, expected) {
+			t.Fatalf("handled events, Expected=%v, Actual=%v", expected, handled)
+		}
+
+		bus.SubscribeInTransaction(func(ctx context.Context, db DB, event any) error {
+			return errors.New("failed")
+		})
+		if err := bus.dispatchInTransaction(ctx, nil, events); err == nil {
+			t.Fatal("error of handler should be returned")
+		}
+	})
+
+	t.Run("after commit", func(t *testing.T) {
+		bus := NewDomainEventBus()
+
+		var handled []any
+		bus.Subscribe(
+			func(ctx context.Context, event any) error {
+				return errors.New("failed")
+			},
+			func(ctx context.Context, event any) error {
+				handled = append(handled, event)
+				return nil
+			},
+		)
+
+		if err := bus.dispatchAfterCommit(ctx, events); err == nil {
+			t.Fatal("error of handler should be returned")
+		} else if !reflect.DeepEqual(handled, events) {
+			t.Fatalf("all handlers should be called, Expected=%v, Actual=%v", events, handled)
+		}
+	})
+
+	t.Run("transaction", func(t *testing.T) {
+		bus := NewDomainEventBus()
+
+		called := false
+		if err := bus.transaction(ctx, nil, func(db DB) error {
+			called = true
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		} else if !called {
+			t.Fatal("fn should be called without transaction")
+		}
+	})
+}
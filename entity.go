@@ -12,6 +12,7 @@ import (
 
 	"github.com/jmoiron/sqlx"
 	"github.com/jmoiron/sqlx/reflectx"
+	"github.com/joyparty/entity/internal/field"
 )
 
 const (
@@ -29,6 +30,14 @@ const (
 	EventBeforeDelete
 	// EventAfterDelete after delete entity
 	EventAfterDelete
+	// EventAfterLoad after load entity
+	EventAfterLoad
+	// EventBeforeUpsert before upsert entity
+	EventBeforeUpsert
+	// EventAfterUpsert after upsert entity
+	EventAfterUpsert
+	// EventAfterFind after entity is read by repository
+	EventAfterFind
 )
 
 var (
@@ -57,6 +66,9 @@ type Entity interface {
 }
 
 // EventHook is an interface for event-style hooks.
+//
+// All hooks implemented by an entity are called, including the ones declared by embedded structs,
+// see runHooks for the calling order.
 type EventHook interface {
 	OnEntityEvent(ctx context.Context, ev Event) error
 }
@@ -91,6 +103,26 @@ type AfterDeleteHook interface {
 	AfterDelete(ctx context.Context) error
 }
 
+// AfterLoadHook is called after loading an entity, from either database or cache.
+type AfterLoadHook interface {
+	AfterLoad(ctx context.Context) error
+}
+
+// BeforeUpsertHook is called before upserting an entity, instead of BeforeInsertHook and BeforeUpdateHook.
+type BeforeUpsertHook interface {
+	BeforeUpsert(ctx context.Context) error
+}
+
+// AfterUpsertHook is called after upserting an entity, instead of AfterInsertHook and AfterUpdateHook.
+type AfterUpsertHook interface {
+	AfterUpsert(ctx context.Context) error
+}
+
+// AfterFindHook is called after an entity is read by repository queries.
+type AfterFindHook interface {
+	AfterFind(ctx context.Context) error
+}
+
 // Column contains field metadata information.
 type Column struct {
 	StructField     string
@@ -100,6 +132,16 @@ type Column struct {
 	RefuseUpdate    bool
 	ReturningInsert bool
 	ReturningUpdate bool
+	NotNull         bool
+	// Version is the column of optimistic locking, it's increased by every update,
+	// and the update fails with ErrConflict if the version has been changed by others.
+	Version bool
+	// Validate contains the validation rules declared by the "validate" tag.
+	Validate string
+	// Sensitive columns are redacted in StatementLog.
+	Sensitive bool
+	// Tenant is the column of tenant id, it's set from the context by WithTenant and can't be updated.
+	Tenant bool
 }
 
 func (c Column) String() string {
@@ -112,9 +154,14 @@ type Metadata struct {
 	TableName   string
 	Columns     []Column
 	PrimaryKeys []Column
+	Relations   []Relation
 
 	hasReturningInsert bool
 	hasReturningUpdate bool
+	version            *Column
+	tenant             *Column
+
+	validators []columnValidator
 }
 
 // NewMetadata constructs and returns the metadata for an entity object.
@@ -142,12 +189,38 @@ func NewMetadata(ent Entity) (*Metadata, error) {
 		if col.PrimaryKey {
 			md.PrimaryKeys = append(md.PrimaryKeys, col)
 		}
+		if col.Version {
+			if md.version != nil {
+				return nil, fmt.Errorf("entity %q has multiple version columns", md.Type)
+			}
+			version := col
+			md.version = &version
+		}
+		if col.Tenant {
+			if md.tenant != nil {
+				return nil, fmt.Errorf("entity %q has multiple tenant columns", md.Type)
+			}
+			tenant := col
+			md.tenant = &tenant
+		}
 	}
 
 	if len(md.PrimaryKeys) == 0 {
 		return nil, fmt.Errorf("entity %q primary key not found", md.Type)
 	}
 
+	validators, err := newColumnValidators(md.Columns)
+	if err != nil {
+		return nil, fmt.Errorf("entity %q, %w", md.Type, err)
+	}
+	md.validators = validators
+
+	relations, err := parseRelations(md.Type, md.PrimaryKeys)
+	if err != nil {
+		return nil, fmt.Errorf("entity %q, %w", md.Type, err)
+	}
+	md.Relations = relations
+
 	return md, nil
 }
 
@@ -167,12 +240,39 @@ func getMetadata(ent Entity) (*Metadata, error) {
 	return md, nil
 }
 
+// getTableMetadata returns the metadata with the table of entity itself, or of the shard key of ctx, resolved with ctx,
+// the entities of dynamic table name share the metadata of type except the table name.
+func getTableMetadata(ctx context.Context, ent Entity) (*Metadata, error) {
+	md, err := getMetadata(ent)
+	if err != nil {
+		return nil, err
+	}
+
+	table, err := shardTable(ctx, ent)
+	if err != nil {
+		return nil, err
+	}
+
+	if resolved := ResolveTable(ctx, table); resolved != md.TableName {
+		v := *md
+		v.TableName = resolved
+		return &v, nil
+	}
+	return md, nil
+}
+
 func getColumns(ent Entity) []Column {
 	cols := []Column{}
 	for _, fi := range getFields(ent) {
+		// relation fields are loaded by Preload
+		if _, ok := fi.Field.Tag.Lookup("rel"); ok {
+			continue
+		}
+
 		col := Column{
 			StructField: fi.Field.Name,
 			DBField:     fi.Name,
+			Validate:    fi.Field.Tag.Get("validate"),
 		}
 
 		for key := range fi.Options {
@@ -194,6 +294,16 @@ func getColumns(ent Entity) []Column {
 			case "autoIncrement", "auto_increment":
 				col.AutoIncrement = true
 				col.RefuseUpdate = true
+			case "notNull", "not_null":
+				col.NotNull = true
+			case "version":
+				col.Version = true
+				col.RefuseUpdate = true
+			case "sensitive":
+				col.Sensitive = true
+			case "tenant":
+				col.Tenant = true
+				col.RefuseUpdate = true
 			}
 		}
 		cols = append(cols, col)
@@ -202,6 +312,11 @@ func getColumns(ent Entity) []Column {
 	return cols
 }
 
+// fieldByColumn returns the field value of the column without allocating nil pointers.
+func fieldByColumn(v reflect.Value, name string) reflect.Value {
+	return field.ByColumn(mapper, v, name)
+}
+
 // getFields returns all database fields of an entity object, supporting nested structs. Outer fields have higher priority than inner fields.
 func getFields(ent Entity) []*reflectx.FieldInfo {
 	done := map[string]struct{}{}
@@ -244,16 +359,29 @@ func Load(ctx context.Context, ent Entity, db DB) error {
 	ctx, cancel := context.WithTimeout(ctx, ReadTimeout)
 	defer cancel()
 
-	cv, cacheable := ent.(Cacheable)
+	_, err := runOperation(ctx, OperationLoad, ent, db, loadEntity)
+	return err
+}
+
+func loadEntity(ctx context.Context, op *Operation) error {
+	cv, cacheable := op.Entity.(Cacheable)
 	if cacheable {
 		if loaded, err := loadCache(ctx, cv); err != nil {
 			return fmt.Errorf("load from cache, %w", err)
+		} else if loaded && tenantMatched(ctx, op.Metadata, op.Entity) {
+			observeCache(ctx, CacheHit)
+			return afterLoad(ctx, op.Entity)
 		} else if loaded {
-			return nil
+			// the cache key is prefixed by tenant, it's only possible for the entries written by others,
+			// the tenant of context is restored to load from database
+			if err := assignTenant(ctx, op.Metadata, op.Entity); err != nil {
+				return err
+			}
 		}
+		observeCache(ctx, CacheMiss)
 	}
 
-	if err := doLoad(ctx, ent, db); err != nil {
+	if err := doLoad(ctx, op.Entity, op.DB); err != nil {
 		return err
 	}
 
@@ -263,6 +391,13 @@ func Load(ctx context.Context, ent Entity, db DB) error {
 		}
 	}
 
+	return afterLoad(ctx, op.Entity)
+}
+
+func afterLoad(ctx context.Context, ent Entity) error {
+	if err := runHooks(ctx, ent, EventAfterLoad); err != nil {
+		return fmt.Errorf("after load, %w", err)
+	}
 	return nil
 }
 
@@ -271,22 +406,71 @@ func Insert(ctx context.Context, ent Entity, db DB) (int64, error) {
 	ctx, cancel := context.WithTimeout(ctx, WriteTimeout)
 	defer cancel()
 
-	if err := beforeInsert(ctx, ent); err != nil {
-		return 0, fmt.Errorf("before insert, %w", err)
+	op, err := runOperation(ctx, OperationInsert, ent, db, insertEntity)
+	if err != nil {
+		return 0, err
 	}
+	return op.LastInsertID, nil
+}
 
-	lastID, err := doInsert(ctx, ent, db)
+func insertEntity(ctx context.Context, op *Operation) error {
+	if err := runHooks(ctx, op.Entity, EventBeforeInsert); err != nil {
+		return fmt.Errorf("before insert, %w", err)
+	}
+	if err := validate(ctx, op, EventBeforeInsert); err != nil {
+		return fmt.Errorf("validate, %w", err)
+	}
+
+	lastID, err := doInsert(ctx, op.Entity, op.DB)
 	if err != nil {
-		if isConflictError(err, dbDriver(db)) {
-			return 0, ErrConflict
+		if isConflictError(err, dbDriver(op.DB)) {
+			return ErrConflict
 		}
-		return 0, err
+		return err
 	}
+	op.LastInsertID = lastID
 
-	if err := afterInsert(ctx, ent); err != nil {
-		return 0, fmt.Errorf("after insert, %w", err)
+	if err := runHooks(ctx, op.Entity, EventAfterInsert); err != nil {
+		return fmt.Errorf("after insert, %w", err)
 	}
-	return lastID, nil
+	return nil
+}
+
+// InsertOrIgnore saves a new entity to the database, does nothing if the entity already exists.
+// It reports whether the entity is inserted, after insert hooks are only executed when it is inserted.
+//
+// MySQL reports an existing entity as inserted if the clientFoundRows of DSN is enabled.
+func InsertOrIgnore(ctx context.Context, ent Entity, db DB) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, WriteTimeout)
+	defer cancel()
+
+	op, err := runOperation(ctx, OperationInsertOrIgnore, ent, db, insertOrIgnoreEntity)
+	if err != nil {
+		return false, err
+	}
+	return !op.Ignored, nil
+}
+
+func insertOrIgnoreEntity(ctx context.Context, op *Operation) error {
+	if err := runHooks(ctx, op.Entity, EventBeforeInsert); err != nil {
+		return fmt.Errorf("before insert, %w", err)
+	}
+	if err := validate(ctx, op, EventBeforeInsert); err != nil {
+		return fmt.Errorf("validate, %w", err)
+	}
+
+	inserted, err := doInsertIgnore(ctx, op.Entity, op.DB)
+	if err != nil {
+		return err
+	} else if !inserted {
+		op.Ignored = true
+		return nil
+	}
+
+	if err := runHooks(ctx, op.Entity, EventAfterInsert); err != nil {
+		return fmt.Errorf("after insert, %w", err)
+	}
+	return nil
 }
 
 // Update updates an existing entity in the database.
@@ -294,56 +478,71 @@ func Update(ctx context.Context, ent Entity, db DB) error {
 	ctx, cancel := context.WithTimeout(ctx, WriteTimeout)
 	defer cancel()
 
-	if err := beforeUpdate(ctx, ent); err != nil {
+	_, err := runOperation(ctx, OperationUpdate, ent, db, updateEntity)
+	return err
+}
+
+func updateEntity(ctx context.Context, op *Operation) error {
+	if err := runHooks(ctx, op.Entity, EventBeforeUpdate); err != nil {
 		return fmt.Errorf("before update, %w", err)
 	}
+	if err := validate(ctx, op, EventBeforeUpdate); err != nil {
+		return fmt.Errorf("validate, %w", err)
+	}
 
-	if err := doUpdate(ctx, ent, db); err != nil {
-		if isConflictError(err, dbDriver(db)) {
+	if err := doUpdate(ctx, op.Entity, op.DB); err != nil {
+		if isConflictError(err, dbDriver(op.DB)) {
 			return ErrConflict
 		}
 		return err
 	}
 
-	if v, ok := ent.(Cacheable); ok {
+	if v, ok := op.Entity.(Cacheable); ok {
 		if err := DeleteCache(ctx, v); err != nil {
 			return fmt.Errorf("delete cache, %w", err)
 		}
 	}
 
-	if err := afterUpdate(ctx, ent); err != nil {
+	if err := runHooks(ctx, op.Entity, EventAfterUpdate); err != nil {
 		return fmt.Errorf("after update, %w", err)
 	}
 	return nil
 }
 
 // Upsert inserts a new entity or updates an existing one in the database.
+//
+// Only the BeforeUpsert and AfterUpsert hooks are called, and EventHook with EventBeforeUpsert and EventAfterUpsert.
+// The insert and update hooks are not called since it's unknown which one happens,
+// the entities depending on them should implement the upsert hooks and call them there.
 func Upsert(ctx context.Context, ent Entity, db DB) error {
 	ctx, cancel := context.WithTimeout(ctx, WriteTimeout)
 	defer cancel()
 
-	if err := beforeInsert(ctx, ent); err != nil {
-		return fmt.Errorf("before upsert, %w", err)
-	} else if err := beforeUpdate(ctx, ent); err != nil {
+	_, err := runOperation(ctx, OperationUpsert, ent, db, upsertEntity)
+	return err
+}
+
+func upsertEntity(ctx context.Context, op *Operation) error {
+	if err := runHooks(ctx, op.Entity, EventBeforeUpsert); err != nil {
 		return fmt.Errorf("before upsert, %w", err)
 	}
+	if err := validate(ctx, op, EventBeforeUpsert); err != nil {
+		return fmt.Errorf("validate, %w", err)
+	}
 
-	if err := doUpsert(ctx, ent, db); err != nil {
+	if err := doUpsert(ctx, op.Entity, op.DB); err != nil {
 		return err
 	}
 
-	if v, ok := ent.(Cacheable); ok {
+	if v, ok := op.Entity.(Cacheable); ok {
 		if err := DeleteCache(ctx, v); err != nil {
 			return fmt.Errorf("delete cache, %w", err)
 		}
 	}
 
-	if err := afterInsert(ctx, ent); err != nil {
-		return fmt.Errorf("after upsert, %w", err)
-	} else if err := afterUpdate(ctx, ent); err != nil {
+	if err := runHooks(ctx, op.Entity, EventAfterUpsert); err != nil {
 		return fmt.Errorf("after upsert, %w", err)
 	}
-
 	return nil
 }
 
@@ -352,21 +551,26 @@ func Delete(ctx context.Context, ent Entity, db DB) error {
 	ctx, cancel := context.WithTimeout(ctx, WriteTimeout)
 	defer cancel()
 
-	if err := beforeDelete(ctx, ent); err != nil {
+	_, err := runOperation(ctx, OperationDelete, ent, db, deleteEntity)
+	return err
+}
+
+func deleteEntity(ctx context.Context, op *Operation) error {
+	if err := runHooks(ctx, op.Entity, EventBeforeDelete); err != nil {
 		return fmt.Errorf("before delete, %w", err)
 	}
 
-	if err := doDelete(ctx, ent, db); err != nil {
+	if err := doDelete(ctx, op.Entity, op.DB); err != nil {
 		return err
 	}
 
-	if v, ok := ent.(Cacheable); ok {
+	if v, ok := op.Entity.(Cacheable); ok {
 		if err := DeleteCache(ctx, v); err != nil {
 			return fmt.Errorf("delete cache, %w", err)
 		}
 	}
 
-	if err := afterDelete(ctx, ent); err != nil {
+	if err := runHooks(ctx, op.Entity, EventAfterDelete); err != nil {
 		return fmt.Errorf("after delete, %w", err)
 	}
 	return nil
@@ -376,12 +580,13 @@ func Delete(ctx context.Context, ent Entity, db DB) error {
 type PrepareInsertStatement struct {
 	md       *Metadata
 	stmt     *sqlx.NamedStmt
+	db       DB
 	dbDriver string
 }
 
 // PrepareInsert creates a prepared statement for inserting entities.
 func PrepareInsert(ctx context.Context, ent Entity, db DB) (*PrepareInsertStatement, error) {
-	md, err := getMetadata(ent)
+	md, err := getTableMetadata(ctx, ent)
 	if err != nil {
 		return nil, fmt.Errorf("get metadata, %w", err)
 	}
@@ -395,6 +600,7 @@ func PrepareInsert(ctx context.Context, ent Entity, db DB) (*PrepareInsertStatem
 	return &PrepareInsertStatement{
 		md:       md,
 		stmt:     stmt,
+		db:       db,
 		dbDriver: dbDriver(db),
 	}, nil
 }
@@ -409,34 +615,66 @@ func (pis *PrepareInsertStatement) ExecContext(ctx context.Context, ent Entity)
 	ctx, cancel := context.WithTimeout(ctx, WriteTimeout)
 	defer cancel()
 
-	if err := beforeInsert(ctx, ent); err != nil {
-		return 0, fmt.Errorf("before insert, %w", err)
+	op, err := runOperation(ctx, OperationInsert, ent, pis.db, pis.insert)
+	if err != nil {
+		return 0, err
 	}
+	return op.LastInsertID, nil
+}
 
-	lastID, err = pis.execContext(ctx, ent)
+func (pis *PrepareInsertStatement) insert(ctx context.Context, op *Operation) error {
+	if err := runHooks(ctx, op.Entity, EventBeforeInsert); err != nil {
+		return fmt.Errorf("before insert, %w", err)
+	}
+	if err := validate(ctx, op, EventBeforeInsert); err != nil {
+		return fmt.Errorf("validate, %w", err)
+	}
+
+	stmt := pis.stmt
+	if op.DB != pis.db {
+		// the database is replaced by middleware, such as with a transaction
+		s, err := op.DB.PrepareNamedContext(ctx, getStatement(commandInsert, pis.md, pis.dbDriver))
+		if err != nil {
+			return fmt.Errorf("prepare insert statement, %w", err)
+		}
+		defer s.Close()
+		stmt = s
+	}
+
+	lastID, err := pis.execContext(ctx, stmt, op.Entity)
 	if err != nil {
 		if isConflictError(err, pis.dbDriver) {
-			return 0, ErrConflict
+			return ErrConflict
 		}
-		return 0, err
+		return err
 	}
+	op.LastInsertID = lastID
 
-	if err := afterInsert(ctx, ent); err != nil {
-		return 0, fmt.Errorf("after insert, %w", err)
+	if err := runHooks(ctx, op.Entity, EventAfterInsert); err != nil {
+		return fmt.Errorf("after insert, %w", err)
 	}
-	return lastID, nil
+	return nil
 }
 
-func (pis *PrepareInsertStatement) execContext(ctx context.Context, ent Entity) (lastID int64, err error) {
+func (pis *PrepareInsertStatement) execContext(ctx context.Context, stmt *sqlx.NamedStmt, ent Entity) (lastID int64, err error) {
+	observeStatement(ctx, stmt.QueryString)
+	ctx = startStatementLog(ctx, stmt.QueryString, namedArgs(pis.md, stmt.QueryString, ent))
+	defer func() {
+		endStatementLog(ctx, err)
+	}()
+
 	if pis.md.hasReturningInsert {
-		err := pis.stmt.QueryRowxContext(ctx, ent).StructScan(ent)
+		err := stmt.QueryRowxContext(ctx, ent).StructScan(ent)
 		return 0, err
 	}
 
-	result, err := pis.stmt.ExecContext(ctx, ent)
+	result, err := stmt.ExecContext(ctx, ent)
 	if err != nil {
 		return 0, err
-	} else if pis.dbDriver == driverPostgres {
+	}
+
+	observeResult(ctx, result)
+	if pis.dbDriver == driverPostgres {
 		// PostgreSQL does not support the LastInsertId feature.
 		return 0, nil
 	}
@@ -452,12 +690,13 @@ func (pis *PrepareInsertStatement) execContext(ctx context.Context, ent Entity)
 type PrepareUpdateStatement struct {
 	md       *Metadata
 	stmt     *sqlx.NamedStmt
+	db       DB
 	dbDriver string
 }
 
 // PrepareUpdate creates a prepared statement for updating entities.
 func PrepareUpdate(ctx context.Context, ent Entity, db DB) (*PrepareUpdateStatement, error) {
-	md, err := getMetadata(ent)
+	md, err := getTableMetadata(ctx, ent)
 	if err != nil {
 		return nil, fmt.Errorf("get metadata, %w", err)
 	}
@@ -472,6 +711,7 @@ func PrepareUpdate(ctx context.Context, ent Entity, db DB) (*PrepareUpdateStatem
 	return &PrepareUpdateStatement{
 		md:       md,
 		stmt:     stmt,
+		db:       db,
 		dbDriver: driver,
 	}, nil
 }
@@ -486,97 +726,80 @@ func (pus *PrepareUpdateStatement) ExecContext(ctx context.Context, ent Entity)
 	ctx, cancel := context.WithTimeout(ctx, WriteTimeout)
 	defer cancel()
 
-	if err := beforeUpdate(ctx, ent); err != nil {
+	_, err := runOperation(ctx, OperationUpdate, ent, pus.db, pus.update)
+	return err
+}
+
+func (pus *PrepareUpdateStatement) update(ctx context.Context, op *Operation) error {
+	if err := runHooks(ctx, op.Entity, EventBeforeUpdate); err != nil {
 		return fmt.Errorf("before update, %w", err)
 	}
+	if err := validate(ctx, op, EventBeforeUpdate); err != nil {
+		return fmt.Errorf("validate, %w", err)
+	}
 
-	if err := pus.execContext(ctx, ent); err != nil {
+	stmt := pus.stmt
+	if op.DB != pus.db {
+		// the database is replaced by middleware, such as with a transaction
+		s, err := op.DB.PrepareNamedContext(ctx, getStatement(commandUpdate, pus.md, pus.dbDriver))
+		if err != nil {
+			return fmt.Errorf("prepare update statement, %w", err)
+		}
+		defer s.Close()
+		stmt = s
+	}
+
+	if err := pus.execContext(ctx, stmt, op.Entity); err != nil {
 		if isConflictError(err, pus.dbDriver) {
 			return ErrConflict
 		}
 		return err
 	}
 
-	if v, ok := ent.(Cacheable); ok {
+	if v, ok := op.Entity.(Cacheable); ok {
 		if err := DeleteCache(ctx, v); err != nil {
 			return fmt.Errorf("delete cache, %w", err)
 		}
 	}
 
-	if err := afterUpdate(ctx, ent); err != nil {
+	if err := runHooks(ctx, op.Entity, EventAfterUpdate); err != nil {
 		return fmt.Errorf("after update, %w", err)
 	}
 	return nil
 }
 
-func (pus *PrepareUpdateStatement) execContext(ctx context.Context, ent Entity) error {
+func (pus *PrepareUpdateStatement) execContext(ctx context.Context, stmt *sqlx.NamedStmt, ent Entity) (err error) {
+	observeStatement(ctx, stmt.QueryString)
+	ctx = startStatementLog(ctx, stmt.QueryString, namedArgs(pus.md, stmt.QueryString, ent))
+	defer func() {
+		endStatementLog(ctx, err)
+	}()
+
 	if pus.md.hasReturningUpdate {
-		return pus.stmt.QueryRowxContext(ctx, ent).StructScan(ent)
+		err := stmt.QueryRowxContext(ctx, ent).StructScan(ent)
+		if errors.Is(err, sql.ErrNoRows) && pus.md.version != nil {
+			return ErrConflict
+		}
+		return err
 	}
 
-	result, err := pus.stmt.ExecContext(ctx, ent)
+	result, err := stmt.ExecContext(ctx, ent)
 	if err != nil {
 		return err
 	}
 
-	if n, err := result.RowsAffected(); err != nil {
+	n, err := result.RowsAffected()
+	if err != nil {
 		return fmt.Errorf("get affected rows, %w", err)
-	} else if n == 0 {
-		return sql.ErrNoRows
 	}
-	return nil
-}
 
-func beforeInsert(ctx context.Context, ent Entity) error {
-	if v, ok := ent.(BeforeInsertHook); ok {
-		return v.BeforeInsert(ctx)
-	} else if v, ok := ent.(EventHook); ok {
-		return v.OnEntityEvent(ctx, EventBeforeInsert)
-	}
-	return nil
-}
-
-func afterInsert(ctx context.Context, ent Entity) error {
-	if v, ok := ent.(AfterInsertHook); ok {
-		return v.AfterInsert(ctx)
-	} else if v, ok := ent.(EventHook); ok {
-		return v.OnEntityEvent(ctx, EventAfterInsert)
-	}
-	return nil
-}
-
-func beforeUpdate(ctx context.Context, ent Entity) error {
-	if v, ok := ent.(BeforeUpdateHook); ok {
-		return v.BeforeUpdate(ctx)
-	} else if v, ok := ent.(EventHook); ok {
-		return v.OnEntityEvent(ctx, EventBeforeUpdate)
-	}
-	return nil
-}
-
-func afterUpdate(ctx context.Context, ent Entity) error {
-	if v, ok := ent.(AfterUpdateHook); ok {
-		return v.AfterUpdate(ctx)
-	} else if v, ok := ent.(EventHook); ok {
-		return v.OnEntityEvent(ctx, EventAfterUpdate)
-	}
-	return nil
-}
-
-func beforeDelete(ctx context.Context, ent Entity) error {
-	if v, ok := ent.(BeforeDeleteHook); ok {
-		return v.BeforeDelete(ctx)
-	} else if v, ok := ent.(EventHook); ok {
-		return v.OnEntityEvent(ctx, EventBeforeDelete)
-	}
-	return nil
-}
-
-func afterDelete(ctx context.Context, ent Entity) error {
-	if v, ok := ent.(AfterDeleteHook); ok {
-		return v.AfterDelete(ctx)
-	} else if v, ok := ent.(EventHook); ok {
-		return v.OnEntityEvent(ctx, EventAfterDelete)
+	observeRows(ctx, n)
+	if n == 0 {
+		if pus.md.version != nil {
+			return ErrConflict
+		}
+		return sql.ErrNoRows
 	}
+	increaseVersion(pus.md, ent)
 	return nil
 }
@@ -0,0 +1,20 @@
+module github.com/joyparty/entity/extra/entityotel
+
+go 1.18
+
+replace github.com/joyparty/entity => ../..
+
+require (
+	github.com/joyparty/entity v0.0.0-00010101000000-000000000000
+	go.opentelemetry.io/otel v1.14.0
+	go.opentelemetry.io/otel/sdk v1.14.0
+	go.opentelemetry.io/otel/trace v1.14.0
+)
+
+require (
+	github.com/doug-martin/goqu/v9 v9.19.0 // indirect
+	github.com/go-logr/logr v1.2.3 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/jmoiron/sqlx v1.4.0 // indirect
+	golang.org/x/sys v0.5.0 // indirect
+)
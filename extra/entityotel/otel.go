@@ -0,0 +1,109 @@
+// Package entityotel traces entity operations, cache loading and transactions with OpenTelemetry.
+//
+// Example:
+//
+//	entity.RegisterObserver(entityotel.NewObserver())
+package entityotel
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/joyparty/entity"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/joyparty/entity/extra/entityotel"
+
+// Option is an option for NewObserver.
+type Option func(*Observer)
+
+// WithTracerProvider sets the tracer provider, the global provider is used by default.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *Observer) {
+		o.tracer = tp.Tracer(instrumentationName)
+	}
+}
+
+// WithAttributes adds attributes to all the spans, such as db.system.
+func WithAttributes(attrs ...attribute.KeyValue) Option {
+	return func(o *Observer) {
+		o.attrs = append(o.attrs, attrs...)
+	}
+}
+
+// Observer creates an OpenTelemetry span for each entity span.
+type Observer struct {
+	tracer trace.Tracer
+	attrs  []attribute.KeyValue
+}
+
+// NewObserver creates a new Observer.
+func NewObserver(opts ...Option) *Observer {
+	o := &Observer{
+		tracer: otel.Tracer(instrumentationName),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// SpanStart implements entity.Observer.
+func (o *Observer) SpanStart(ctx context.Context, span *entity.Span) context.Context {
+	attrs := append([]attribute.KeyValue{attribute.String("db.operation.name", span.Name)}, o.attrs...)
+	if span.Table != "" {
+		attrs = append(attrs, attribute.String("db.collection.name", span.Table))
+	}
+
+	ctx, _ = o.tracer.Start(ctx, "entity."+span.Name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(span.StartTime),
+		trace.WithAttributes(attrs...),
+	)
+	return ctx
+}
+
+// SpanEnd implements entity.Observer.
+func (o *Observer) SpanEnd(ctx context.Context, span *entity.Span) {
+	s := trace.SpanFromContext(ctx)
+
+	if span.Statement != "" {
+		s.SetAttributes(attribute.String("db.query.text", span.Statement))
+	}
+	if span.RowsAffected >= 0 {
+		s.SetAttributes(attribute.Int64("db.response.rows_affected", span.RowsAffected))
+	}
+	if span.Cache != entity.CacheUnused {
+		s.SetAttributes(attribute.String("entity.cache", span.Cache.String()))
+	}
+
+	// not found is an expected result, not a failure
+	if span.Err != nil && !errors.Is(span.Err, sql.ErrNoRows) {
+		s.RecordError(span.Err)
+		s.SetStatus(codes.Error, span.Err.Error())
+	}
+
+	s.End(trace.WithTimestamp(span.EndTime))
+}
+
+// QueryTags is an entity.QueryTagger returning the W3C traceparent and tracestate of the span in ctx,
+// so the statements can be correlated with traces on the database side.
+//
+// Example:
+//
+//	entity.RegisterQueryTagger(entityotel.QueryTags)
+func QueryTags(ctx context.Context) map[string]string {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier
+}
@@ -0,0 +1,71 @@
+package entityotel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/joyparty/entity"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestObserver(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	o := NewObserver(WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))))
+
+	span := &entity.Span{
+		Name:         "update",
+		Table:        "users",
+		Statement:    `UPDATE "users" SET "name" = $1 WHERE "id" = $2`,
+		RowsAffected: 1,
+		Err:          errors.New("failed"),
+		StartTime:    time.Now(),
+	}
+	ctx := o.SpanStart(context.Background(), span)
+	span.EndTime = time.Now()
+	o.SpanEnd(ctx, span)
+
+	ended := recorder.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("ended spans, Expected=1, Actual=%d", len(ended))
+	}
+
+	s := ended[0]
+	if s.Name() != "entity.update" {
+		t.Fatalf("span name, Expected=entity.update, Actual=%s", s.Name())
+	} else if s.Status().Code != codes.Error {
+		t.Fatalf("span status, Expected=%v, Actual=%v", codes.Error, s.Status().Code)
+	}
+
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range s.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if v := attrs["db.collection.name"].AsString(); v != "users" {
+		t.Fatalf("table attribute, Expected=users, Actual=%s", v)
+	} else if v := attrs["db.query.text"].AsString(); v != span.Statement {
+		t.Fatalf("statement attribute, Expected=%s, Actual=%s", span.Statement, v)
+	} else if v := attrs["db.response.rows_affected"].AsInt64(); v != 1 {
+		t.Fatalf("rows attribute, Expected=1, Actual=%d", v)
+	}
+}
+
+func TestQueryTags(t *testing.T) {
+	if tags := QueryTags(context.Background()); tags != nil {
+		t.Fatalf("tags without span, Expected=nil, Actual=%v", tags)
+	}
+
+	tp := sdktrace.NewTracerProvider()
+	ctx, span := tp.Tracer("test").Start(context.Background(), "test")
+	defer span.End()
+
+	sc := span.SpanContext()
+	expected := "00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-01"
+	if tags := QueryTags(ctx); tags["traceparent"] != expected {
+		t.Fatalf("traceparent, Expected=%s, Actual=%s", expected, tags["traceparent"])
+	}
+}
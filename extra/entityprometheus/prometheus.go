@@ -0,0 +1,134 @@
+// Package entityprometheus collects the metrics of entity operations, cache loading and transactions for Prometheus.
+//
+// Example:
+//
+//	o := entityprometheus.NewObserver()
+//	prometheus.MustRegister(o)
+//	entity.RegisterObserver(o)
+package entityprometheus
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/joyparty/entity"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Option is an option for NewObserver.
+type Option func(*options)
+
+type options struct {
+	namespace   string
+	buckets     []float64
+	constLabels prometheus.Labels
+}
+
+// WithNamespace sets the namespace of metrics, default "entity".
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithBuckets sets the buckets of the duration histogram in seconds, default prometheus.DefBuckets.
+func WithBuckets(buckets []float64) Option {
+	return func(o *options) {
+		o.buckets = buckets
+	}
+}
+
+// WithConstLabels adds constant labels to all the metrics, such as the name of database.
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(o *options) {
+		o.constLabels = labels
+	}
+}
+
+// Observer records the metrics of entity spans, it's also a prometheus.Collector to be registered.
+//
+// Metrics:
+//
+//   - {namespace}_operation_duration_seconds histogram, labels: operation, table, status
+//   - {namespace}_rows_affected_total counter, labels: operation, table
+//   - {namespace}_cache_requests_total counter, labels: table, result
+type Observer struct {
+	duration *prometheus.HistogramVec
+	rows     *prometheus.CounterVec
+	cache    *prometheus.CounterVec
+}
+
+// NewObserver creates a new Observer.
+func NewObserver(opts ...Option) *Observer {
+	o := &options{
+		namespace: "entity",
+		buckets:   prometheus.DefBuckets,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return &Observer{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   o.namespace,
+			Name:        "operation_duration_seconds",
+			Help:        "Duration of entity operations, cache loading and transactions.",
+			Buckets:     o.buckets,
+			ConstLabels: o.constLabels,
+		}, []string{"operation", "table", "status"}),
+		rows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   o.namespace,
+			Name:        "rows_affected_total",
+			Help:        "Number of rows affected or returned by entity operations.",
+			ConstLabels: o.constLabels,
+		}, []string{"operation", "table"}),
+		cache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   o.namespace,
+			Name:        "cache_requests_total",
+			Help:        "Number of entity cache requests by result.",
+			ConstLabels: o.constLabels,
+		}, []string{"table", "result"}),
+	}
+}
+
+// SpanStart implements entity.Observer.
+func (o *Observer) SpanStart(ctx context.Context, _ *entity.Span) context.Context {
+	return ctx
+}
+
+// SpanEnd implements entity.Observer.
+func (o *Observer) SpanEnd(_ context.Context, span *entity.Span) {
+	status := "ok"
+	if span.Err != nil {
+		// not found is an expected result, not a failure
+		if errors.Is(span.Err, sql.ErrNoRows) {
+			status = "not_found"
+		} else {
+			status = "error"
+		}
+	}
+	o.duration.WithLabelValues(span.Name, span.Table, status).Observe(span.Duration().Seconds())
+
+	if span.RowsAffected > 0 {
+		o.rows.WithLabelValues(span.Name, span.Table).Add(float64(span.RowsAffected))
+	}
+
+	if span.Name == entity.SpanCache && span.Cache != entity.CacheUnused {
+		o.cache.WithLabelValues(span.Table, span.Cache.String()).Inc()
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (o *Observer) Describe(ch chan<- *prometheus.Desc) {
+	o.duration.Describe(ch)
+	o.rows.Describe(ch)
+	o.cache.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (o *Observer) Collect(ch chan<- prometheus.Metric) {
+	o.duration.Collect(ch)
+	o.rows.Collect(ch)
+	o.cache.Collect(ch)
+}
@@ -0,0 +1,44 @@
+package entityprometheus
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joyparty/entity"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserver(t *testing.T) {
+	o := NewObserver()
+
+	now := time.Now()
+	spans := []*entity.Span{
+		{Name: "update", Table: "users", RowsAffected: 2, StartTime: now, EndTime: now.Add(time.Millisecond)},
+		{Name: "load", Table: "users", RowsAffected: 0, Err: sql.ErrNoRows, StartTime: now, EndTime: now},
+		{Name: entity.SpanCache, Table: "users", Cache: entity.CacheHit, RowsAffected: -1, StartTime: now, EndTime: now},
+		{Name: entity.SpanCache, Table: "users", Cache: entity.CacheMiss, RowsAffected: -1, StartTime: now, EndTime: now},
+	}
+	for _, span := range spans {
+		o.SpanEnd(o.SpanStart(context.Background(), span), span)
+	}
+
+	expected := `
+# HELP entity_cache_requests_total Number of entity cache requests by result.
+# TYPE entity_cache_requests_total counter
+entity_cache_requests_total{result="hit",table="users"} 1
+entity_cache_requests_total{result="miss",table="users"} 1
+# HELP entity_rows_affected_total Number of rows affected or returned by entity operations.
+# TYPE entity_rows_affected_total counter
+entity_rows_affected_total{operation="update",table="users"} 2
+`
+	if err := testutil.CollectAndCompare(o, strings.NewReader(expected), "entity_cache_requests_total", "entity_rows_affected_total"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := testutil.CollectAndCount(o, "entity_operation_duration_seconds"); n != 3 {
+		t.Fatalf("duration series, Expected=3, Actual=%d", n)
+	}
+}
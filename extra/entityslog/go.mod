@@ -0,0 +1,12 @@
+module github.com/joyparty/entity/extra/entityslog
+
+go 1.21
+
+replace github.com/joyparty/entity => ../..
+
+require github.com/joyparty/entity v0.0.0-00010101000000-000000000000
+
+require (
+	github.com/doug-martin/goqu/v9 v9.19.0 // indirect
+	github.com/jmoiron/sqlx v1.4.0 // indirect
+)
@@ -0,0 +1,113 @@
+// Package entityslog logs the statements executed by entity with log/slog.
+//
+// Example:
+//
+//	entity.SetStatementLogger(entityslog.New(slog.Default(),
+//		entityslog.WithSlowThreshold(200*time.Millisecond),
+//		entityslog.WithSampleRate(0.1),
+//	))
+package entityslog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"github.com/joyparty/entity"
+)
+
+// Option is an option for New.
+type Option func(*Logger)
+
+// WithLevel sets the level of the statements neither failed nor slow, default slog.LevelDebug.
+func WithLevel(level slog.Level) Option {
+	return func(l *Logger) {
+		l.level = level
+	}
+}
+
+// WithSlowThreshold logs the statements taking at least d at slog.LevelWarn, regardless of sampling.
+// Zero disables it, which is the default.
+func WithSlowThreshold(d time.Duration) Option {
+	return func(l *Logger) {
+		l.slowThreshold = d
+	}
+}
+
+// WithSampleRate logs only a fraction of the statements neither failed nor slow, rate is in [0, 1], default 1.
+func WithSampleRate(rate float64) Option {
+	return func(l *Logger) {
+		l.sampleRate = rate
+	}
+}
+
+// Logger implements entity.StatementLogger with slog.
+//
+// Failed statements are logged at slog.LevelError, except for no rows found.
+type Logger struct {
+	logger        *slog.Logger
+	level         slog.Level
+	slowThreshold time.Duration
+	sampleRate    float64
+}
+
+// New creates a new Logger.
+func New(logger *slog.Logger, opts ...Option) *Logger {
+	l := &Logger{
+		logger:     logger,
+		level:      slog.LevelDebug,
+		sampleRate: 1,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// SampleStatement implements entity.StatementSampler, the arguments are needed for every statement
+// if the slow threshold is set, because a statement may turn out to be slow.
+func (l *Logger) SampleStatement(ctx context.Context) bool {
+	if l.slowThreshold > 0 {
+		return true
+	}
+	return l.sampled() && l.logger.Enabled(ctx, l.level)
+}
+
+// LogStatement implements entity.StatementLogger.
+func (l *Logger) LogStatement(ctx context.Context, log entity.StatementLog) {
+	level, msg := l.level, "sql statement"
+	if log.Err != nil && !errors.Is(log.Err, sql.ErrNoRows) {
+		level, msg = slog.LevelError, "sql statement failed"
+	} else if l.slowThreshold > 0 && log.Duration >= l.slowThreshold {
+		level, msg = slog.LevelWarn, "slow sql statement"
+	} else if log.Args == nil && l.slowThreshold == 0 {
+		// not sampled by SampleStatement
+		return
+	} else if l.slowThreshold > 0 && !l.sampled() {
+		return
+	}
+
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("statement", log.Statement),
+		slog.Any("args", log.Args),
+		slog.Duration("duration", log.Duration),
+	}
+	if log.RowsAffected >= 0 {
+		attrs = append(attrs, slog.Int64("rows_affected", log.RowsAffected))
+	}
+	if log.Err != nil {
+		attrs = append(attrs, slog.Any("error", log.Err))
+	}
+	l.logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+func (l *Logger) sampled() bool {
+	return l.sampleRate >= 1 || rand.Float64() < l.sampleRate
+}
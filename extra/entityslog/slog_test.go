@@ -0,0 +1,76 @@
+package entityslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joyparty/entity"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		WithSlowThreshold(100*time.Millisecond),
+		WithSampleRate(0),
+	)
+
+	ctx := context.Background()
+	l.LogStatement(ctx, entity.StatementLog{Statement: "SELECT 1", Duration: time.Millisecond, RowsAffected: 1})
+	l.LogStatement(ctx, entity.StatementLog{
+		Statement:    `UPDATE "users" SET "password" = :password WHERE "id" = :id`,
+		Args:         []any{entity.Redacted, 1},
+		Duration:     time.Second,
+		RowsAffected: 1,
+	})
+	l.LogStatement(ctx, entity.StatementLog{Statement: "DELETE", Err: errors.New("failed"), RowsAffected: -1})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("logged lines, Expected=2, Actual=%d\n%s", len(lines), buf.String())
+	}
+
+	expected := []string{"WARN", "ERROR"}
+	for i, line := range lines {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		} else if record["level"] != expected[i] {
+			t.Fatalf("level of line %d, Expected=%s, Actual=%v", i, expected[i], record["level"])
+		}
+	}
+
+	if !strings.Contains(lines[0], `"args":["[REDACTED]",1]`) {
+		t.Fatalf("args should be logged, Actual=%s", lines[0])
+	}
+}
+
+func TestLoggerSampleStatement(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	ctx := context.Background()
+
+	if !New(logger, WithSlowThreshold(time.Second), WithSampleRate(0)).SampleStatement(ctx) {
+		t.Fatal("statements should be sampled with slow threshold")
+	} else if New(logger, WithSampleRate(0)).SampleStatement(ctx) {
+		t.Fatal("statements should not be sampled with zero sample rate")
+	} else if !New(logger).SampleStatement(ctx) {
+		t.Fatal("statements should be sampled by default")
+	} else if New(logger, WithLevel(slog.LevelDebug-1)).SampleStatement(ctx) {
+		t.Fatal("statements should not be sampled if the level is disabled")
+	}
+
+	l := New(logger)
+	l.LogStatement(ctx, entity.StatementLog{Statement: "SELECT 1", RowsAffected: 1})
+	l.LogStatement(ctx, entity.StatementLog{Statement: "SELECT 2", Args: []any{}, RowsAffected: 1})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], "SELECT 2") {
+		t.Fatalf("only the sampled statement should be logged\n%s", buf.String())
+	}
+}
@@ -6,11 +6,18 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"reflect"
 	"regexp"
 	"strings"
 
 	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
 	"github.com/jmoiron/sqlx"
+
+	// register the goqu dialects of supported drivers
+	_ "github.com/doug-martin/goqu/v9/dialect/mysql"
+	_ "github.com/doug-martin/goqu/v9/dialect/postgres"
+	_ "github.com/doug-martin/goqu/v9/dialect/sqlite3"
 )
 
 // Package entity provides convenient wrapper functions for goqu query builder.
@@ -25,7 +32,7 @@ func ExecInsert(ctx context.Context, db DB, stmt *goqu.InsertDataset) (sql.Resul
 	if err != nil {
 		return nil, fmt.Errorf("build insert statement, %w", err)
 	}
-	return db.ExecContext(ctx, query, args...)
+	return execContext(ctx, db, query, args)
 }
 
 // ExecUpdate executes an update statement.
@@ -38,7 +45,7 @@ func ExecUpdate(ctx context.Context, db DB, stmt *goqu.UpdateDataset) (sql.Resul
 	if err != nil {
 		return nil, fmt.Errorf("build update statement, %w", err)
 	}
-	return db.ExecContext(ctx, query, args...)
+	return execContext(ctx, db, query, args)
 }
 
 // ExecDelete executes a delete statement.
@@ -51,7 +58,19 @@ func ExecDelete(ctx context.Context, db DB, stmt *goqu.DeleteDataset) (sql.Resul
 	if err != nil {
 		return nil, fmt.Errorf("build delete statement, %w", err)
 	}
-	return db.ExecContext(ctx, query, args...)
+	return execContext(ctx, db, query, args)
+}
+
+func execContext(ctx context.Context, db DB, query string, args []any) (sql.Result, error) {
+	ctx = startStatementLog(ctx, query, positionalArgs(ctx, args))
+
+	result, err := db.ExecContext(ctx, commentStatement(ctx, query), args...)
+	if err == nil {
+		logResult(ctx, result)
+	}
+	endStatementLog(ctx, err)
+
+	return result, err
 }
 
 // GetRecord executes a select query and returns a single result.
@@ -64,7 +83,17 @@ func GetRecord(ctx context.Context, dest any, db DB, stmt *goqu.SelectDataset) e
 	if err != nil {
 		return fmt.Errorf("build select statement, %w", err)
 	}
-	return db.GetContext(ctx, dest, query, args...)
+
+	ctx = startStatementLog(ctx, query, positionalArgs(ctx, args))
+	err = db.GetContext(ctx, dest, commentStatement(ctx, query), args...)
+	if err == nil {
+		logRows(ctx, 1)
+	} else if errors.Is(err, sql.ErrNoRows) {
+		logRows(ctx, 0)
+	}
+	endStatementLog(ctx, err)
+
+	return err
 }
 
 // GetRecords executes a select query and returns multiple results.
@@ -77,7 +106,17 @@ func GetRecords(ctx context.Context, dest any, db DB, stmt *goqu.SelectDataset)
 	if err != nil {
 		return fmt.Errorf("build select statement, %w", err)
 	}
-	return db.SelectContext(ctx, dest, query, args...)
+
+	ctx = startStatementLog(ctx, query, positionalArgs(ctx, args))
+	err = db.SelectContext(ctx, dest, commentStatement(ctx, query), args...)
+	if err == nil {
+		if v := reflect.Indirect(reflect.ValueOf(dest)); v.Kind() == reflect.Slice {
+			logRows(ctx, int64(v.Len()))
+		}
+	}
+	endStatementLog(ctx, err)
+
+	return err
 }
 
 // GetTotalCount returns the total number of records matching the query conditions.
@@ -132,7 +171,8 @@ func TryTransaction[T Tx](db DB, fn func(db DB) error) error {
 // TryTransactionX attempts to execute a function within a transaction with context support.
 // If the database is already a transaction, the function is executed directly.
 // If it's a transaction initiator, a transaction is started.
-// The specific Tx type must be explicitly specified as it cannot be derived from the DB interface.
+// The specific Tx type must be explicitly specified as it cannot be derived from the DB interface,
+// the databases beginning Tx, such as ClusterDB and the one returned by WrapDB, are accepted for any T.
 //
 // Example: TryTransactionX[*sqlx.Tx](ctx, db, func(db entity.DB) error { ... })
 func TryTransactionX[T Tx](ctx context.Context, db DB, fn func(db DB) error) error {
@@ -140,6 +180,10 @@ func TryTransactionX[T Tx](ctx context.Context, db DB, fn func(db DB) error) err
 		return fn(v)
 	} else if v, ok := db.(TxInitiator[T]); ok {
 		return TransactionX(ctx, v, fn)
+	} else if v, ok := db.(Tx); ok {
+		return fn(v)
+	} else if v, ok := db.(TxInitiator[Tx]); ok {
+		return TransactionX[Tx](ctx, v, fn)
 	}
 
 	var x T
@@ -156,7 +200,8 @@ func TryTransactionWithOptions[T Tx](db DB, opt *sql.TxOptions, fn func(db DB) e
 // TryTransactionWithOptionsX attempts to execute a function within a transaction with context and custom options.
 // If the database is already a transaction, the function is executed directly.
 // If it's a transaction initiator, a transaction is started.
-// The specific Tx type must be explicitly specified as it cannot be derived from the DB interface.
+// The specific Tx type must be explicitly specified as it cannot be derived from the DB interface,
+// the databases beginning Tx, such as ClusterDB and the one returned by WrapDB, are accepted for any T.
 //
 // Example: TryTransactionWithOptionsX[*sqlx.Tx](ctx, db, opt, func(db entity.DB) error { ... })
 func TryTransactionWithOptionsX[T Tx](ctx context.Context, db DB, opt *sql.TxOptions, fn func(db DB) error) error {
@@ -164,13 +209,50 @@ func TryTransactionWithOptionsX[T Tx](ctx context.Context, db DB, opt *sql.TxOpt
 		return fn(v)
 	} else if v, ok := db.(TxInitiator[T]); ok {
 		return TransactionWithOptionsX(ctx, v, opt, fn)
+	} else if v, ok := db.(Tx); ok {
+		return fn(v)
+	} else if v, ok := db.(TxInitiator[Tx]); ok {
+		return TransactionWithOptionsX[Tx](ctx, v, opt, fn)
 	}
 
 	var x T
 	return fmt.Errorf("db is neither %T nor TxInitiator[%T]", x, x)
 }
 
+// tryTransaction runs fn within a transaction of *sqlx.Tx or Tx, or directly if db is already a transaction.
+func tryTransaction(ctx context.Context, db DB, fn func(db DB) error) error {
+	if _, ok := db.(Tx); ok {
+		return fn(db)
+	}
+
+	switch v := db.(type) {
+	case TxInitiator[*sqlx.Tx]:
+		return TransactionX[*sqlx.Tx](ctx, v, fn)
+	case TxInitiator[Tx]:
+		return TransactionX[Tx](ctx, v, fn)
+	}
+	return fmt.Errorf("db %T can not begin transaction", db)
+}
+
+// canBeginTx reports whether tryTransaction can run fn on db,
+// ShardedDB begins a transaction only on the shard located by the shard key of ctx.
+func canBeginTx(ctx context.Context, db DB) bool {
+	switch db.(type) {
+	case *ShardedDB:
+		_, ok := ShardKeyFromContext(ctx)
+		return ok
+	case Tx, TxInitiator[*sqlx.Tx], TxInitiator[Tx]:
+		return true
+	}
+	return false
+}
+
 func runTransaction[T Tx, U TxInitiator[T]](ctx context.Context, db U, opt *sql.TxOptions, fn func(db DB) error) (err error) {
+	ctx, span := startSpan(ctx, SpanTransaction, "")
+	defer func() {
+		endSpan(ctx, span, err)
+	}()
+
 	tx, err := db.BeginTxx(ctx, opt)
 	if err != nil {
 		return fmt.Errorf("begin transaction, %w", err)
@@ -251,19 +333,30 @@ func SavePoint(ctx context.Context, tx Tx, name string, fn func() error) (err er
 }
 
 // QueryBy executes a select query and processes the result set using the provided callback function.
-func QueryBy(ctx context.Context, db DB, stmt *goqu.SelectDataset, fn func(ctx context.Context, rows *sqlx.Rows) error) error {
+// The values of a statement not prepared are inlined into the SQL, so they are logged by the statement logger as is.
+func QueryBy(ctx context.Context, db DB, stmt *goqu.SelectDataset, fn func(ctx context.Context, rows *sqlx.Rows) error) (err error) {
 	query, args, err := stmt.ToSQL()
 	if err != nil {
 		return fmt.Errorf("build sql, %w", err)
 	}
 
-	rows, err := db.QueryxContext(ctx, query, args...)
+	// the duration includes handling the rows, which are read lazily
+	ctx = startStatementLog(ctx, query, positionalArgs(ctx, args))
+	var n int64
+	defer func() {
+		logRows(ctx, n)
+		endStatementLog(ctx, err)
+	}()
+
+	rows, err := db.QueryxContext(ctx, commentStatement(ctx, query), args...)
 	if err != nil {
 		return fmt.Errorf("execute query, %w", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
+		n++
+
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
@@ -318,6 +411,19 @@ func NewUpsertTarget(ent Entity) string {
 	return strings.Join(target, ", ")
 }
 
+// Dialect returns the goqu dialect matching the database driver.
+func Dialect(db DB) goqu.DialectWrapper {
+	return goqu.Dialect(dbDriver(db))
+}
+
+// TableIdentifier converts a table name, optionally qualified by schema, to goqu identifier.
+func TableIdentifier(name string) exp.IdentifierExpression {
+	if i := strings.LastIndex(name, "."); i > 0 {
+		return goqu.S(name[:i]).Table(name[i+1:])
+	}
+	return goqu.T(name)
+}
+
 // Pagination contains pagination calculation information for database queries.
 type Pagination struct {
 	First    int `json:"first"`
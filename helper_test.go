@@ -3,6 +3,9 @@ package entity
 import (
 	"reflect"
 	"testing"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
 )
 
 func TestPagination(t *testing.T) {
@@ -65,3 +68,40 @@ func TestPagination(t *testing.T) {
 		}
 	}
 }
+
+func TestTableIdentifier(t *testing.T) {
+	cases := map[string]string{
+		"users":        `SELECT * FROM "users"`,
+		"public.users": `SELECT * FROM "public"."users"`,
+	}
+
+	for name, expected := range cases {
+		query, _, err := goqu.From(TableIdentifier(name)).ToSQL()
+		if err != nil {
+			t.Fatal(err)
+		} else if query != expected {
+			t.Fatalf("table %q, Expected=%s, Actual=%s", name, expected, query)
+		}
+	}
+}
+
+func TestDialect(t *testing.T) {
+	cases := map[string]string{
+		driverMysql:    "SELECT * FROM `users` WHERE (`id` = ?)",
+		driverPostgres: `SELECT * FROM "users" WHERE ("id" = $1)`,
+		driverSqlite3:  "SELECT * FROM `users` WHERE (`id` = ?)",
+	}
+
+	for driver, expected := range cases {
+		query, _, err := Dialect(sqlx.NewDb(nil, driver)).
+			From("users").
+			Where(goqu.C("id").Eq(1)).
+			Prepared(true).
+			ToSQL()
+		if err != nil {
+			t.Fatal(err)
+		} else if query != expected {
+			t.Fatalf("driver %q, Expected=%s, Actual=%s", driver, expected, query)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package entity
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/jmoiron/sqlx/reflectx"
+)
+
+var (
+	hookReceivers = &sync.Map{}
+
+	// method names of the specific hooks, EventHook is called for every event
+	hookMethods = map[Event]string{
+		EventBeforeInsert: "BeforeInsert",
+		EventAfterInsert:  "AfterInsert",
+		EventBeforeUpdate: "BeforeUpdate",
+		EventAfterUpdate:  "AfterUpdate",
+		EventBeforeDelete: "BeforeDelete",
+		EventAfterDelete:  "AfterDelete",
+		EventAfterLoad:    "AfterLoad",
+		EventBeforeUpsert: "BeforeUpsert",
+		EventAfterUpsert:  "AfterUpsert",
+		EventAfterFind:    "AfterFind",
+	}
+
+	eventHookMethod = "OnEntityEvent"
+)
+
+// hookReceiver is the entity, or an embedded struct, that the hook methods are called on.
+type hookReceiver struct {
+	// index of the embedded field where the methods come from, empty for the entity itself
+	index []int
+	// length of the index prefix of the struct the methods are called on,
+	// the methods promoted to the outer struct are called on the outer one, so overriding methods are respected
+	owner int
+	// names of the hook methods
+	methods map[string]bool
+}
+
+// runHooks calls all the hooks of the event implemented by the entity.
+//
+// Hooks coming from embedded structs are called before the ones declared by the outer struct,
+// for each struct, the specific hook (such as BeforeInsert) is called before EventHook.
+// A hook overriding the one of an embedded struct replaces it, as the method of outer struct does in Go,
+// so it should call the embedded one explicitly if needed.
+func runHooks(ctx context.Context, ent Entity, ev Event) error {
+	rv := reflect.ValueOf(ent)
+
+	for _, receiver := range getHookReceivers(rv.Type()) {
+		v, ok := receiver.value(ent, rv)
+		if !ok {
+			continue
+		}
+
+		if name := hookMethods[ev]; receiver.methods[name] {
+			if err := callHook(ctx, v, ev); err != nil {
+				return err
+			}
+		}
+
+		if receiver.methods[eventHookMethod] {
+			if h, ok := v.(EventHook); ok {
+				if err := h.OnEntityEvent(ctx, ev); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func callHook(ctx context.Context, v any, ev Event) error {
+	switch ev {
+	case EventBeforeInsert:
+		if h, ok := v.(BeforeInsertHook); ok {
+			return h.BeforeInsert(ctx)
+		}
+	case EventAfterInsert:
+		if h, ok := v.(AfterInsertHook); ok {
+			return h.AfterInsert(ctx)
+		}
+	case EventBeforeUpdate:
+		if h, ok := v.(BeforeUpdateHook); ok {
+			return h.BeforeUpdate(ctx)
+		}
+	case EventAfterUpdate:
+		if h, ok := v.(AfterUpdateHook); ok {
+			return h.AfterUpdate(ctx)
+		}
+	case EventBeforeDelete:
+		if h, ok := v.(BeforeDeleteHook); ok {
+			return h.BeforeDelete(ctx)
+		}
+	case EventAfterDelete:
+		if h, ok := v.(AfterDeleteHook); ok {
+			return h.AfterDelete(ctx)
+		}
+	case EventAfterLoad:
+		if h, ok := v.(AfterLoadHook); ok {
+			return h.AfterLoad(ctx)
+		}
+	case EventBeforeUpsert:
+		if h, ok := v.(BeforeUpsertHook); ok {
+			return h.BeforeUpsert(ctx)
+		}
+	case EventAfterUpsert:
+		if h, ok := v.(AfterUpsertHook); ok {
+			return h.AfterUpsert(ctx)
+		}
+	case EventAfterFind:
+		if h, ok := v.(AfterFindHook); ok {
+			return h.AfterFind(ctx)
+		}
+	}
+	return nil
+}
+
+// value returns the receiver value of the entity, false if any embedded pointer to the methods is nil.
+func (hr hookReceiver) value(ent Entity, rv reflect.Value) (any, bool) {
+	var receiver reflect.Value
+
+	v := reflect.Indirect(rv)
+	for i, fi := range hr.index {
+		if i == hr.owner {
+			receiver = v
+		}
+
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return nil, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(fi)
+	}
+
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil, false
+	} else if hr.owner == len(hr.index) {
+		receiver = v
+	}
+
+	if hr.owner == 0 {
+		return ent, true
+	} else if receiver.Kind() == reflect.Ptr {
+		return receiver.Interface(), true
+	} else if receiver.CanAddr() {
+		return receiver.Addr().Interface(), true
+	}
+	return receiver.Interface(), true
+}
+
+func getHookReceivers(t reflect.Type) []hookReceiver {
+	if v, ok := hookReceivers.Load(t); ok {
+		return v.([]hookReceiver)
+	}
+
+	var receivers []hookReceiver
+	if st := reflectx.Deref(t); st.Kind() == reflect.Struct {
+		receivers = collectHookReceivers(st)
+	}
+
+	hookReceivers.Store(t, receivers)
+	return receivers
+}
+
+// collectHookReceivers finds the receiver of each hook method of the struct type.
+//
+// A method in the method set of the outer struct is called on the outer struct,
+// it's traced down through the embedded fields having the same method to find where it comes from,
+// the receivers are ordered by the embedded fields in depth-first order, the inner ones come first.
+func collectHookReceivers(t reflect.Type) []hookReceiver {
+	names := []string{eventHookMethod}
+	for _, name := range hookMethods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var receivers []hookReceiver
+	for _, name := range names {
+		receivers = resolveHookReceiver(receivers, t, nil, name)
+	}
+
+	order := map[string]int{}
+	walkEmbedded(t, nil, func(index []int) {
+		order[fmt.Sprint(index)] = len(order)
+	})
+	sort.SliceStable(receivers, func(i, j int) bool {
+		return order[fmt.Sprint(receivers[i].index)] < order[fmt.Sprint(receivers[j].index)]
+	})
+	return receivers
+}
+
+func resolveHookReceiver(receivers []hookReceiver, t reflect.Type, index []int, name string) []hookReceiver {
+	if !hasMethod(t, name) {
+		// not in the method set, it may be declared by more than one embedded struct at the same depth
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if ft := reflectx.Deref(field.Type); field.Anonymous && field.IsExported() && ft.Kind() == reflect.Struct {
+				receivers = resolveHookReceiver(receivers, ft, appendIndex(index, i), name)
+			}
+		}
+		return receivers
+	}
+
+	owner := len(index)
+	for {
+		i, ft, ok := embeddedMethod(t, name)
+		if !ok {
+			break
+		}
+		index, t = appendIndex(index, i), ft
+	}
+
+	for i, r := range receivers {
+		if r.owner == owner && reflect.DeepEqual(r.index, index) {
+			receivers[i].methods[name] = true
+			return receivers
+		}
+	}
+	return append(receivers, hookReceiver{
+		index:   index,
+		owner:   owner,
+		methods: map[string]bool{name: true},
+	})
+}
+
+// embeddedMethod returns the only embedded struct field whose method set has the method.
+func embeddedMethod(t reflect.Type, name string) (int, reflect.Type, bool) {
+	found := -1
+	var typ reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if ft := reflectx.Deref(field.Type); field.Anonymous && ft.Kind() == reflect.Struct && hasMethod(ft, name) {
+			if found >= 0 {
+				return 0, nil, false
+			}
+			found, typ = i, ft
+		}
+	}
+	return found, typ, found >= 0
+}
+
+// hasMethod reports whether the method set of pointer to the struct type has the method.
+func hasMethod(t reflect.Type, name string) bool {
+	_, ok := reflect.PtrTo(t).MethodByName(name)
+	return ok
+}
+
+// walkEmbedded visits the embedded struct fields in depth-first order, the inner ones first, then the struct itself.
+func walkEmbedded(t reflect.Type, index []int, visit func(index []int)) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if ft := reflectx.Deref(field.Type); field.Anonymous && ft.Kind() == reflect.Struct {
+			walkEmbedded(ft, appendIndex(index, i), visit)
+		}
+	}
+	visit(index)
+}
+
+func appendIndex(index []int, i int) []int {
+	result := make([]int, len(index), len(index)+1)
+	copy(result, index)
+	return append(result, i)
+}
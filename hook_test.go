@@ -0,0 +1,244 @@
+package entity
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestRunHooks(t *testing.T) {
+	cases := []struct {
+		ent      hookedEntity
+		ev       Event
+		expected []string
+	}{
+		{
+			ent:      &hookedOuter{},
+			ev:       EventBeforeInsert,
+			expected: []string{"base event", "outer before insert"},
+		},
+		{
+			ent:      &hookedOuter{},
+			ev:       EventBeforeUpdate,
+			expected: []string{"base event"},
+		},
+		{
+			ent:      &hookedOverride{},
+			ev:       EventBeforeInsert,
+			expected: []string{"override event"},
+		},
+		{
+			ent:      &hookedExplicit{},
+			ev:       EventBeforeInsert,
+			expected: []string{"base event", "explicit event"},
+		},
+		{
+			ent:      &hookedPointer{},
+			ev:       EventBeforeInsert,
+			expected: nil,
+		},
+		{
+			ent:      &hookedPointer{hookedBase: &hookedBase{}},
+			ev:       EventBeforeInsert,
+			expected: nil,
+		},
+		{
+			ent:      &hookedPointer{HookedBase: &HookedBase{}},
+			ev:       EventBeforeInsert,
+			expected: []string{"base event"},
+		},
+		{
+			ent:      &hookedAmbiguous{},
+			ev:       EventBeforeInsert,
+			expected: []string{"base event", "audit event"},
+		},
+		{
+			ent:      &hookedUnexported{},
+			ev:       EventAfterFind,
+			expected: []string{"unexported after find"},
+		},
+	}
+
+	for _, c := range cases {
+		if err := runHooks(context.Background(), c.ent, c.ev); err != nil {
+			t.Fatal(err)
+		}
+
+		if actual := c.ent.getCalls(); !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("%T hooks of event %d, Expected=%v, Actual=%v", c.ent, c.ev, c.expected, actual)
+		}
+	}
+}
+
+type hookedEntity interface {
+	Entity
+	getCalls() []string
+}
+
+type hookCalls struct {
+	calls []string
+}
+
+func (hc *hookCalls) getCalls() []string {
+	return hc.calls
+}
+
+type HookedBase struct {
+	hookCalls
+}
+
+func (hb *HookedBase) TableName() string {
+	return "hooked"
+}
+
+func (hb *HookedBase) OnEntityEvent(_ context.Context, _ Event) error {
+	hb.calls = append(hb.calls, "base event")
+	return nil
+}
+
+type hookedOuter struct {
+	HookedBase
+}
+
+func (ho *hookedOuter) BeforeInsert(_ context.Context) error {
+	ho.calls = append(ho.calls, "outer before insert")
+	return nil
+}
+
+// hookedOverride shadows the hook of embedded struct, only the outer one is called.
+type hookedOverride struct {
+	HookedBase
+}
+
+func (ho *hookedOverride) OnEntityEvent(_ context.Context, _ Event) error {
+	ho.calls = append(ho.calls, "override event")
+	return nil
+}
+
+// hookedExplicit overrides the hook of embedded struct and calls it explicitly.
+type hookedExplicit struct {
+	HookedBase
+}
+
+func (he *hookedExplicit) OnEntityEvent(ctx context.Context, ev Event) error {
+	if err := he.HookedBase.OnEntityEvent(ctx, ev); err != nil {
+		return err
+	}
+	he.calls = append(he.calls, "explicit event")
+	return nil
+}
+
+type hookedBase struct {
+	calls []string
+}
+
+func (hb *hookedBase) AfterFind(_ context.Context) error {
+	hb.calls = append(hb.calls, "unexported after find")
+	return nil
+}
+
+type hookedUnexported struct {
+	hookedBase
+}
+
+func (hu *hookedUnexported) TableName() string {
+	return "hooked"
+}
+
+func (hu *hookedUnexported) getCalls() []string {
+	return hu.calls
+}
+
+type hookedPointer struct {
+	*hookedBase
+	*HookedBase
+
+	calls []string
+}
+
+func (hp *hookedPointer) TableName() string {
+	return "hooked"
+}
+
+func (hp *hookedPointer) getCalls() []string {
+	if hp.HookedBase != nil {
+		return hp.HookedBase.calls
+	}
+	return hp.calls
+}
+
+type HookedAudit struct {
+	calls []string
+}
+
+func (ha *HookedAudit) OnEntityEvent(_ context.Context, _ Event) error {
+	ha.calls = append(ha.calls, "audit event")
+	return nil
+}
+
+// hookedAmbiguous has no OnEntityEvent in its method set, both embedded ones are called.
+type hookedAmbiguous struct {
+	HookedBase
+	HookedAudit
+}
+
+func (ha *hookedAmbiguous) getCalls() []string {
+	return append(ha.HookedBase.calls, ha.HookedAudit.calls...)
+}
+
+// upsertHooked records the hooks called by Upsert.
+type upsertHooked struct {
+	ID   int64  `db:"id,primaryKey"`
+	Name string `db:"name"`
+
+	calls []string
+}
+
+func (u *upsertHooked) TableName() string {
+	return "users"
+}
+
+func (u *upsertHooked) BeforeInsert(context.Context) error {
+	u.calls = append(u.calls, "before insert")
+	return nil
+}
+
+func (u *upsertHooked) AfterInsert(context.Context) error {
+	u.calls = append(u.calls, "after insert")
+	return nil
+}
+
+func (u *upsertHooked) BeforeUpdate(context.Context) error {
+	u.calls = append(u.calls, "before update")
+	return nil
+}
+
+func (u *upsertHooked) AfterUpdate(context.Context) error {
+	u.calls = append(u.calls, "after update")
+	return nil
+}
+
+func (u *upsertHooked) BeforeUpsert(context.Context) error {
+	u.calls = append(u.calls, "before upsert")
+	return nil
+}
+
+func (u *upsertHooked) AfterUpsert(context.Context) error {
+	u.calls = append(u.calls, "after upsert")
+	return nil
+}
+
+func TestUpsertHooks(t *testing.T) {
+	db := newSQLiteDB(t, sqliteUserTable)
+	ctx := context.Background()
+
+	// the insert and update hooks are not called, whether the row is inserted or updated
+	for _, name := range []string{"insert", "update"} {
+		ent := &upsertHooked{ID: 1, Name: name}
+		if err := Upsert(ctx, ent, db); err != nil {
+			t.Fatal(err)
+		} else if expected := []string{"before upsert", "after upsert"}; !reflect.DeepEqual(ent.calls, expected) {
+			t.Fatalf("upsert to %s, Expected=%v, Actual=%v", name, expected, ent.calls)
+		}
+	}
+}
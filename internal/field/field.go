@@ -0,0 +1,28 @@
+// Package field looks up the struct fields of database columns.
+package field
+
+import (
+	"reflect"
+
+	"github.com/jmoiron/sqlx/reflectx"
+)
+
+// ByColumn returns the field value of the column, without allocating nil pointers like reflectx.Mapper.FieldByName,
+// an invalid value is returned if the field is not found or inside a nil embedded pointer.
+func ByColumn(m *reflectx.Mapper, v reflect.Value, name string) reflect.Value {
+	fi := m.TypeMap(v.Type()).GetByPath(name)
+	if fi == nil {
+		return reflect.Value{}
+	}
+
+	for _, i := range fi.Index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
@@ -0,0 +1,177 @@
+package entity
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// OperationLoad loads an entity.
+	OperationLoad OperationKind = iota + 1
+	// OperationInsert inserts an entity.
+	OperationInsert
+	// OperationInsertOrIgnore inserts an entity or does nothing if it already exists.
+	OperationInsertOrIgnore
+	// OperationUpdate updates an entity.
+	OperationUpdate
+	// OperationUpsert inserts or updates an entity.
+	OperationUpsert
+	// OperationDelete deletes an entity.
+	OperationDelete
+)
+
+var (
+	middlewares   []Middleware
+	middlewaresMu sync.RWMutex
+)
+
+// OperationKind represents the kind of entity operation.
+type OperationKind int
+
+func (k OperationKind) String() string {
+	switch k {
+	case OperationLoad:
+		return "load"
+	case OperationInsert:
+		return "insert"
+	case OperationInsertOrIgnore:
+		return "insert_or_ignore"
+	case OperationUpdate:
+		return "update"
+	case OperationUpsert:
+		return "upsert"
+	case OperationDelete:
+		return "delete"
+	}
+	return fmt.Sprintf("operation(%d)", int(k))
+}
+
+// Operation contains the information of an entity operation passed through the middleware chain.
+type Operation struct {
+	Kind     OperationKind
+	Metadata *Metadata
+	Entity   Entity
+	// DB is the database used by the operation, middleware can replace it before calling the next handler,
+	// the prepared statements are prepared again on the replaced database.
+	// BeginTx replaces it with a transaction for the rest of the operation.
+	DB DB
+
+	// LastInsertID is set after an insert operation, if supported by the database.
+	LastInsertID int64
+	// Ignored is set after an insert or ignore operation that does nothing because the entity already exists.
+	Ignored bool
+
+	// tx is the transaction begun by BeginTx, origin is the database replaced by it
+	tx     Tx
+	origin DB
+}
+
+// BeginTx replaces DB with a transaction if it is not one already, so the rest of the operation runs in it,
+// including the middleware outside the caller after their next handler returns.
+//
+// The transaction is committed after the whole middleware chain succeeds, or rolled back if it fails,
+// and DB is restored then.
+func (op *Operation) BeginTx(ctx context.Context) error {
+	if _, ok := op.DB.(Tx); ok {
+		return nil
+	}
+
+	var (
+		tx  Tx
+		err error
+	)
+	switch db := op.DB.(type) {
+	case TxInitiator[*sqlx.Tx]:
+		var v *sqlx.Tx
+		if v, err = db.BeginTxx(ctx, nil); err == nil {
+			tx = v
+		}
+	case TxInitiator[Tx]:
+		tx, err = db.BeginTxx(ctx, nil)
+	default:
+		return fmt.Errorf("db %T can not begin transaction", op.DB)
+	}
+	if err != nil {
+		return fmt.Errorf("begin transaction, %w", err)
+	}
+
+	op.tx, op.origin, op.DB = tx, op.DB, tx
+	return nil
+}
+
+// endTx commits or rolls back the transaction begun by BeginTx, err is the error of the middleware chain.
+func (op *Operation) endTx(err error) error {
+	if op.tx == nil {
+		return err
+	}
+
+	tx := op.tx
+	op.tx, op.DB = nil, op.origin
+
+	if err == nil {
+		if errCommit := tx.Commit(); errCommit != nil {
+			err = fmt.Errorf("commit transaction, %w", errCommit)
+		}
+	} else if errRollback := tx.Rollback(); errRollback != nil {
+		err = fmt.Errorf("rollback transaction, %v, caused by %w", errRollback, err)
+	}
+	return err
+}
+
+// Handler handles an entity operation.
+type Handler func(ctx context.Context, op *Operation) error
+
+// Middleware wraps a Handler to run additional logic around entity operations,
+// such as auditing, authorization, tracing and validation.
+type Middleware func(next Handler) Handler
+
+// Use registers process-wide middleware around Load, Insert, InsertOrIgnore, Update, Upsert, Delete
+// and the prepared statements.
+//
+// Middleware are called in the order they are registered, the first one is the outermost.
+// It should be called during initialization, before any entity operation.
+func Use(mw ...Middleware) {
+	middlewaresMu.Lock()
+	defer middlewaresMu.Unlock()
+
+	middlewares = append(middlewares, mw...)
+}
+
+func runOperation(ctx context.Context, kind OperationKind, ent Entity, db DB, handler Handler) (*Operation, error) {
+	md, err := getTableMetadata(ctx, ent)
+	if err != nil {
+		return nil, fmt.Errorf("get metadata, %w", err)
+	}
+	if err := assignTenant(ctx, md, ent); err != nil {
+		return nil, err
+	}
+
+	middlewaresMu.RLock()
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	middlewaresMu.RUnlock()
+
+	op := &Operation{
+		Kind:     kind,
+		Metadata: md,
+		Entity:   ent,
+		DB:       db,
+	}
+
+	ctx = withEntityShardKey(ctx, ent)
+	ctx, span := startSpan(ctx, kind.String(), md.TableName)
+	defer func() {
+		if v := recover(); v != nil {
+			_ = op.endTx(fmt.Errorf("%v", v))
+			panic(v)
+		}
+	}()
+	err = op.endTx(handler(ctx, op))
+	endSpan(ctx, span, err)
+
+	return op, err
+}
@@ -0,0 +1,50 @@
+package entity
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	defer func(origin []Middleware) {
+		middlewares = origin
+	}(middlewares)
+	middlewares = nil
+
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, op *Operation) error {
+				calls = append(calls, name+" before "+op.Kind.String())
+				err := next(ctx, op)
+				calls = append(calls, name+" after "+op.Kind.String())
+				return err
+			}
+		}
+	}
+	Use(trace("outer"), trace("inner"))
+
+	ent := &GenernalEntity{}
+	op, err := runOperation(context.Background(), OperationInsert, ent, nil, func(_ context.Context, op *Operation) error {
+		calls = append(calls, "handler")
+		op.LastInsertID = 1
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"outer before insert",
+		"inner before insert",
+		"handler",
+		"inner after insert",
+		"outer after insert",
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("middleware calls, Expected=%v, Actual=%v", expected, calls)
+	} else if op.Entity != ent || op.Metadata.TableName != "genernal" || op.LastInsertID != 1 {
+		t.Fatalf("unexpected operation %+v", op)
+	}
+}
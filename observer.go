@@ -0,0 +1,171 @@
+package entity
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// Names of the spans other than entity operations, entity operations use OperationKind.String() as name.
+const (
+	// SpanCache is the span of loading an entity from cache.
+	SpanCache = "cache"
+	// SpanTransaction is the span of a transaction started by Transaction functions.
+	SpanTransaction = "transaction"
+)
+
+var (
+	observers   []Observer
+	observersMu sync.RWMutex
+)
+
+// CacheResult is the result of loading from cache.
+type CacheResult int
+
+const (
+	// CacheUnused means the cache is not used.
+	CacheUnused CacheResult = iota
+	// CacheHit means the entity is loaded from cache.
+	CacheHit
+	// CacheMiss means the entity is not found in cache.
+	CacheMiss
+)
+
+func (r CacheResult) String() string {
+	switch r {
+	case CacheHit:
+		return "hit"
+	case CacheMiss:
+		return "miss"
+	}
+	return "unused"
+}
+
+// Span describes an observed operation, it's shared by all the observers of the operation.
+type Span struct {
+	// Name is the kind of entity operation such as "load" and "insert", or SpanCache, SpanTransaction.
+	Name string
+	// Table is the table of entity, empty for transaction.
+	Table string
+	// Statement is the last SQL statement executed in the span, empty if nothing executed.
+	Statement string
+	// RowsAffected is the number of rows affected or returned by Statement, -1 if unknown.
+	RowsAffected int64
+	// Cache is the result of loading from cache, set on load and cache spans.
+	Cache CacheResult
+	// Err is the error of operation, set before SpanEnd.
+	Err error
+
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// Duration returns the duration of span, it's zero before the span ends.
+func (s *Span) Duration() time.Duration {
+	if s.EndTime.IsZero() {
+		return 0
+	}
+	return s.EndTime.Sub(s.StartTime)
+}
+
+// Observer observes entity operations, cache loading and transactions, for tracing and metrics.
+type Observer interface {
+	// SpanStart is called before the operation, the returned context is used by the operation
+	// and passed to SpanEnd, so spans can be nested.
+	SpanStart(ctx context.Context, span *Span) context.Context
+	// SpanEnd is called after the operation with the result filled into span.
+	SpanEnd(ctx context.Context, span *Span)
+}
+
+// RegisterObserver registers process-wide observers.
+//
+// It should be called during initialization, before any entity operation.
+func RegisterObserver(obs ...Observer) {
+	observersMu.Lock()
+	defer observersMu.Unlock()
+
+	observers = append(observers, obs...)
+}
+
+type spanKey struct{}
+
+// startSpan starts a span if there is any observer, the returned span is nil otherwise.
+func startSpan(ctx context.Context, name, table string) (context.Context, *Span) {
+	observersMu.RLock()
+	obs := observers
+	observersMu.RUnlock()
+
+	if len(obs) == 0 {
+		return ctx, nil
+	}
+
+	span := &Span{
+		Name:         name,
+		Table:        table,
+		RowsAffected: -1,
+		StartTime:    time.Now(),
+	}
+
+	ctx = context.WithValue(ctx, spanKey{}, span)
+	for _, o := range obs {
+		ctx = o.SpanStart(ctx, span)
+	}
+	return ctx, span
+}
+
+// endSpan ends the span in the reverse order of start.
+func endSpan(ctx context.Context, span *Span, err error) {
+	if span == nil {
+		return
+	}
+
+	observersMu.RLock()
+	obs := observers
+	observersMu.RUnlock()
+
+	span.Err = err
+	span.EndTime = time.Now()
+	for i := len(obs) - 1; i >= 0; i-- {
+		obs[i].SpanEnd(ctx, span)
+	}
+}
+
+func spanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// observeStatement records the statement to be executed into the current span.
+func observeStatement(ctx context.Context, stmt string) {
+	if span := spanFromContext(ctx); span != nil {
+		span.Statement = stmt
+		span.RowsAffected = -1
+	}
+}
+
+// observeRows records the number of rows affected or returned into the current span and statement log.
+func observeRows(ctx context.Context, n int64) {
+	if span := spanFromContext(ctx); span != nil {
+		span.RowsAffected = n
+	}
+	logRows(ctx, n)
+}
+
+// observeResult records the number of rows affected by the result into the current span and statement log.
+func observeResult(ctx context.Context, result sql.Result) {
+	if spanFromContext(ctx) == nil && ctx.Value(statementLogKey{}) == nil {
+		return
+	}
+
+	if n, err := result.RowsAffected(); err == nil {
+		observeRows(ctx, n)
+	}
+}
+
+// observeCache records the result of loading from cache into the current span.
+func observeCache(ctx context.Context, result CacheResult) {
+	if span := spanFromContext(ctx); span != nil {
+		span.Cache = result
+	}
+}
@@ -0,0 +1,73 @@
+package entity
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type traceObserver struct {
+	name  string
+	calls *[]string
+	spans *[]Span
+}
+
+func (to traceObserver) SpanStart(ctx context.Context, span *Span) context.Context {
+	*to.calls = append(*to.calls, to.name+" start "+span.Name)
+	return ctx
+}
+
+func (to traceObserver) SpanEnd(_ context.Context, span *Span) {
+	*to.calls = append(*to.calls, to.name+" end "+span.Name)
+	if to.spans != nil {
+		*to.spans = append(*to.spans, *span)
+	}
+}
+
+func TestObserver(t *testing.T) {
+	defer func(origin []Observer) {
+		observers = origin
+	}(observers)
+	observers = nil
+
+	if ctx, span := startSpan(context.Background(), SpanTransaction, ""); span != nil || spanFromContext(ctx) != nil {
+		t.Fatal("span should not be started without observer")
+	}
+
+	var (
+		calls []string
+		spans []Span
+	)
+	RegisterObserver(
+		traceObserver{name: "outer", calls: &calls},
+		traceObserver{name: "inner", calls: &calls, spans: &spans},
+	)
+
+	errFailed := errors.New("failed")
+	_, err := runOperation(context.Background(), OperationUpdate, &GenernalEntity{}, nil, func(ctx context.Context, op *Operation) error {
+		observeStatement(ctx, "UPDATE genernal")
+		observeRows(ctx, 1)
+		return errFailed
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("operation error, Expected=%v, Actual=%v", errFailed, err)
+	}
+
+	expected := []string{
+		"outer start update",
+		"inner start update",
+		"inner end update",
+		"outer end update",
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("observer calls, Expected=%v, Actual=%v", expected, calls)
+	}
+
+	span := spans[0]
+	if span.Table != "genernal" || span.Statement != "UPDATE genernal" || span.RowsAffected != 1 {
+		t.Fatalf("unexpected span %+v", span)
+	} else if !errors.Is(span.Err, errFailed) || span.EndTime.IsZero() {
+		t.Fatalf("span result, Err=%v, EndTime=%v", span.Err, span.EndTime)
+	}
+}
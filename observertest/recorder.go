@@ -0,0 +1,72 @@
+// Package observertest provides an in-memory observer for testing the instrumentation of entity operations.
+package observertest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/joyparty/entity"
+)
+
+// Recorder records the ended spans in memory.
+//
+// Example:
+//
+//	recorder := observertest.NewRecorder()
+//	entity.RegisterObserver(recorder)
+//	...
+//	for _, span := range recorder.Spans() {
+//		t.Log(span.Name, span.Table, span.Statement)
+//	}
+type Recorder struct {
+	mu    sync.Mutex
+	spans []entity.Span
+}
+
+// NewRecorder creates a new Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// SpanStart implements entity.Observer.
+func (r *Recorder) SpanStart(ctx context.Context, _ *entity.Span) context.Context {
+	return ctx
+}
+
+// SpanEnd implements entity.Observer, a copy of span is recorded.
+func (r *Recorder) SpanEnd(_ context.Context, span *entity.Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.spans = append(r.spans, *span)
+}
+
+// Spans returns the recorded spans in the order of ending, nested spans come before the enclosing ones.
+func (r *Recorder) Spans() []entity.Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]entity.Span{}, r.spans...)
+}
+
+// Filter returns the recorded spans with the name.
+func (r *Recorder) Filter(name string) []entity.Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []entity.Span
+	for _, span := range r.spans {
+		if span.Name == name {
+			result = append(result, span)
+		}
+	}
+	return result
+}
+
+// Reset removes all the recorded spans.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.spans = nil
+}
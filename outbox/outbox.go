@@ -0,0 +1,130 @@
+// Package outbox implements the transactional outbox pattern for entity events.
+//
+// Events of the entities implementing EventSource are written to the outbox table by the middleware,
+// in the same transaction as the entity when the operation runs inside entity.TransactionX,
+// or a middleware registered after it begins one by entity.Operation.BeginTx, such as the audit middleware,
+// then Relay reads the pending messages and hands them to a Publisher.
+//
+// The outbox table is entity-agnostic, for example in PostgreSQL:
+//
+//	CREATE TABLE outbox_messages (
+//		id bigserial PRIMARY KEY,
+//		topic varchar(255) NOT NULL,
+//		message_key varchar(255) NOT NULL DEFAULT '',
+//		payload bytea NOT NULL,
+//		created_at timestamptz NOT NULL,
+//		sent_at timestamptz
+//	);
+//	CREATE INDEX ON outbox_messages (id) WHERE sent_at IS NULL;
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/joyparty/entity"
+)
+
+// DefaultTable is the default name of the outbox table.
+const DefaultTable = "outbox_messages"
+
+// Event is a domain event recorded to the outbox.
+type Event struct {
+	Topic string
+	// Key is used by publishers to keep the order of events, such as the partition key of Kafka.
+	Key     string
+	Payload []byte
+}
+
+// EventSource is implemented by entities that record events to the outbox after they are written.
+//
+// OutboxEvents is called after a successful Insert, InsertOrIgnore (when inserted), Update, Upsert or Delete,
+// op is the kind of the operation.
+type EventSource interface {
+	OutboxEvents(ctx context.Context, op entity.OperationKind) ([]Event, error)
+}
+
+// Message is a row of the outbox table.
+type Message struct {
+	ID        int64        `db:"id"`
+	Topic     string       `db:"topic"`
+	Key       string       `db:"message_key"`
+	Payload   []byte       `db:"payload"`
+	CreatedAt time.Time    `db:"created_at"`
+	SentAt    sql.NullTime `db:"sent_at"`
+}
+
+// Option configures the outbox middleware.
+type Option func(*options)
+
+type options struct {
+	table string
+}
+
+// WithTable sets the name of the outbox table, DefaultTable is used by default.
+func WithTable(table string) Option {
+	return func(o *options) {
+		o.table = table
+	}
+}
+
+// Middleware returns an entity.Middleware writing the events of EventSource entities to the outbox table.
+//
+// Events are written with the database of the operation, they are atomic with the entity only when the
+// operation runs inside a transaction, or the transaction begun by an inner middleware.
+//
+// Example:
+//
+//	entity.Use(outbox.Middleware())
+func Middleware(opts ...Option) entity.Middleware {
+	o := &options{table: DefaultTable}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return func(next entity.Handler) entity.Handler {
+		return func(ctx context.Context, op *entity.Operation) error {
+			if err := next(ctx, op); err != nil {
+				return err
+			}
+
+			src, ok := op.Entity.(EventSource)
+			if !ok || op.Kind == entity.OperationLoad || op.Ignored {
+				return nil
+			}
+
+			events, err := src.OutboxEvents(ctx, op.Kind)
+			if err != nil {
+				return fmt.Errorf("outbox events, %w", err)
+			}
+			return Write(ctx, op.DB, o.table, events...)
+		}
+	}
+}
+
+// Write inserts events into the outbox table, it can be used to record events not bound to entity operations.
+func Write(ctx context.Context, db entity.DB, table string, events ...Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	rows := make([]any, 0, len(events))
+	for _, ev := range events {
+		rows = append(rows, goqu.Record{
+			"topic":       ev.Topic,
+			"message_key": ev.Key,
+			"payload":     ev.Payload,
+			"created_at":  now,
+		})
+	}
+
+	stmt := entity.Dialect(db).Insert(table).Rows(rows...)
+	if _, err := entity.ExecInsert(ctx, db, stmt); err != nil {
+		return fmt.Errorf("write outbox, %w", err)
+	}
+	return nil
+}
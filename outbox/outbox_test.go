@@ -0,0 +1,79 @@
+package outbox
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/joyparty/entity"
+)
+
+type order struct {
+	ID int64 `db:"id,primaryKey"`
+}
+
+func (o *order) TableName() string {
+	return "orders"
+}
+
+func (o *order) OutboxEvents(_ context.Context, op entity.OperationKind) ([]Event, error) {
+	return []Event{{Topic: "order." + op.String()}}, nil
+}
+
+func TestClaimStatement(t *testing.T) {
+	cases := []struct {
+		driver   string
+		opts     []RelayOption
+		expected string
+	}{
+		{"postgres", nil, `SELECT * FROM "outbox_messages" WHERE ("sent_at" IS NULL) ORDER BY "id" ASC LIMIT $1 FOR UPDATE SKIP LOCKED`},
+		{"pgx", nil, `SELECT * FROM "outbox_messages" WHERE ("sent_at" IS NULL) ORDER BY "id" ASC LIMIT $1 FOR UPDATE SKIP LOCKED`},
+		{"postgres", []RelayOption{WithSkipLocked(false)}, `SELECT * FROM "outbox_messages" WHERE ("sent_at" IS NULL) ORDER BY "id" ASC LIMIT $1`},
+		// the version of MySQL is unknown
+		{"mysql", nil, "SELECT * FROM `outbox_messages` WHERE (`sent_at` IS NULL) ORDER BY `id` ASC LIMIT ?"},
+		{"mysql", []RelayOption{WithSkipLocked(true)}, "SELECT * FROM `outbox_messages` WHERE (`sent_at` IS NULL) ORDER BY `id` ASC LIMIT ? FOR UPDATE SKIP LOCKED"},
+		{"sqlite3", nil, "SELECT * FROM `outbox_messages` WHERE (`sent_at` IS ?) ORDER BY `id` ASC LIMIT ?"},
+		{"sqlite", nil, "SELECT * FROM `outbox_messages` WHERE (`sent_at` IS ?) ORDER BY `id` ASC LIMIT ?"},
+		{"unknown", nil, `SELECT * FROM "outbox_messages" WHERE ("sent_at" IS NULL) ORDER BY "id" ASC LIMIT ?`},
+	}
+
+	for _, c := range cases {
+		db := sqlx.NewDb(nil, c.driver)
+		relay := NewRelay[*sqlx.Tx](db, PublisherFunc(func(context.Context, []Message) error { return nil }), c.opts...)
+
+		query, _, err := relay.claimStatement(db).Prepared(true).ToSQL()
+		if err != nil {
+			t.Fatalf("%s claim statement, %v", c.driver, err)
+		} else if query != c.expected {
+			t.Fatalf("%s claim statement, Expected=%q, Actual=%q", c.driver, c.expected, query)
+		}
+	}
+}
+
+func TestWithBatchSize(t *testing.T) {
+	publisher := PublisherFunc(func(context.Context, []Message) error { return nil })
+
+	cases := map[uint]uint{0: 100, 10: 10}
+	for size, expected := range cases {
+		relay := NewRelay[*sqlx.Tx](nil, publisher, WithBatchSize(size))
+		if relay.batchSize != expected {
+			t.Fatalf("WithBatchSize(%d), Expected=%d, Actual=%d", size, expected, relay.batchSize)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	handler := Middleware()(func(context.Context, *entity.Operation) error {
+		return nil
+	})
+
+	// operations without writing events should not touch the database
+	for _, op := range []*entity.Operation{
+		{Kind: entity.OperationLoad, Entity: &order{}},
+		{Kind: entity.OperationInsertOrIgnore, Entity: &order{}, Ignored: true},
+	} {
+		if err := handler(context.Background(), op); err != nil {
+			t.Fatalf("%s, %v", op.Kind, err)
+		}
+	}
+}
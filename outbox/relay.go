@@ -0,0 +1,201 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/joyparty/entity"
+)
+
+// Publisher publishes outbox messages to the message broker.
+//
+// Messages are marked as sent only when Publish returns nil,
+// so they may be published more than once, consumers should be idempotent.
+type Publisher interface {
+	Publish(ctx context.Context, messages []Message) error
+}
+
+// PublisherFunc is an adapter to allow the use of ordinary functions as Publisher.
+type PublisherFunc func(ctx context.Context, messages []Message) error
+
+// Publish calls f(ctx, messages).
+func (f PublisherFunc) Publish(ctx context.Context, messages []Message) error {
+	return f(ctx, messages)
+}
+
+// Relay polls the pending messages of the outbox table and hands them to a Publisher.
+//
+// On PostgreSQL, messages are locked by "FOR UPDATE SKIP LOCKED",
+// so multiple relays can run concurrently without publishing the same message.
+// The driver name doesn't tell the version of MySQL, enable it by WithSkipLocked on MySQL 8,
+// otherwise only one relay should run on the outbox table.
+//
+// The locks are held while the batch is published, so a message is never published by two relays at the same time,
+// at the cost of keeping the transaction open as long as the publisher takes,
+// the publisher should fail fast on the deadline of its context.
+type Relay struct {
+	db          entity.DB
+	publisher   Publisher
+	table       string
+	batchSize   uint
+	interval    time.Duration
+	skipLocked  *bool
+	errHandler  func(error)
+	transaction func(ctx context.Context, fn func(db entity.DB) error) error
+}
+
+// RelayOption configures Relay.
+type RelayOption func(*Relay)
+
+// WithRelayTable sets the name of the outbox table, DefaultTable is used by default.
+func WithRelayTable(table string) RelayOption {
+	return func(r *Relay) {
+		r.table = table
+	}
+}
+
+// WithBatchSize sets the maximum number of messages published in a batch, default 100, zero keeps the default.
+func WithBatchSize(size uint) RelayOption {
+	return func(r *Relay) {
+		if size > 0 {
+			r.batchSize = size
+		}
+	}
+}
+
+// WithPollInterval sets the waiting time after the outbox is drained or an error occurs, default 1 second.
+func WithPollInterval(interval time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.interval = interval
+	}
+}
+
+// WithSkipLocked sets whether the pending messages are claimed by "FOR UPDATE SKIP LOCKED",
+// it's enabled on PostgreSQL only by default. MySQL before 8 and sqlite don't support it.
+func WithSkipLocked(enabled bool) RelayOption {
+	return func(r *Relay) {
+		r.skipLocked = &enabled
+	}
+}
+
+// WithErrorHandler sets the function called on errors of Run, errors are ignored by default.
+func WithErrorHandler(fn func(error)) RelayOption {
+	return func(r *Relay) {
+		r.errHandler = fn
+	}
+}
+
+// NewRelay creates a relay, each batch is claimed, published and marked in a transaction of type T.
+//
+// Example:
+//
+//	relay := outbox.NewRelay[*sqlx.Tx](db, publisher)
+//	go relay.Run(ctx)
+func NewRelay[T entity.Tx](db entity.DB, publisher Publisher, opts ...RelayOption) *Relay {
+	r := &Relay{
+		db:        db,
+		publisher: publisher,
+		table:     DefaultTable,
+		batchSize: 100,
+		interval:  time.Second,
+		transaction: func(ctx context.Context, fn func(db entity.DB) error) error {
+			return entity.TryTransactionX[T](ctx, db, fn)
+		},
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Run relays messages until the context is canceled.
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err != nil && r.errHandler != nil && ctx.Err() == nil {
+			r.errHandler(err)
+		}
+
+		// continue immediately if there may be more pending messages
+		if err == nil && n == int(r.batchSize) {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+// RelayOnce publishes a batch of pending messages, it returns the number of published messages.
+func (r *Relay) RelayOnce(ctx context.Context) (n int, err error) {
+	err = r.transaction(ctx, func(db entity.DB) error {
+		messages, err := r.claim(ctx, db)
+		if err != nil {
+			return fmt.Errorf("claim messages, %w", err)
+		} else if len(messages) == 0 {
+			return nil
+		}
+
+		if err := r.publisher.Publish(ctx, messages); err != nil {
+			return fmt.Errorf("publish messages, %w", err)
+		}
+
+		ids := make([]int64, 0, len(messages))
+		for _, msg := range messages {
+			ids = append(ids, msg.ID)
+		}
+
+		stmt := entity.Dialect(db).Update(r.table).
+			Set(goqu.Record{"sent_at": time.Now()}).
+			Where(goqu.C("id").In(ids))
+		if _, err := entity.ExecUpdate(ctx, db, stmt); err != nil {
+			return fmt.Errorf("mark messages sent, %w", err)
+		}
+
+		n = len(messages)
+		return nil
+	})
+	return
+}
+
+func (r *Relay) claim(ctx context.Context, db entity.DB) ([]Message, error) {
+	stmt := r.claimStatement(db)
+
+	messages := []Message{}
+	if err := entity.GetRecords(ctx, &messages, db, stmt); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (r *Relay) claimStatement(db entity.DB) *goqu.SelectDataset {
+	dialect := entity.Dialect(db)
+	stmt := dialect.From(r.table).
+		Where(goqu.C("sent_at").IsNull()).
+		Order(goqu.C("id").Asc()).
+		Limit(r.batchSize)
+
+	if r.lockRows(dialect) {
+		stmt = stmt.ForUpdate(exp.SkipLocked)
+	}
+	return stmt
+}
+
+// lockRows reports whether the claimed messages are locked,
+// the dialect is compared rather than the driver name, so the aliases of driver, such as "pgx", are resolved.
+func (r *Relay) lockRows(dialect goqu.DialectWrapper) bool {
+	if r.skipLocked != nil {
+		return *r.skipLocked
+	}
+	return dialect == goqu.Dialect("postgres") || dialect == goqu.Dialect("mysql8")
+}
@@ -0,0 +1,137 @@
+package entity
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	queryTaggers   []QueryTagger
+	excludedTags   = map[string]bool{}
+	queryTaggersMu sync.RWMutex
+)
+
+// QueryTagger returns the tags of the statements executed with ctx, such as the application name and traceparent.
+type QueryTagger func(ctx context.Context) map[string]string
+
+// RegisterQueryTagger registers process-wide query taggers.
+//
+// The tags from taggers and WithQueryTags are appended to the statements as a sqlcommenter comment,
+// like /*app='api',route='%2Fusers'*/, so the statements can be attributed on the database side.
+// The tags of WithQueryTags take precedence over the ones of taggers.
+//
+// The comment is appended when the statement is executed, the cached statements are not changed.
+// It's applied to the CRUD functions, Repository.ForEach and the helper functions ExecInsert, ExecUpdate,
+// ExecDelete, GetRecord, GetRecords and QueryBy, but not to the prepared statements, which are prepared once.
+//
+// Every distinct comment makes a distinct statement text, the tags with per-request values, such as traceparent
+// and user id, defeat the prepared statement caches of drivers and split the statistics of pg_stat_statements
+// which doesn't normalize comments. Keep the tags low-cardinality, or drop such keys by ExcludeQueryTags.
+//
+// It should be called during initialization, before any entity operation.
+func RegisterQueryTagger(taggers ...QueryTagger) {
+	queryTaggersMu.Lock()
+	defer queryTaggersMu.Unlock()
+
+	queryTaggers = append(queryTaggers, taggers...)
+}
+
+// ExcludeQueryTags drops the tags of keys from the comments, whether they come from taggers or WithQueryTags,
+// such as the high-cardinality ones in an environment relying on statement caches.
+//
+// It should be called during initialization, before any entity operation.
+func ExcludeQueryTags(keys ...string) {
+	queryTaggersMu.Lock()
+	defer queryTaggersMu.Unlock()
+
+	excluded := make(map[string]bool, len(excludedTags)+len(keys))
+	for k := range excludedTags {
+		excluded[k] = true
+	}
+	for _, k := range keys {
+		excluded[k] = true
+	}
+	excludedTags = excluded
+}
+
+type queryTagsKey struct{}
+
+// WithQueryTags returns a copy of ctx with tags appended to the statements executed with it,
+// the tags are merged with the ones of parent context.
+func WithQueryTags(ctx context.Context, tags map[string]string) context.Context {
+	merged := map[string]string{}
+	if parent, ok := ctx.Value(queryTagsKey{}).(map[string]string); ok {
+		for k, v := range parent {
+			merged[k] = v
+		}
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, queryTagsKey{}, merged)
+}
+
+// commentStatement appends the comment of query tags to the statement.
+func commentStatement(ctx context.Context, stmt string) string {
+	queryTaggersMu.RLock()
+	taggers, excluded := queryTaggers, excludedTags
+	queryTaggersMu.RUnlock()
+
+	tags, _ := ctx.Value(queryTagsKey{}).(map[string]string)
+	if len(taggers) == 0 && len(tags) == 0 {
+		return stmt
+	}
+
+	// sqlcommenter skips the statements already commented
+	if strings.Contains(stmt, "/*") {
+		return stmt
+	}
+
+	merged := map[string]string{}
+	for _, tagger := range taggers {
+		for k, v := range tagger(ctx) {
+			merged[k] = v
+		}
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	for k := range excluded {
+		delete(merged, k)
+	}
+
+	comment := formatQueryTags(merged)
+	if comment == "" {
+		return stmt
+	}
+	return stmt + " " + comment
+}
+
+// formatQueryTags serializes the tags in sqlcommenter format, the keys are sorted.
+func formatQueryTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if k != "" && v != "" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, queryTagEscape(k)+"='"+queryTagEscape(tags[k])+"'")
+	}
+	return "/*" + strings.Join(pairs, ",") + "*/"
+}
+
+// queryTagEscape url-encodes s, quotes and colons are encoded too,
+// so the comment can't be closed by values and the named parameters are not affected.
+func queryTagEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
@@ -0,0 +1,57 @@
+package entity
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCommentStatement(t *testing.T) {
+	defer func(origin []QueryTagger, excluded map[string]bool) {
+		queryTaggers, excludedTags = origin, excluded
+	}(queryTaggers, excludedTags)
+	queryTaggers, excludedTags = nil, map[string]bool{}
+
+	stmt := `SELECT "id" FROM "users" WHERE "id" = :id`
+	if v := commentStatement(context.Background(), stmt); v != stmt {
+		t.Fatalf("statement without tags, Expected=%s, Actual=%s", stmt, v)
+	}
+
+	RegisterQueryTagger(func(ctx context.Context) map[string]string {
+		return map[string]string{"app": "api", "route": "default"}
+	})
+
+	ctx := WithQueryTags(context.Background(), map[string]string{"route": "/users/:id"})
+	ctx = WithQueryTags(ctx, map[string]string{"user": "o'neil x"})
+
+	expected := stmt + ` /*app='api',route='%2Fusers%2F%3Aid',user='o%27neil%20x'*/`
+	if v := commentStatement(ctx, stmt); v != expected {
+		t.Fatalf("commented statement, Expected=%s, Actual=%s", expected, v)
+	}
+
+	commented := "SELECT 1 /* hint */"
+	if v := commentStatement(ctx, commented); v != commented {
+		t.Fatalf("commented statement should be kept, Actual=%s", v)
+	}
+
+	// the quotes and comment terminators of keys and values are encoded
+	ctx = WithQueryTags(context.Background(), map[string]string{"a'b": "x*/y", "note": "*/ DROP TABLE users; /*"})
+	expected = stmt + ` /*a%27b='x%2A%2Fy',app='api',note='%2A%2F%20DROP%20TABLE%20users%3B%20%2F%2A',route='default'*/`
+	if v := commentStatement(ctx, stmt); v != expected {
+		t.Fatalf("escaped statement, Expected=%s, Actual=%s", expected, v)
+	}
+
+	// the keys are sorted whatever order they are added in
+	ctx = WithQueryTags(context.Background(), map[string]string{"z": "1"})
+	ctx = WithQueryTags(ctx, map[string]string{"b": "2"})
+	ctx = WithQueryTags(ctx, map[string]string{"m": "3", "route": "r"})
+	expected = stmt + ` /*app='api',b='2',m='3',route='r',z='1'*/`
+	if v := commentStatement(ctx, stmt); v != expected {
+		t.Fatalf("sorted statement, Expected=%s, Actual=%s", expected, v)
+	}
+
+	ExcludeQueryTags("m", "route")
+	expected = stmt + ` /*app='api',b='2',z='1'*/`
+	if v := commentStatement(ctx, stmt); v != expected {
+		t.Fatalf("statement with excluded tags, Expected=%s, Actual=%s", expected, v)
+	}
+}
@@ -0,0 +1,531 @@
+package entity
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/reflectx"
+)
+
+const (
+	// HasOne means the target table has a foreign key referencing the entity, one row at most.
+	HasOne RelationKind = "hasOne"
+	// HasMany means the target table has a foreign key referencing the entity.
+	HasMany RelationKind = "hasMany"
+	// BelongsTo means the entity has a foreign key referencing the target table.
+	BelongsTo RelationKind = "belongsTo"
+	// ManyToMany means the entity and the target table are associated through a join table.
+	ManyToMany RelationKind = "manyToMany"
+
+	// preloadBatchSize is the number of values in the IN query of Preload,
+	// the values are loaded in batches to stay within the parameter limits of databases.
+	preloadBatchSize = 500
+)
+
+// RelationKind is the kind of relationship between entities.
+type RelationKind string
+
+// Relation describes a relationship declared by the "rel" tag of a struct field.
+//
+// Example:
+//
+//	type User struct {
+//		ID      int64    `db:"id,primaryKey"`
+//		Orders  []*Order `db:"-" rel:"hasMany,fk=user_id"`
+//		Profile *Profile `db:"-" rel:"hasOne,fk=user_id"`
+//		Roles   []Role   `db:"-" rel:"manyToMany,join=user_roles,fk=user_id,joinFk=role_id"`
+//	}
+//
+//	type Order struct {
+//		ID     int64 `db:"id,primaryKey"`
+//		UserID int64 `db:"user_id"`
+//		User   *User `db:"-" rel:"belongsTo,fk=user_id"`
+//	}
+//
+// Options:
+//   - fk: required, the foreign key column, it's on the target table for hasOne and hasMany,
+//     on the entity for belongsTo, and on the join table referencing the entity for manyToMany
+//   - ref: the column referenced by fk, default is the primary key of the entity,
+//     or of the target for belongsTo
+//   - join: required by manyToMany, the join table
+//   - joinFk: required by manyToMany, the column of join table referencing the target
+//   - joinRef: the target column referenced by joinFk, default is the primary key of the target
+type Relation struct {
+	Name           string
+	Kind           RelationKind
+	ForeignKey     string
+	References     string
+	JoinTable      string
+	JoinForeignKey string
+	JoinReferences string
+
+	// struct type of the target entity
+	target reflect.Type
+	index  []int
+}
+
+// parseRelations parses the relations declared by the fields of an entity struct, including the embedded structs.
+func parseRelations(t reflect.Type, primaryKeys []Column) ([]Relation, error) {
+	var result []Relation
+
+	for _, field := range relationFields(t) {
+		tag := field.Tag.Get("rel")
+		if !field.IsExported() {
+			return nil, fmt.Errorf("relation %q, unexported field", field.Name)
+		}
+
+		rel := Relation{Name: field.Name, index: field.Index}
+		for i, s := range strings.Split(tag, ",") {
+			s = strings.TrimSpace(s)
+			if i == 0 {
+				rel.Kind = RelationKind(s)
+				continue
+			}
+
+			key, value, _ := strings.Cut(s, "=")
+			switch key {
+			case "fk":
+				rel.ForeignKey = value
+			case "ref":
+				rel.References = value
+			case "join":
+				rel.JoinTable = value
+			case "joinFk":
+				rel.JoinForeignKey = value
+			case "joinRef":
+				rel.JoinReferences = value
+			default:
+				return nil, fmt.Errorf("relation %q, unknown option %q", field.Name, s)
+			}
+		}
+
+		ft := field.Type
+		switch rel.Kind {
+		case HasMany, ManyToMany:
+			if ft.Kind() != reflect.Slice {
+				return nil, fmt.Errorf("relation %q, %s field must be a slice", field.Name, rel.Kind)
+			}
+			ft = ft.Elem()
+		case HasOne, BelongsTo:
+		default:
+			return nil, fmt.Errorf("relation %q, unknown kind %q", field.Name, rel.Kind)
+		}
+
+		if rel.target = reflectx.Deref(ft); rel.target.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("relation %q, target must be a struct", field.Name)
+		} else if _, ok := reflect.New(rel.target).Interface().(Entity); !ok {
+			return nil, fmt.Errorf("relation %q, %s is not an entity", field.Name, rel.target)
+		}
+
+		if rel.ForeignKey == "" {
+			return nil, fmt.Errorf("relation %q, fk is required", field.Name)
+		} else if rel.Kind == ManyToMany && (rel.JoinTable == "" || rel.JoinForeignKey == "") {
+			return nil, fmt.Errorf("relation %q, join and joinFk are required by manyToMany", field.Name)
+		}
+
+		// the references on the entity side default to its primary key,
+		// the ones on the target side are resolved when preloading, to avoid recursion of cyclic relations
+		if rel.References == "" && rel.Kind != BelongsTo {
+			if len(primaryKeys) != 1 {
+				return nil, fmt.Errorf("relation %q, ref is required by composite primary keys", field.Name)
+			}
+			rel.References = primaryKeys[0].DBField
+		}
+
+		result = append(result, rel)
+	}
+
+	return result, nil
+}
+
+// relationFields returns the fields declared with the "rel" tag, the fields of embedded structs are included like getFields,
+// and outer fields have higher priority than inner fields.
+func relationFields(t reflect.Type) []reflect.StructField {
+	var result []reflect.StructField
+	done := map[string]bool{}
+
+	var walk func(t reflect.Type, index []int)
+	walk = func(t reflect.Type, index []int) {
+		var embedded []reflect.StructField
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			field.Index = append(append([]int{}, index...), i)
+
+			if _, ok := field.Tag.Lookup("rel"); ok {
+				if !done[field.Name] {
+					result = append(result, field)
+				}
+			} else if field.Anonymous && reflectx.Deref(field.Type).Kind() == reflect.Struct {
+				embedded = append(embedded, field)
+				continue
+			}
+			done[field.Name] = true
+		}
+
+		for _, field := range embedded {
+			walk(reflectx.Deref(field.Type), field.Index)
+		}
+	}
+	walk(t, nil)
+
+	return result
+}
+
+// Preload loads the relations of rows by batched IN queries, and assigns them to the relation fields.
+//
+// Nested relations are separated by dot, such as "Orders.Items", the parent relations are loaded first.
+//
+// Example:
+//
+//	err := entity.Preload(ctx, db, users, "Profile", "Orders", "Orders.Items")
+func Preload[E Entity](ctx context.Context, db DB, rows []E, paths ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, ReadTimeout)
+	defer cancel()
+
+	if len(rows) == 0 || len(paths) == 0 {
+		return nil
+	}
+
+	parents := make([]reflect.Value, 0, len(rows))
+	for _, row := range rows {
+		rv := reflect.ValueOf(row)
+		if rv.Kind() != reflect.Ptr || rv.Type().Elem().Kind() != reflect.Struct {
+			return fmt.Errorf("preload %T, rows must be pointers to struct", row)
+		} else if !rv.IsNil() {
+			parents = append(parents, rv)
+		}
+	}
+
+	if len(parents) == 0 {
+		return nil
+	}
+
+	md, err := getMetadata(parents[0].Interface().(Entity))
+	if err != nil {
+		return fmt.Errorf("get metadata, %w", err)
+	}
+	return preload(ctx, db, md, parents, newPreloadTree(paths))
+}
+
+// preloadTree is the nested relation names to be preloaded.
+type preloadTree map[string]preloadTree
+
+func newPreloadTree(paths []string) preloadTree {
+	tree := preloadTree{}
+	for _, path := range paths {
+		node := tree
+		for _, name := range strings.Split(path, ".") {
+			if node[name] == nil {
+				node[name] = preloadTree{}
+			}
+			node = node[name]
+		}
+	}
+	return tree
+}
+
+func preload(ctx context.Context, db DB, md *Metadata, parents []reflect.Value, tree preloadTree) error {
+	names := make([]string, 0, len(tree))
+	for name := range tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		rel, ok := md.relation(name)
+		if !ok {
+			return fmt.Errorf("preload %s, relation %q not found", md.Type, name)
+		}
+
+		target, err := getMetadata(reflect.New(rel.target).Interface().(Entity))
+		if err != nil {
+			return fmt.Errorf("preload %s.%s, get metadata, %w", md.Type, name, err)
+		}
+
+		if err := preloadRelation(ctx, db, target, rel, parents); err != nil {
+			return fmt.Errorf("preload %s.%s, %w", md.Type, name, err)
+		}
+
+		if children := tree[name]; len(children) > 0 {
+			if err := preload(ctx, db, target, relationValues(rel, parents), children); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (md *Metadata) relation(name string) (Relation, bool) {
+	for _, rel := range md.Relations {
+		if rel.Name == name {
+			return rel, true
+		}
+	}
+	return Relation{}, false
+}
+
+func preloadRelation(ctx context.Context, db DB, target *Metadata, rel Relation, parents []reflect.Value) error {
+	// column of parents used to match the targets
+	parentKey := rel.References
+	if rel.Kind == BelongsTo {
+		parentKey = rel.ForeignKey
+	}
+
+	keys, values := collectKeys(parents, parentKey)
+	if len(keys) == 0 {
+		return nil
+	}
+
+	var (
+		targetKey string
+		// target keys of each parent key, only used by manyToMany
+		joined map[string][]string
+	)
+
+	switch rel.Kind {
+	case HasOne, HasMany:
+		targetKey = rel.ForeignKey
+	case BelongsTo:
+		targetKey = rel.References
+	case ManyToMany:
+		targetKey = rel.JoinReferences
+
+		var err error
+		joined, values, err = loadJoinTable(ctx, db, rel, values)
+		if err != nil {
+			return fmt.Errorf("load join table, %w", err)
+		} else if len(values) == 0 {
+			assignRelation(rel, parents, keys, nil)
+			return nil
+		}
+	}
+
+	if targetKey == "" {
+		if len(target.PrimaryKeys) != 1 {
+			return fmt.Errorf("ref is required by composite primary keys of %s", target.Type)
+		}
+		targetKey = target.PrimaryKeys[0].DBField
+	}
+
+	targets, err := loadTargets(ctx, db, target, targetKey, values)
+	if err != nil {
+		return err
+	}
+
+	matched := map[string][]reflect.Value{}
+	for _, v := range targets {
+		key := relationKey(fieldByColumn(v, targetKey))
+		matched[key] = append(matched[key], v)
+	}
+
+	if joined != nil {
+		byParent := make(map[string][]reflect.Value, len(joined))
+		for parentKey, targetKeys := range joined {
+			for _, key := range targetKeys {
+				byParent[parentKey] = append(byParent[parentKey], matched[key]...)
+			}
+		}
+		matched = byParent
+	}
+
+	assignRelation(rel, parents, keys, matched)
+	return nil
+}
+
+// collectKeys returns the relation key of each parent, and the distinct non-NULL values of them.
+func collectKeys(parents []reflect.Value, column string) ([]string, []any) {
+	keys := make([]string, len(parents))
+	values := []any{}
+	seen := map[string]bool{}
+
+	for i, parent := range parents {
+		v, valid := indirectValue(fieldByColumn(parent, column))
+		if !valid {
+			continue
+		}
+
+		key := relationKey(v)
+		keys[i] = key
+		if !seen[key] {
+			seen[key] = true
+			values = append(values, v.Interface())
+		}
+	}
+	return keys, values
+}
+
+func loadJoinTable(ctx context.Context, db DB, rel Relation, values []any) (map[string][]string, []any, error) {
+	table := TableIdentifier(ResolveTable(ctx, rel.JoinTable))
+
+	joined := map[string][]string{}
+	targetValues := []any{}
+	seen := map[string]bool{}
+
+	for _, batch := range batchValues(values) {
+		stmt := Dialect(db).From(table).
+			Select(goqu.C(rel.ForeignKey), goqu.C(rel.JoinForeignKey)).
+			Where(goqu.C(rel.ForeignKey).In(batch...))
+
+		err := QueryBy(ctx, db, stmt, func(_ context.Context, rows *sqlx.Rows) error {
+			var parentValue, targetValue any
+			if err := rows.Scan(&parentValue, &targetValue); err != nil {
+				return err
+			}
+
+			parentKey := relationKey(reflect.ValueOf(parentValue))
+			targetKey := relationKey(reflect.ValueOf(targetValue))
+			joined[parentKey] = append(joined[parentKey], targetKey)
+
+			if !seen[targetKey] {
+				seen[targetKey] = true
+				targetValues = append(targetValues, targetValue)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return joined, targetValues, nil
+}
+
+// loadTargets returns the pointers of the target entities whose column is in values.
+func loadTargets(ctx context.Context, db DB, target *Metadata, column string, values []any) ([]reflect.Value, error) {
+	columns := make([]any, 0, len(target.Columns))
+	for _, col := range target.Columns {
+		columns = append(columns, goqu.C(col.DBField))
+	}
+
+	order := make([]exp.OrderedExpression, 0, len(target.PrimaryKeys))
+	for _, col := range target.PrimaryKeys {
+		order = append(order, goqu.C(col.DBField).Asc())
+	}
+
+	table := TableIdentifier(ResolveTable(ctx, target.TableName))
+	tenant, err := tenantCondition(ctx, target, table.GetTable())
+	if err != nil {
+		return nil, err
+	}
+
+	var result []reflect.Value
+	for _, batch := range batchValues(values) {
+		stmt := Dialect(db).From(table).
+			Select(columns...).
+			Where(goqu.C(column).In(batch...)).
+			Order(order...)
+		if tenant != nil {
+			stmt = stmt.Where(tenant)
+		}
+
+		dest := reflect.New(reflect.SliceOf(reflect.PtrTo(target.Type)))
+		if err := GetRecords(ctx, dest.Interface(), db, stmt); err != nil {
+			return nil, err
+		}
+
+		for i := 0; i < dest.Elem().Len(); i++ {
+			v := dest.Elem().Index(i)
+			if err := afterFind(ctx, v.Interface().(Entity)); err != nil {
+				return nil, err
+			}
+			result = append(result, v)
+		}
+	}
+	return result, nil
+}
+
+// batchValues splits values into batches of preloadBatchSize.
+func batchValues(values []any) [][]any {
+	batches := make([][]any, 0, (len(values)+preloadBatchSize-1)/preloadBatchSize)
+	for len(values) > preloadBatchSize {
+		batches = append(batches, values[:preloadBatchSize])
+		values = values[preloadBatchSize:]
+	}
+	if len(values) > 0 {
+		batches = append(batches, values)
+	}
+	return batches
+}
+
+// assignRelation sets the matched targets to the relation field of parents,
+// the fields are reset even if nothing matched, slices to empty, pointers to nil.
+func assignRelation(rel Relation, parents []reflect.Value, keys []string, matched map[string][]reflect.Value) {
+	for i, parent := range parents {
+		// the field is inside a nil embedded pointer
+		field, err := parent.Elem().FieldByIndexErr(rel.index)
+		if err != nil {
+			continue
+		}
+
+		var targets []reflect.Value
+		if keys[i] != "" {
+			targets = matched[keys[i]]
+		}
+
+		if field.Kind() == reflect.Slice {
+			slice := reflect.MakeSlice(field.Type(), 0, len(targets))
+			for _, v := range targets {
+				slice = reflect.Append(slice, elemValue(v, field.Type().Elem()))
+			}
+			field.Set(slice)
+		} else if len(targets) > 0 {
+			field.Set(elemValue(targets[0], field.Type()))
+		} else {
+			field.Set(reflect.Zero(field.Type()))
+		}
+	}
+}
+
+// relationValues returns the pointers of the loaded targets of parents.
+func relationValues(rel Relation, parents []reflect.Value) []reflect.Value {
+	var result []reflect.Value
+
+	add := func(v reflect.Value) {
+		if v.Kind() != reflect.Ptr {
+			v = v.Addr()
+		}
+		if !v.IsNil() {
+			result = append(result, v)
+		}
+	}
+
+	for _, parent := range parents {
+		field, err := parent.Elem().FieldByIndexErr(rel.index)
+		if err != nil {
+			continue
+		}
+
+		if field.Kind() == reflect.Slice {
+			for i := 0; i < field.Len(); i++ {
+				add(field.Index(i))
+			}
+		} else if field.Kind() != reflect.Ptr || !field.IsNil() {
+			add(field)
+		}
+	}
+	return result
+}
+
+// elemValue converts a target pointer to the type of field or slice element.
+func elemValue(v reflect.Value, t reflect.Type) reflect.Value {
+	if t.Kind() == reflect.Ptr {
+		return v
+	}
+	return v.Elem()
+}
+
+// relationKey converts a column value to string, so the values of different integer types can be matched.
+func relationKey(v reflect.Value) string {
+	v, valid := indirectValue(v)
+	if !valid {
+		return ""
+	} else if b, ok := v.Interface().([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(v.Interface())
+}
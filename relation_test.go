@@ -0,0 +1,229 @@
+package entity
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type relUser struct {
+	ID      int64         `db:"id,primaryKey"`
+	Orders  []*relOrder   `db:"-" rel:"hasMany,fk=user_id"`
+	Profile *relProfile   `rel:"hasOne,fk=user_id"`
+	Groups  []relGroup    `db:"-" rel:"manyToMany,join=user_groups,fk=user_id,joinFk=group_id"`
+	Friends []relUserMeta `db:"-"`
+}
+
+type relOrder struct {
+	ID     int64    `db:"id,primaryKey"`
+	UserID int64    `db:"user_id"`
+	User   *relUser `db:"-" rel:"belongsTo,fk=user_id"`
+}
+
+type relProfile struct {
+	ID     int64 `db:"id,primaryKey"`
+	UserID int64 `db:"user_id"`
+}
+
+type relGroup struct {
+	ID int64 `db:"id,primaryKey"`
+}
+
+type relUserMeta struct{}
+
+func (*relUser) TableName() string    { return "users" }
+func (*relOrder) TableName() string   { return "orders" }
+func (*relProfile) TableName() string { return "profiles" }
+func (*relGroup) TableName() string   { return "groups" }
+
+func TestRelations(t *testing.T) {
+	md, err := NewMetadata(&relUser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(md.Columns) != 1 || md.Columns[0].DBField != "id" {
+		t.Fatalf("relation fields should not be columns, %v", md.Columns)
+	}
+
+	expected := []Relation{
+		{Name: "Orders", Kind: HasMany, ForeignKey: "user_id", References: "id"},
+		{Name: "Profile", Kind: HasOne, ForeignKey: "user_id", References: "id"},
+		{Name: "Groups", Kind: ManyToMany, ForeignKey: "user_id", References: "id", JoinTable: "user_groups", JoinForeignKey: "group_id"},
+	}
+	if len(md.Relations) != len(expected) {
+		t.Fatalf("relations, Expected=%d, Actual=%d", len(expected), len(md.Relations))
+	}
+	for i, rel := range md.Relations {
+		rel.target, rel.index = nil, nil
+		if !reflect.DeepEqual(rel, expected[i]) {
+			t.Fatalf("relation, Expected=%+v, Actual=%+v", expected[i], rel)
+		}
+	}
+
+	// belongsTo references the primary key of target by default, resolved when preloading
+	if md, err := NewMetadata(&relOrder{}); err != nil {
+		t.Fatal(err)
+	} else if rel := md.Relations[0]; rel.Kind != BelongsTo || rel.References != "" {
+		t.Fatalf("belongsTo relation, %+v", rel)
+	}
+
+	for _, v := range []any{
+		struct {
+			Orders relOrder `rel:"hasMany,fk=user_id"`
+		}{},
+		struct {
+			Orders []relOrder `rel:"hasMany"`
+		}{},
+		struct {
+			Groups []relGroup `rel:"manyToMany,fk=user_id"`
+		}{},
+		struct {
+			Meta *relUserMeta `rel:"hasOne,fk=user_id"`
+		}{},
+		struct {
+			Orders []relOrder `rel:"hasSome,fk=user_id"`
+		}{},
+	} {
+		if _, err := parseRelations(reflect.TypeOf(v), md.PrimaryKeys); err == nil {
+			t.Fatalf("parseRelations(%T), Expected=error, Actual=nil", v)
+		}
+	}
+}
+
+type relBase struct {
+	Orders []*relOrder `db:"-" rel:"hasMany,fk=user_id"`
+}
+
+type relMember struct {
+	ID int64 `db:"id,primaryKey"`
+	*relBase
+}
+
+func (*relMember) TableName() string { return "members" }
+
+func TestEmbeddedRelations(t *testing.T) {
+	md, err := NewMetadata(&relMember{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(md.Relations) != 1 || md.Relations[0].Name != "Orders" || !reflect.DeepEqual(md.Relations[0].index, []int{1, 0}) {
+		t.Fatalf("embedded relations, %+v", md.Relations)
+	}
+
+	// nil embedded pointer is skipped
+	parents := []reflect.Value{reflect.ValueOf(&relMember{ID: 1}), reflect.ValueOf(&relMember{ID: 2, relBase: &relBase{}})}
+	assignRelation(md.Relations[0], parents, []string{"1", "2"}, map[string][]reflect.Value{
+		"2": {reflect.ValueOf(&relOrder{ID: 3, UserID: 2})},
+	})
+	if member := parents[1].Interface().(*relMember); len(member.Orders) != 1 || member.Orders[0].ID != 3 {
+		t.Fatalf("assigned orders, %+v", member.Orders)
+	} else if values := relationValues(md.Relations[0], parents); len(values) != 1 {
+		t.Fatalf("relation values, Expected=1, Actual=%d", len(values))
+	}
+}
+
+func TestAssignRelation(t *testing.T) {
+	md, err := NewMetadata(&relUser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the related objects of reused entities are reset if nothing matched
+	user := &relUser{ID: 1, Orders: []*relOrder{{ID: 2}}, Profile: &relProfile{ID: 3}}
+	for _, rel := range md.Relations[:2] {
+		assignRelation(rel, []reflect.Value{reflect.ValueOf(user)}, []string{"1"}, map[string][]reflect.Value{})
+	}
+	if user.Orders == nil || len(user.Orders) != 0 {
+		t.Fatalf("orders, Expected=[], Actual=%v", user.Orders)
+	} else if user.Profile != nil {
+		t.Fatalf("profile, Expected=nil, Actual=%+v", user.Profile)
+	}
+}
+
+// argsConnector is a driver returning the bound arguments of query as the rows of "id" column,
+// like "WHERE id IN (...)" matches all of them.
+type argsConnector struct {
+	queries *[]int
+}
+
+func (c argsConnector) Connect(context.Context) (driver.Conn, error) {
+	return argsConn(c), nil
+}
+
+func (c argsConnector) Driver() driver.Driver {
+	return c
+}
+
+func (c argsConnector) Open(string) (driver.Conn, error) {
+	return argsConn(c), nil
+}
+
+type argsConn argsConnector
+
+func (c argsConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c argsConn) Close() error {
+	return nil
+}
+
+func (c argsConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c argsConn) QueryContext(_ context.Context, _ string, args []driver.NamedValue) (driver.Rows, error) {
+	*c.queries = append(*c.queries, len(args))
+
+	ids := make([]int64, 0, len(args))
+	for _, arg := range args {
+		ids = append(ids, arg.Value.(int64))
+	}
+	return &idsRows{ids: ids}, nil
+}
+
+func TestPreloadBatch(t *testing.T) {
+	var queries []int
+	db := sqlx.NewDb(sql.OpenDB(argsConnector{queries: &queries}), driverPostgres)
+	defer db.Close()
+
+	orders := make([]*relOrder, 0, preloadBatchSize*2+1)
+	for i := 1; i <= cap(orders); i++ {
+		orders = append(orders, &relOrder{ID: int64(i), UserID: int64(i)})
+	}
+
+	if err := Preload(context.Background(), db, orders, "User"); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []int{preloadBatchSize, preloadBatchSize, 1}; !reflect.DeepEqual(queries, expected) {
+		t.Fatalf("arguments of queries, Expected=%v, Actual=%v", expected, queries)
+	}
+	for _, order := range orders {
+		if order.User == nil || order.User.ID != order.UserID {
+			t.Fatalf("user of order %d, %+v", order.ID, order.User)
+		}
+	}
+}
+
+func TestPreloadTree(t *testing.T) {
+	tree := newPreloadTree([]string{"Orders.Items", "Orders", "Orders.User", "Profile"})
+
+	expected := preloadTree{
+		"Orders": preloadTree{
+			"Items": preloadTree{},
+			"User":  preloadTree{},
+		},
+		"Profile": preloadTree{},
+	}
+	if !reflect.DeepEqual(tree, expected) {
+		t.Fatalf("preload tree, Expected=%v, Actual=%v", expected, tree)
+	}
+}
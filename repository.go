@@ -1,13 +1,20 @@
 package entity
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/jmoiron/sqlx/reflectx"
 )
 
 // Row is an entity row interface.
@@ -22,27 +29,145 @@ type Repository[ID comparable, R Row[ID]] struct {
 	db      DB
 	rowType reflect.Type
 	factory func(ID) (R, error)
+
+	defaultScopes []Scope
+	namedScopes   map[string]Scope
+	scopes        []Scope
+	scopeErr      error
+
+	unit *UnitOfWork
+}
+
+// Scope modifies the query statement of repository reads, such as adding common WHERE conditions.
+type Scope func(stmt *goqu.SelectDataset) *goqu.SelectDataset
+
+// RepositoryOption is an option for NewRepository.
+type RepositoryOption func(*repositoryOptions)
+
+type repositoryOptions struct {
+	defaultScopes []Scope
+	namedScopes   map[string]Scope
+}
+
+// WithDefaultScopes registers scopes applied to every read of the repository, unless Unscoped is used.
+func WithDefaultScopes(scopes ...Scope) RepositoryOption {
+	return func(o *repositoryOptions) {
+		o.defaultScopes = append(o.defaultScopes, scopes...)
+	}
+}
+
+// WithNamedScope registers a scope that can be applied on demand by Repository.Scopes.
+func WithNamedScope(name string, scope Scope) RepositoryOption {
+	return func(o *repositoryOptions) {
+		o.namedScopes[name] = scope
+	}
 }
 
 // NewRepository creates a new Repository instance.
-func NewRepository[ID comparable, R Row[ID]](db DB) *Repository[ID, R] {
+func NewRepository[ID comparable, R Row[ID]](db DB, opts ...RepositoryOption) *Repository[ID, R] {
 	var row R
 	rt := reflect.TypeOf(row)
 	if rt.Kind() == reflect.Ptr {
 		rt = rt.Elem()
 	}
 
+	options := &repositoryOptions{
+		namedScopes: map[string]Scope{},
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	var id ID
+	key, keyErr := newCompositeKey(reflect.TypeOf(id), reflect.New(rt).Interface().(R))
+
 	return &Repository[ID, R]{
 		db:      db,
 		rowType: rt,
 		factory: func(id ID) (R, error) {
 			row := reflect.New(rt).Interface().(R)
+			if keyErr != nil {
+				return row, fmt.Errorf("composite key, %w", keyErr)
+			} else if key != nil {
+				key.assign(reflect.ValueOf(id), reflect.ValueOf(row))
+			}
+
 			if err := row.SetID(id); err != nil {
 				return row, fmt.Errorf("set id, %w", err)
 			}
 			return row, nil
 		},
+		defaultScopes: options.defaultScopes,
+		namedScopes:   options.namedScopes,
+	}
+}
+
+// Scopes returns a copy of the repository that applies the named scopes to every read in addition to the default scopes.
+// If any scope is not registered, the reads of the returned repository fail with the error.
+func (r *Repository[ID, R]) Scopes(names ...string) *Repository[ID, R] {
+	clone := *r
+
+	scopes := make([]Scope, 0, len(r.scopes)+len(names))
+	scopes = append(scopes, r.scopes...)
+	for _, name := range names {
+		scope, ok := r.namedScopes[name]
+		if !ok {
+			if clone.scopeErr == nil {
+				clone.scopeErr = fmt.Errorf("undefined scope %q", name)
+			}
+			continue
+		}
+		scopes = append(scopes, scope)
+	}
+
+	clone.scopes = scopes
+	return &clone
+}
+
+// Unscoped returns a copy of the repository without the default scopes.
+func (r *Repository[ID, R]) Unscoped() *Repository[ID, R] {
+	clone := *r
+	clone.defaultScopes = nil
+	return &clone
+}
+
+func (r *Repository[ID, R]) isScoped() bool {
+	return len(r.defaultScopes) > 0 || len(r.scopes) > 0 || r.scopeErr != nil
+}
+
+// applyScopes applies the table and tenant of ctx and the scopes to stmt, the tenant is applied even if Unscoped is used.
+func (r *Repository[ID, R]) applyScopes(ctx context.Context, stmt *goqu.SelectDataset) *goqu.SelectDataset {
+	stmt, _ = r.applyScopesRef(ctx, stmt)
+	return stmt
+}
+
+// applyScopesRef is applyScopes that also returns the name referencing the table of entity in stmt.
+func (r *Repository[ID, R]) applyScopesRef(ctx context.Context, stmt *goqu.SelectDataset) (*goqu.SelectDataset, string) {
+	row := reflect.New(r.rowType).Interface().(R)
+	md, err := getMetadata(row)
+	if err != nil {
+		return stmt.SetError(fmt.Errorf("get metadata, %w", err)), ""
+	}
+
+	ref := TableIdentifier(md.TableName).GetTable()
+	if r.scopeErr != nil {
+		return stmt.SetError(r.scopeErr), ref
+	}
+
+	stmt, ref = resolveFrom(ctx, row, md, stmt)
+	if cond, err := tenantCondition(ctx, md, ref); err != nil {
+		return stmt.SetError(err), ref
+	} else if cond != nil {
+		stmt = stmt.Where(cond)
 	}
+
+	for _, scope := range r.defaultScopes {
+		stmt = scope(stmt)
+	}
+	for _, scope := range r.scopes {
+		stmt = scope(stmt)
+	}
+	return stmt, ref
 }
 
 // GetDB returns the database connection used by the repository.
@@ -50,25 +175,137 @@ func (r *Repository[ID, R]) GetDB() DB {
 	return r.db
 }
 
+// WithDB returns a copy of the repository using the given database, such as a transaction.
+func (r *Repository[ID, R]) WithDB(db DB) *Repository[ID, R] {
+	clone := *r
+	clone.db = db
+	return &clone
+}
+
+// WithUnit returns a copy of the repository reading from the database of the unit of work,
+// Find returns the instance tracked by the unit for the same primary key.
+func (r *Repository[ID, R]) WithUnit(u *UnitOfWork) *Repository[ID, R] {
+	clone := *r
+	clone.db = u.DB()
+	clone.unit = u
+	return &clone
+}
+
+// Dataset returns a select statement from the entity table, with all the entity columns selected
+// and the goqu dialect matching the database driver.
+func (r *Repository[ID, R]) Dataset() *goqu.SelectDataset {
+	md, err := getMetadata(reflect.New(r.rowType).Interface().(R))
+	if err != nil {
+		return Dialect(r.db).From().SetError(fmt.Errorf("get metadata, %w", err))
+	}
+
+	table := TableIdentifier(md.TableName)
+	columns := make([]any, 0, len(md.Columns))
+	for _, col := range md.Columns {
+		columns = append(columns, goqu.T(table.GetTable()).Col(col.DBField))
+	}
+
+	return Dialect(r.db).From(table).Select(columns...)
+}
+
 // NewEntity creates a new entity object with the given ID.
 func (r *Repository[ID, R]) NewEntity(id ID) (R, error) {
 	return r.factory(id)
 }
 
 // Find retrieves an entity by its primary key.
+//
+// If the repository is scoped, the entity is queried with the scopes applied, the cache is not used.
 func (r *Repository[ID, R]) Find(ctx context.Context, id ID) (R, error) {
 	row, err := r.factory(id)
 	if err != nil {
 		return row, fmt.Errorf("new row, %w", err)
 	}
 
-	if err := Load(ctx, row, r.db); err != nil {
+	if r.unit != nil {
+		if tracked, ok := r.unit.lookup(row); ok {
+			return tracked.(R), nil
+		}
+	}
+
+	if r.isScoped() {
+		row, err = r.findScoped(ctx, row)
+	} else if err = Load(ctx, row, r.db); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrNotFound
+		}
+	} else {
+		err = afterFind(ctx, row)
+	}
+
+	if err != nil || r.unit == nil {
+		return row, err
+	}
+
+	tracked, err := r.unit.attach(row)
+	if err != nil {
+		return row, fmt.Errorf("attach to unit of work, %w", err)
+	}
+	return tracked.(R), nil
+}
+
+func (r *Repository[ID, R]) findScoped(ctx context.Context, row R) (R, error) {
+	md, err := getMetadata(row)
+	if err != nil {
+		return row, fmt.Errorf("get metadata, %w", err)
+	}
+
+	stmt := r.applyScopes(ctx,
+		r.Dataset().Where(primaryKeyCondition(md, row)).Limit(1),
+	)
+
+	if err := GetRecord(ctx, row, r.db, stmt); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return row, ErrNotFound
 		}
 		return row, err
 	}
-	return row, nil
+	return row, afterFind(ctx, row)
+}
+
+// FindMany retrieves entities by primary keys, the order of results is not guaranteed.
+func (r *Repository[ID, R]) FindMany(ctx context.Context, ids ...ID) ([]R, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var md *Metadata
+	keys := make([]map[string]any, 0, len(ids))
+	for _, id := range ids {
+		row, err := r.factory(id)
+		if err != nil {
+			return nil, fmt.Errorf("new row, %w", err)
+		}
+
+		if md == nil {
+			if md, err = getMetadata(row); err != nil {
+				return nil, fmt.Errorf("get metadata, %w", err)
+			}
+		}
+		keys = append(keys, primaryKeyValues(md, row))
+	}
+
+	return r.Query(ctx, r.Dataset().Where(primaryKeysIn(md.PrimaryKeys, keys)))
+}
+
+// ExistsID checks whether an entity with the given primary key exists.
+func (r *Repository[ID, R]) ExistsID(ctx context.Context, id ID) (bool, error) {
+	row, err := r.factory(id)
+	if err != nil {
+		return false, fmt.Errorf("new row, %w", err)
+	}
+
+	md, err := getMetadata(row)
+	if err != nil {
+		return false, fmt.Errorf("get metadata, %w", err)
+	}
+
+	return r.Exists(ctx, r.Dataset().Where(primaryKeyCondition(md, row)))
 }
 
 // Create saves a new entity to the database.
@@ -77,6 +314,44 @@ func (r *Repository[ID, R]) Create(ctx context.Context, row R) error {
 	return err
 }
 
+// CreateOrIgnore saves a new entity to the database, does nothing if the entity already exists.
+// It reports whether the entity is created.
+func (r *Repository[ID, R]) CreateOrIgnore(ctx context.Context, row R) (bool, error) {
+	return InsertOrIgnore(ctx, row, r.db)
+}
+
+// FindOrCreate retrieves an entity by its primary key, or creates it after initialized by the init function if not found.
+// If the entity is created concurrently by others, the existing one is returned.
+//
+// The entity is created by CreateOrIgnore, so a conflict does not abort the transaction of the repository database,
+// ErrConflict is returned if the ignored conflict is not on the primary key.
+func (r *Repository[ID, R]) FindOrCreate(ctx context.Context, id ID, init func(row R) error) (R, error) {
+	row, err := r.Find(ctx, id)
+	if err == nil || !errors.Is(err, ErrNotFound) {
+		return row, err
+	}
+
+	row, err = r.factory(id)
+	if err != nil {
+		return row, fmt.Errorf("new row, %w", err)
+	} else if err := init(row); err != nil {
+		return row, fmt.Errorf("init row, %w", err)
+	}
+
+	if created, err := r.CreateOrIgnore(ctx, row); err != nil {
+		return row, err
+	} else if created {
+		return row, nil
+	}
+
+	// created by others, read it from the primary
+	row, err = r.Find(ForcePrimary(ctx), id)
+	if errors.Is(err, ErrNotFound) {
+		return row, ErrConflict
+	}
+	return row, err
+}
+
 // Update updates an existing entity.
 func (r *Repository[ID, R]) Update(ctx context.Context, row R) error {
 	return Update(ctx, row, r.db)
@@ -84,7 +359,8 @@ func (r *Repository[ID, R]) Update(ctx context.Context, row R) error {
 
 // UpdateBy retrieves an entity by ID and executes the apply function to update it. If apply returns false, changes are not saved.
 func (r *Repository[ID, R]) UpdateBy(ctx context.Context, id ID, apply func(row R) (bool, error)) error {
-	row, err := r.Find(ctx, id)
+	// read from the primary of ClusterDB, the entity is written back
+	row, err := r.Find(ForcePrimary(ctx), id)
 	if err != nil {
 		return err
 	} else if ok, err := apply(row); err != nil {
@@ -107,12 +383,12 @@ func (r *Repository[ID, R]) Delete(ctx context.Context, row R) error {
 
 // ForEach iterates over entities matching the query statement. The iteratee function should return false to stop iteration.
 func (r *Repository[ID, R]) ForEach(ctx context.Context, stmt *goqu.SelectDataset, iteratee func(row R) (bool, error)) error {
-	query, args, err := stmt.ToSQL()
+	query, args, err := r.applyScopes(ctx, stmt).ToSQL()
 	if err != nil {
 		return fmt.Errorf("build sql, %w", err)
 	}
 
-	rows, err := r.db.QueryxContext(ctx, query, args...)
+	rows, err := r.db.QueryxContext(ctx, commentStatement(ctx, query), args...)
 	if err != nil {
 		return err
 	}
@@ -123,6 +399,8 @@ func (r *Repository[ID, R]) ForEach(ctx context.Context, stmt *goqu.SelectDatase
 
 		if err := rows.StructScan(row); err != nil {
 			return fmt.Errorf("scan row, %w", err)
+		} else if err := afterFind(ctx, row); err != nil {
+			return err
 		} else if ok, err := iteratee(row); err != nil {
 			return err
 		} else if !ok {
@@ -133,9 +411,84 @@ func (r *Repository[ID, R]) ForEach(ctx context.Context, stmt *goqu.SelectDatase
 	return rows.Err()
 }
 
+// ForEachConcurrent iterates over entities matching the query statement with a pool of workers.
+// Rows are read by the calling goroutine and dispatched to the workers, so the iteratee must be safe for concurrent use.
+// The iteration stops when any iteratee returns false or an error, and the first error is returned.
+// If ctx is done, the iteration stops and ctx.Err() is returned, the rows already dispatched are skipped.
+func (r *Repository[ID, R]) ForEachConcurrent(ctx context.Context, stmt *goqu.SelectDataset, workers int, iteratee func(row R) (bool, error)) error {
+	return forEachConcurrent(ctx, workers, func(ctx context.Context, yield func(row R) (bool, error)) error {
+		return r.ForEach(ctx, stmt, yield)
+	}, iteratee)
+}
+
+// forEachConcurrent dispatches the rows produced by the calling goroutine to a pool of workers calling iteratee.
+func forEachConcurrent[R any](ctx context.Context, workers int, produce func(ctx context.Context, yield func(row R) (bool, error)) error, iteratee func(row R) (bool, error)) error {
+	if workers <= 0 {
+		return fmt.Errorf("invalid workers %d", workers)
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		stopped  bool
+		firstErr error
+	)
+	stop := func(err error) {
+		once.Do(func() {
+			stopped = true
+			firstErr = err
+			cancel()
+		})
+	}
+
+	queue := make(chan R)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for row := range queue {
+				if ctx.Err() != nil {
+					// stopped or cancelled, the queue is drained without calling iteratee
+					continue
+				}
+
+				if ok, err := iteratee(row); err != nil {
+					stop(err)
+				} else if !ok {
+					stop(nil)
+				}
+			}
+		}()
+	}
+
+	err := produce(ctx, func(row R) (bool, error) {
+		select {
+		case queue <- row:
+			return true, nil
+		case <-ctx.Done():
+			return false, nil
+		}
+	})
+	close(queue)
+	wg.Wait()
+
+	if stopped {
+		// the query may be interrupted by the cancellation, it's not an error
+		return firstErr
+	} else if err := parent.Err(); err != nil {
+		return err
+	}
+	return err
+}
+
 // UpdateByQuery queries for entities and updates them using the apply function. If apply returns false for a row, that update is skipped.
 func (r *Repository[ID, R]) UpdateByQuery(ctx context.Context, stmt *goqu.SelectDataset, apply func(row R) (bool, error)) error {
-	return r.ForEach(ctx, stmt, func(row R) (bool, error) {
+	return r.ForEach(ForcePrimary(ctx), stmt, func(row R) (bool, error) {
 		if ok, err := apply(row); err != nil || !ok {
 			return false, err
 		} else if err := r.Update(ctx, row); err != nil {
@@ -145,15 +498,152 @@ func (r *Repository[ID, R]) UpdateByQuery(ctx context.Context, stmt *goqu.Select
 	})
 }
 
+// Chunk walks through the entities matching the query statement in batches ordered by primary key.
+//
+// Each batch is an independent query that starts after the primary key of the last row of the previous batch,
+// so no cursor is kept open while fn is running. Use WithChunkTransaction to handle each batch in its own transaction,
+// the transaction is set to the variable of the option before fn is called.
+//
+// The batches are ordered and limited by primary key, an error is returned if stmt has order, limit or offset.
+func (r *Repository[ID, R]) Chunk(ctx context.Context, stmt *goqu.SelectDataset, size int, fn func(rows []R) error, opts ...ChunkOption) error {
+	if size <= 0 {
+		return fmt.Errorf("invalid chunk size %d", size)
+	} else if clauses := stmt.GetClauses(); clauses.HasOrder() || clauses.Limit() != nil || clauses.Offset() > 0 {
+		return errors.New("chunk statement can't have order, limit or offset")
+	}
+
+	md, err := getMetadata(reflect.New(r.rowType).Interface().(R))
+	if err != nil {
+		return fmt.Errorf("get metadata, %w", err)
+	}
+
+	options := &chunkOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	stmt, table := r.applyScopesRef(ctx, stmt)
+
+	// the order of scopes is replaced by primary key order
+	orders := make([]exp.OrderedExpression, 0, len(md.PrimaryKeys))
+	for _, col := range md.PrimaryKeys {
+		orders = append(orders, goqu.T(table).Col(col.DBField).Asc())
+	}
+	stmt = stmt.ClearOrder().ClearLimit().ClearOffset().Order(orders...).Limit(uint(size))
+
+	checkpoint := options.checkpoint
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		query := stmt
+		if len(checkpoint.LastKey) > 0 {
+			values, err := primaryKeyTyped(md, checkpoint.LastKey)
+			if err != nil {
+				return fmt.Errorf("resume from checkpoint, %w", err)
+			}
+
+			where, err := keysetAfter(table, md.PrimaryKeys, values)
+			if err != nil {
+				return fmt.Errorf("resume from checkpoint, %w", err)
+			}
+			query = query.Where(where)
+		}
+
+		var rows []R
+		if err := GetRecords(ctx, &rows, r.db, query); err != nil {
+			return fmt.Errorf("query batch %d, %w", checkpoint.Batches+1, err)
+		} else if len(rows) == 0 {
+			return nil
+		} else if err := afterFindAll(ctx, rows); err != nil {
+			return err
+		}
+
+		if options.transaction == nil {
+			err = fn(rows)
+		} else {
+			err = options.transaction(ctx, r.db, func(db DB) error {
+				*options.tx = db
+				return fn(rows)
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("handle batch %d, %w", checkpoint.Batches+1, err)
+		}
+
+		checkpoint.Batches++
+		checkpoint.Rows += len(rows)
+		checkpoint.LastKey = primaryKeyValues(md, rows[len(rows)-1])
+
+		if options.progress != nil {
+			if err := options.progress(ctx, checkpoint); err != nil {
+				return fmt.Errorf("report progress, %w", err)
+			}
+		}
+
+		if len(rows) < size {
+			return nil
+		}
+	}
+}
+
+// DeleteByQuery deletes entities matching the query statement one by one and returns the number of deleted entities.
+// Hooks and cache invalidation are performed for each entity, use DeleteByQueryWithoutHooks for large amounts of data.
+// The entities are deleted in a transaction if the database can begin one, nothing is deleted if any of them fails,
+// otherwise, such as ShardedDB without the shard key of ctx, the entities deleted before the failure are kept.
+// All the matching entities are loaded into memory before deleting, narrow the query or use Chunk for large tables.
+func (r *Repository[ID, R]) DeleteByQuery(ctx context.Context, stmt *goqu.SelectDataset) (int, error) {
+	var deleted int
+	deleteRows := func(db DB) error {
+		repo := r.WithDB(db)
+
+		rows, err := repo.Query(ctx, stmt)
+		if err != nil {
+			return fmt.Errorf("query rows, %w", err)
+		}
+
+		for _, row := range rows {
+			if err := repo.Delete(ctx, row); err != nil {
+				return err
+			}
+		}
+		deleted = len(rows)
+		return nil
+	}
+
+	var err error
+	if canBeginTx(ctx, r.db) {
+		err = tryTransaction(ctx, r.db, deleteRows)
+	} else {
+		err = deleteRows(r.db)
+	}
+	return deleted, err
+}
+
+// DeleteByQueryWithoutHooks deletes entities matching the query statement with a single DELETE statement
+// and returns the number of affected rows.
+// No hook is executed and no cache is invalidated, and no entity is loaded into memory like DeleteByQuery does.
+func (r *Repository[ID, R]) DeleteByQueryWithoutHooks(ctx context.Context, stmt *goqu.SelectDataset) (int64, error) {
+	result, err := ExecDelete(ctx, r.db, r.applyScopes(ctx, stmt).Delete())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 // Get retrieves a single entity matching the query statement.
 func (r *Repository[ID, R]) Get(ctx context.Context, stmt *goqu.SelectDataset) (R, error) {
 	row := reflect.New(r.rowType).Interface().(R)
-	if err := GetRecord(ctx, row, r.db, stmt); err != nil {
+	if err := GetRecord(ctx, row, r.db, r.applyScopes(ctx, stmt)); err != nil {
 		var x R
 		if errors.Is(err, sql.ErrNoRows) {
 			return x, ErrNotFound
 		}
 
+		return x, err
+	} else if err := afterFind(ctx, row); err != nil {
+		var x R
 		return x, err
 	}
 
@@ -163,14 +653,47 @@ func (r *Repository[ID, R]) Get(ctx context.Context, stmt *goqu.SelectDataset) (
 // Query retrieves a list of entities matching the query statement.
 func (r *Repository[ID, R]) Query(ctx context.Context, stmt *goqu.SelectDataset) ([]R, error) {
 	var rows []R
-	if err := GetRecords(ctx, &rows, r.db, stmt); err != nil {
+	if err := GetRecords(ctx, &rows, r.db, r.applyScopes(ctx, stmt)); err != nil {
+		return nil, err
+	} else if err := afterFindAll(ctx, rows); err != nil {
 		return nil, err
 	}
 	return rows, nil
 }
 
+// Where retrieves a list of entities matching all the conditions.
+func (r *Repository[ID, R]) Where(ctx context.Context, exprs ...exp.Expression) ([]R, error) {
+	return r.Query(ctx, r.Dataset().Where(exprs...))
+}
+
+// GetWhere retrieves a single entity matching all the conditions.
+func (r *Repository[ID, R]) GetWhere(ctx context.Context, exprs ...exp.Expression) (R, error) {
+	return r.Get(ctx, r.Dataset().Where(exprs...).Limit(1))
+}
+
+// Count returns the number of entities matching the query statement.
+func (r *Repository[ID, R]) Count(ctx context.Context, stmt *goqu.SelectDataset) (int, error) {
+	return GetTotalCount(ctx, r.db, r.applyScopes(ctx, stmt))
+}
+
+// Exists checks whether any entity matches the query statement.
+func (r *Repository[ID, R]) Exists(ctx context.Context, stmt *goqu.SelectDataset) (bool, error) {
+	stmt = r.applyScopes(ctx, stmt).Select(goqu.L(`1`)).ClearOrder().ClearOffset().Limit(1)
+
+	var found int
+	if err := GetRecord(ctx, &found, r.db, stmt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 // PageQuery retrieves a paginated list of entities matching the query statement.
 func (r *Repository[ID, R]) PageQuery(ctx context.Context, stmt *goqu.SelectDataset, currentPage, pageSize int) (rows []R, page Pagination, err error) {
+	stmt = r.applyScopes(ctx, stmt)
+
 	total, err := GetTotalCount(ctx, r.db, stmt)
 	if err != nil {
 		err = fmt.Errorf("query total count, %w", err)
@@ -183,155 +706,581 @@ func (r *Repository[ID, R]) PageQuery(ctx context.Context, stmt *goqu.SelectData
 	}
 
 	stmt = stmt.Limit(page.ULimit()).Offset(page.UOffset())
-	err = GetRecords(ctx, &rows, r.db, stmt)
+	if err = GetRecords(ctx, &rows, r.db, stmt); err != nil {
+		return
+	}
+
+	err = afterFindAll(ctx, rows)
 	return
 }
 
-// PersistentObject is an interface for domain objects that can be persisted to the database.
-type PersistentObject[ID comparable, DO any] interface {
-	Row[ID]
+func afterFind(ctx context.Context, row Entity) error {
+	if err := runHooks(ctx, row, EventAfterFind); err != nil {
+		return fmt.Errorf("after find, %w", err)
+	}
+	return nil
+}
 
-	GetID() ID
-	Set(context.Context, DO) error
-	ToDomainObject() (DO, error)
+func afterFindAll[R Entity](ctx context.Context, rows []R) error {
+	for _, row := range rows {
+		if err := afterFind(ctx, row); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// DomainObjectRepository is a repository for domain objects.
+// CompositeKey can be embedded into a row struct whose ID is a struct mapped onto the primary key columns by db tags,
+// it satisfies the SetID method of Row interface and leaves the primary key assignment to Repository.
 //
-// Note: Some methods accept *goqu.SelectDataset parameters, which exposes technical implementation details
-// and violates DDD principles. Therefore, DomainObjectRepository should not be used as a final implementation
-// but rather as a component of the final implementation.
-type DomainObjectRepository[ID comparable, DO any, PO PersistentObject[ID, DO]] struct {
-	poRepository *Repository[ID, PO]
-	poType       reflect.Type
+// Example:
+//
+//	type MemberKey struct {
+//		OrgID  int64 `db:"org_id"`
+//		UserID int64 `db:"user_id"`
+//	}
+//
+//	type memberRow struct {
+//		entity.CompositeKey[MemberKey]
+//
+//		OrgID  int64 `db:"org_id,primaryKey"`
+//		UserID int64 `db:"user_id,primaryKey"`
+//	}
+type CompositeKey[ID comparable] struct{}
+
+// SetID implements Row interface, the primary key fields are assigned by Repository.
+func (CompositeKey[ID]) SetID(ID) error {
+	return nil
 }
 
-// NewDomainObjectRepository creates a new DomainObjectRepository.
-func NewDomainObjectRepository[ID comparable, DO any, PO PersistentObject[ID, DO]](
-	persistentRepository *Repository[ID, PO],
-) *DomainObjectRepository[ID, DO, PO] {
-	var x PO
-	poType := reflect.TypeOf(x)
-	if poType.Kind() == reflect.Pointer {
-		poType = poType.Elem()
-	}
+// compositeKey maps the fields of a struct ID onto the primary key fields of a row.
+type compositeKey struct {
+	idFields  [][]int
+	rowFields [][]int
+}
 
-	return &DomainObjectRepository[ID, DO, PO]{
-		poRepository: persistentRepository,
-		poType:       poType,
+// newCompositeKey returns nil if the ID is not a struct or none of its fields is mapped onto the primary keys.
+func newCompositeKey(idType reflect.Type, row Entity) (*compositeKey, error) {
+	if idType == nil || idType.Kind() != reflect.Struct {
+		return nil, nil
 	}
-}
 
-// Find retrieves a domain object by ID.
-func (r *DomainObjectRepository[ID, DO, PO]) Find(ctx context.Context, id ID) (DO, error) {
-	po, err := r.poRepository.Find(ctx, id)
+	md, err := getMetadata(row)
 	if err != nil {
-		var x DO
-		return x, err
+		return nil, fmt.Errorf("get metadata, %w", err)
 	}
 
-	return po.ToDomainObject()
-}
+	pks := map[string]struct{}{}
+	for _, col := range md.PrimaryKeys {
+		pks[col.DBField] = struct{}{}
+	}
 
-// Create saves a new domain object to the database.
-func (r *DomainObjectRepository[ID, DO, PO]) Create(ctx context.Context, do DO) error {
-	po, err := r.NewPersistentObject(ctx, do)
+	key := &compositeKey{}
+	var unknown []string
+	rowMap := mapper.TypeMap(md.Type)
+	for _, fi := range mapper.TypeMap(idType).Tree.Children {
+		if fi == nil || fi.Embedded {
+			continue
+		}
+
+		if _, ok := pks[fi.Name]; !ok {
+			unknown = append(unknown, fi.Name)
+			continue
+		}
+		delete(pks, fi.Name)
+
+		rf := rowMap.Names[fi.Name]
+		if !fi.Field.Type.ConvertibleTo(rf.Field.Type) {
+			return nil, fmt.Errorf("field %q, cannot convert %s to %s", fi.Name, fi.Field.Type, rf.Field.Type)
+		}
+
+		key.idFields = append(key.idFields, fi.Index)
+		key.rowFields = append(key.rowFields, rf.Index)
+	}
+
+	if len(key.idFields) == 0 {
+		return nil, nil
+	} else if len(unknown) > 0 {
+		return nil, fmt.Errorf("%s is not primary key of %q", strings.Join(unknown, ", "), md.Type)
+	} else if len(pks) > 0 {
+		missing := make([]string, 0, len(pks))
+		for name := range pks {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return nil, fmt.Errorf("primary key %s of %q not found in %s", strings.Join(missing, ", "), md.Type, idType)
+	}
+
+	return key, nil
+}
+
+func (ck *compositeKey) assign(id, row reflect.Value) {
+	id = reflect.Indirect(id)
+	row = reflect.Indirect(row)
+
+	for i, index := range ck.idFields {
+		dst := reflectx.FieldByIndexes(row, ck.rowFields[i])
+		dst.Set(id.FieldByIndex(index).Convert(dst.Type()))
+	}
+}
+
+// primaryKeysIn builds the condition that matches any of the given primary key values.
+func primaryKeysIn(keys []Column, values []map[string]any) exp.Expression {
+	if len(keys) == 1 {
+		col := keys[0].DBField
+
+		vals := make([]any, 0, len(values))
+		for _, v := range values {
+			vals = append(vals, v[col])
+		}
+		return goqu.C(col).In(vals...)
+	}
+
+	conds := make([]exp.Expression, 0, len(values))
+	for _, v := range values {
+		ex := goqu.Ex{}
+		for _, col := range keys {
+			ex[col.DBField] = v[col.DBField]
+		}
+		conds = append(conds, ex)
+	}
+	return goqu.Or(conds...)
+}
+
+// ChunkCheckpoint records the progress of Repository.Chunk.
+//
+// It is reported after every finished batch, persist it and pass it back by WithChunkResume to continue after a crash.
+type ChunkCheckpoint struct {
+	// Batches is the number of finished batches.
+	Batches int `json:"batches"`
+	// Rows is the number of handled rows.
+	Rows int `json:"rows"`
+	// LastKey contains the primary key values of the last handled row, keyed by column name.
+	// The values decoded from JSON are converted back to the types of primary key fields when resuming.
+	LastKey map[string]any `json:"last_key,omitempty"`
+}
+
+// UnmarshalJSON decodes the numbers of LastKey as json.Number, so the large integers keep their precision.
+func (c *ChunkCheckpoint) UnmarshalJSON(data []byte) error {
+	type checkpoint ChunkCheckpoint
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode((*checkpoint)(c))
+}
+
+// ChunkOption is an option for Repository.Chunk.
+type ChunkOption func(*chunkOptions)
+
+type chunkOptions struct {
+	transaction func(ctx context.Context, db DB, fn func(db DB) error) error
+	tx          *DB
+	progress    func(ctx context.Context, checkpoint ChunkCheckpoint) error
+	checkpoint  ChunkCheckpoint
+}
+
+// WithChunkTransaction handles each batch in its own transaction, tx is set to the transaction before the batch is handled,
+// so the handler can write the batch in it. The specific Tx type must be explicitly specified
+// as it cannot be derived from the DB interface.
+//
+// Example:
+//
+//	var tx entity.DB
+//	err := repo.Chunk(ctx, stmt, 100, func(rows []*User) error {
+//		for _, row := range rows {
+//			if err := repo.WithDB(tx).Update(ctx, row); err != nil {
+//				return err
+//			}
+//		}
+//		return nil
+//	}, entity.WithChunkTransaction[*sqlx.Tx](nil, &tx))
+func WithChunkTransaction[T Tx](opt *sql.TxOptions, tx *DB) ChunkOption {
+	if tx == nil {
+		tx = new(DB)
+	}
+
+	return func(o *chunkOptions) {
+		o.tx = tx
+		o.transaction = func(ctx context.Context, db DB, fn func(db DB) error) error {
+			return TryTransactionWithOptionsX[T](ctx, db, opt, fn)
+		}
+	}
+}
+
+// WithChunkProgress sets a callback that is called after each batch is handled.
+// Returning an error from the callback stops the iteration.
+func WithChunkProgress(fn func(ctx context.Context, checkpoint ChunkCheckpoint) error) ChunkOption {
+	return func(o *chunkOptions) {
+		o.progress = fn
+	}
+}
+
+// WithChunkResume continues the iteration after the given checkpoint.
+func WithChunkResume(checkpoint ChunkCheckpoint) ChunkOption {
+	return func(o *chunkOptions) {
+		o.checkpoint = checkpoint
+	}
+}
+
+// keysetAfter builds the condition that matches rows after the given primary key values in primary key order,
+// the columns are qualified by table.
+func keysetAfter(table string, keys []Column, values map[string]any) (exp.Expression, error) {
+	conds := make([]exp.Expression, 0, len(keys))
+	for i, col := range keys {
+		and := make([]exp.Expression, 0, i+1)
+		for _, prev := range keys[:i] {
+			and = append(and, goqu.T(table).Col(prev.DBField).Eq(values[prev.DBField]))
+		}
+
+		v, ok := values[col.DBField]
+		if !ok {
+			return nil, fmt.Errorf("missing primary key %q", col.DBField)
+		}
+		and = append(and, goqu.T(table).Col(col.DBField).Gt(v))
+
+		conds = append(conds, goqu.And(and...))
+	}
+
+	return goqu.Or(conds...), nil
+}
+
+// primaryKeyTyped converts the primary key values to the types of primary key fields,
+// such as the json.Number and string decoded from a persisted checkpoint.
+func primaryKeyTyped(md *Metadata, values map[string]any) (map[string]any, error) {
+	tm := mapper.TypeMap(md.Type)
+
+	typed := make(map[string]any, len(values))
+	for _, col := range md.PrimaryKeys {
+		v, ok := values[col.DBField]
+		fi := tm.GetByPath(col.DBField)
+		if !ok || fi == nil || v == nil || reflect.TypeOf(v) == fi.Field.Type {
+			typed[col.DBField] = v
+			continue
+		}
+
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("primary key %q, %w", col.DBField, err)
+		}
+
+		value := reflect.New(fi.Field.Type)
+		if err := json.Unmarshal(data, value.Interface()); err != nil {
+			return nil, fmt.Errorf("primary key %q, %w", col.DBField, err)
+		}
+		typed[col.DBField] = value.Elem().Interface()
+	}
+	return typed, nil
+}
+
+func primaryKeyCondition(md *Metadata, ent Entity) exp.Expression {
+	ex := goqu.Ex{}
+	for col, v := range primaryKeyValues(md, ent) {
+		ex[col] = v
+	}
+	return ex
+}
+
+func primaryKeyValues(md *Metadata, ent Entity) map[string]any {
+	rv := reflect.ValueOf(ent)
+
+	values := make(map[string]any, len(md.PrimaryKeys))
+	for _, col := range md.PrimaryKeys {
+		values[col.DBField] = mapper.FieldByName(rv, col.DBField).Interface()
+	}
+	return values
+}
+
+// PersistentObject is an interface for domain objects that can be persisted to the database.
+type PersistentObject[ID comparable, DO any] interface {
+	Row[ID]
+
+	GetID() ID
+	Set(context.Context, DO) error
+	ToDomainObject() (DO, error)
+}
+
+// Aggregate is implemented by persistent objects of aggregates spanning a root table and child tables.
+//
+// DomainObjectRepository loads the children after the root is read, and writes the children with the root
+// in one transaction. The children are compared by type and primary key with the ones loaded by UpdateBy
+// and UpdateByQuery, or the ones stored in the database for Update, Upsert and Delete:
+// new ones are inserted, changed ones are updated and missing ones are deleted.
+// Use a version column on the root for optimistic locking.
+type Aggregate interface {
+	// LoadChildren loads the child entities of the root from the database.
+	LoadChildren(ctx context.Context, db DB) error
+	// Children returns the current child entities. It's called after the root is written,
+	// so the foreign keys of children can be filled from the root.
+	Children() []Entity
+}
+
+// DomainObjectRepository is a repository for domain objects.
+//
+// Note: Some methods accept *goqu.SelectDataset parameters, which exposes technical implementation details
+// and violates DDD principles. Therefore, DomainObjectRepository should not be used as a final implementation
+// but rather as a component of the final implementation. The *BySpec methods accept spec.Specification
+// built from the fields of domain object instead.
+type DomainObjectRepository[ID comparable, DO any, PO PersistentObject[ID, DO]] struct {
+	poRepository *Repository[ID, PO]
+	poType       reflect.Type
+	aggregate    bool
+	events       *DomainEventBus
+	fields       map[string]string
+}
+
+// DomainObjectRepositoryOption is an option for NewDomainObjectRepository.
+type DomainObjectRepositoryOption func(*domainObjectRepositoryOptions)
+
+type domainObjectRepositoryOptions struct {
+	events *DomainEventBus
+	fields map[string]string
+}
+
+// WithDomainEventBus dispatches the domain events recorded by domain objects implementing DomainEventSource
+// after they are written by Create, Update, UpdateBy, UpdateByQuery, Upsert or Delete.
+func WithDomainEventBus(bus *DomainEventBus) DomainObjectRepositoryOption {
+	return func(o *domainObjectRepositoryOptions) {
+		o.events = bus
+	}
+}
+
+// NewDomainObjectRepository creates a new DomainObjectRepository.
+func NewDomainObjectRepository[ID comparable, DO any, PO PersistentObject[ID, DO]](
+	persistentRepository *Repository[ID, PO],
+	opts ...DomainObjectRepositoryOption,
+) *DomainObjectRepository[ID, DO, PO] {
+	var x PO
+	poType := reflect.TypeOf(x)
+	if poType.Kind() == reflect.Pointer {
+		poType = poType.Elem()
+	}
+
+	options := &domainObjectRepositoryOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	_, aggregate := reflect.New(poType).Interface().(Aggregate)
+
+	return &DomainObjectRepository[ID, DO, PO]{
+		poRepository: persistentRepository,
+		poType:       poType,
+		aggregate:    aggregate,
+		events:       options.events,
+		fields:       options.fields,
+	}
+}
+
+// Find retrieves a domain object by ID.
+func (r *DomainObjectRepository[ID, DO, PO]) Find(ctx context.Context, id ID) (DO, error) {
+	po, err := r.poRepository.Find(ctx, id)
 	if err != nil {
-		return fmt.Errorf("new persistent object, %w", err)
+		var x DO
+		return x, err
+	} else if err := r.loadChildren(ctx, r.poRepository.db, po); err != nil {
+		var x DO
+		return x, err
 	}
 
-	return r.poRepository.Create(ctx, po)
+	return po.ToDomainObject()
 }
 
-// Update updates an existing domain object in the database.
-func (r *DomainObjectRepository[ID, DO, PO]) Update(ctx context.Context, do DO) error {
-	po, err := r.NewPersistentObject(ctx, do)
+// FindMany retrieves domain objects by IDs, the order of results is not guaranteed.
+func (r *DomainObjectRepository[ID, DO, PO]) FindMany(ctx context.Context, ids ...ID) ([]DO, error) {
+	rows, err := r.poRepository.FindMany(ctx, ids...)
 	if err != nil {
-		return fmt.Errorf("new persistent object, %w", err)
+		return nil, err
+	} else if err := r.loadChildren(ctx, r.poRepository.db, rows...); err != nil {
+		return nil, err
 	}
 
-	return r.poRepository.Update(ctx, po)
+	return r.ToDomainObjects(rows)
+}
+
+// Create saves a new domain object to the database.
+func (r *DomainObjectRepository[ID, DO, PO]) Create(ctx context.Context, do DO) error {
+	return r.save(ctx, OperationInsert, do)
+}
+
+// Update updates an existing domain object in the database.
+func (r *DomainObjectRepository[ID, DO, PO]) Update(ctx context.Context, do DO) error {
+	return r.save(ctx, OperationUpdate, do)
 }
 
 // UpdateBy retrieves a domain object by ID and updates it using the apply function.
 func (r *DomainObjectRepository[ID, DO, PO]) UpdateBy(ctx context.Context, id ID, apply func(do DO) (bool, error)) error {
-	return r.poRepository.UpdateBy(ctx, id, func(po PO) (ok bool, err error) {
-		defer func() {
-			if err != nil {
-				err = fmt.Errorf("id %v, %w", id, err)
+	if !r.aggregate && r.events == nil {
+		return r.poRepository.UpdateBy(ctx, id, func(po PO) (ok bool, err error) {
+			defer func() {
+				if err != nil {
+					err = fmt.Errorf("id %v, %w", id, err)
+				}
+			}()
+
+			if v, err := po.ToDomainObject(); err != nil {
+				return false, fmt.Errorf("convert to domain object, %w", err)
+			} else if ok, err := apply(v); err != nil || !ok {
+				return false, err
+			} else if err := po.Set(ctx, v); err != nil {
+				return false, fmt.Errorf("set persistent object, %w", err)
 			}
-		}()
 
-		if v, err := po.ToDomainObject(); err != nil {
-			return false, fmt.Errorf("convert to domain object, %w", err)
-		} else if ok, err := apply(v); err != nil || !ok {
-			return false, err
-		} else if err := po.Set(ctx, v); err != nil {
-			return false, fmt.Errorf("set persistent object, %w", err)
+			return true, nil
+		})
+	}
+
+	var events []any
+	err := r.transaction(ctx, r.poRepository.db, func(db DB) error {
+		po, err := r.poRepository.WithDB(db).Find(ForcePrimary(ctx), id)
+		if err != nil {
+			return err
 		}
 
-		return true, nil
+		do, loaded, ok, err := r.apply(ctx, db, po, apply)
+		if err != nil {
+			return fmt.Errorf("id %v, %w", id, err)
+		} else if !ok {
+			return nil
+		}
+
+		events, err = r.write(ctx, db, OperationUpdate, po, do, loaded)
+		return err
 	})
+	if err != nil {
+		return err
+	}
+	return r.dispatchAfterCommit(ctx, events)
 }
 
 // UpdateByQuery queries for domain objects and updates them using the apply function.
+// The matching rows of aggregates or domain objects with events are read into memory before any of them is written.
+//
+// The domain events are dispatched after all the rows are written. If there is any handler called in transaction,
+// all the rows are written in one transaction, so an error of the handlers rolls back all of them.
 func (r *DomainObjectRepository[ID, DO, PO]) UpdateByQuery(ctx context.Context, stmt *goqu.SelectDataset, apply func(do DO) (bool, error)) error {
-	return r.poRepository.UpdateByQuery(ctx, stmt, func(po PO) (ok bool, err error) {
-		defer func() {
-			if err != nil {
-				err = fmt.Errorf("id %v, %w", po.GetID(), err)
+	if !r.aggregate && r.events == nil {
+		return r.poRepository.UpdateByQuery(ctx, stmt, func(po PO) (ok bool, err error) {
+			defer func() {
+				if err != nil {
+					err = fmt.Errorf("id %v, %w", po.GetID(), err)
+				}
+			}()
+
+			if v, err := po.ToDomainObject(); err != nil {
+				return false, fmt.Errorf("convert to domain object, %w", err)
+			} else if ok, err := apply(v); err != nil || !ok {
+				return false, err
+			} else if err := po.Set(ctx, v); err != nil {
+				return false, fmt.Errorf("set persistent object, %w", err)
 			}
-		}()
 
-		if v, err := po.ToDomainObject(); err != nil {
-			return false, fmt.Errorf("convert to domain object, %w", err)
-		} else if ok, err := apply(v); err != nil || !ok {
-			return false, err
-		} else if err := po.Set(ctx, v); err != nil {
-			return false, fmt.Errorf("set persistent object, %w", err)
+			return true, nil
+		})
+	}
+
+	// the rows are read before writing, the children and events are written by other statements,
+	// which can't be issued on the connection of an open cursor by some drivers
+	rows, err := r.poRepository.Query(ForcePrimary(ctx), stmt)
+	if err != nil {
+		return err
+	}
+
+	var events []any
+	update := func(db DB) error {
+		for _, po := range rows {
+			var ok bool
+			err := r.transaction(ctx, db, func(db DB) error {
+				do, loaded, applied, err := r.apply(ctx, db, po, apply)
+				if err != nil {
+					return fmt.Errorf("id %v, %w", po.GetID(), err)
+				} else if ok = applied; !ok {
+					return nil
+				}
+
+				written, err := r.write(ctx, db, OperationUpdate, po, do, loaded)
+				if err != nil {
+					return err
+				}
+
+				events = append(events, written...)
+				return nil
+			})
+			if err != nil {
+				return err
+			} else if !ok {
+				return nil
+			}
 		}
+		return nil
+	}
 
-		return true, nil
-	})
+	if r.events == nil {
+		return update(r.poRepository.db)
+	} else if err := r.events.transaction(ctx, r.poRepository.db, update); err != nil {
+		if r.events.transactional() {
+			return err
+		}
+		// the rows written before the error have been committed
+		return errors.Join(err, r.dispatchAfterCommit(ctx, events))
+	}
+	return r.dispatchAfterCommit(ctx, events)
 }
 
 // Upsert inserts a new domain object or updates an existing one.
 func (r *DomainObjectRepository[ID, DO, PO]) Upsert(ctx context.Context, do DO) error {
-	po, err := r.NewPersistentObject(ctx, do)
-	if err != nil {
-		return fmt.Errorf("new persistent object, %w", err)
-	}
-
-	return r.poRepository.Upsert(ctx, po)
+	return r.save(ctx, OperationUpsert, do)
 }
 
 // Delete removes a domain object from the database.
 func (r *DomainObjectRepository[ID, DO, PO]) Delete(ctx context.Context, do DO) error {
-	po, err := r.NewPersistentObject(ctx, do)
-	if err != nil {
-		return fmt.Errorf("new persistent object, %w", err)
-	}
-
-	return r.poRepository.Delete(ctx, po)
+	return r.save(ctx, OperationDelete, do)
 }
 
 // ForEach iterates over domain objects matching the query. The iteratee function should return false to stop iteration.
+//
+// The rows of aggregates are read into memory before their children are loaded,
+// because the children can't be queried on the connection of an open cursor by some drivers,
+// use Chunk of the persistent repository for large tables.
 func (r *DomainObjectRepository[ID, DO, PO]) ForEach(ctx context.Context, stmt *goqu.SelectDataset, iteratee func(do DO) (bool, error)) error {
-	return r.poRepository.ForEach(ctx, stmt, func(po PO) (ok bool, err error) {
-		defer func() {
-			if err != nil {
-				err = fmt.Errorf("id %v, %w", po.GetID(), err)
-			}
-		}()
+	each := r.iterateDomainObject(ctx, iteratee)
+	if !r.aggregate {
+		return r.poRepository.ForEach(ctx, stmt, each)
+	}
 
-		if do, err := po.ToDomainObject(); err != nil {
-			return false, fmt.Errorf("convert to domain object, %w", err)
-		} else if ok, err := iteratee(do); err != nil || !ok {
-			return false, err
+	rows, err := r.poRepository.Query(ctx, stmt)
+	if err != nil {
+		return err
+	}
+
+	for _, po := range rows {
+		if ok, err := each(po); err != nil || !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForEachConcurrent iterates over domain objects matching the query with a pool of workers.
+// The iteratee function should return false to stop iteration.
+// The rows of aggregates are read into memory first like ForEach.
+func (r *DomainObjectRepository[ID, DO, PO]) ForEachConcurrent(ctx context.Context, stmt *goqu.SelectDataset, workers int, iteratee func(do DO) (bool, error)) error {
+	if r.aggregate {
+		rows, err := r.poRepository.Query(ctx, stmt)
+		if err != nil {
+			return err
 		}
 
-		return true, nil
-	})
+		return forEachConcurrent(ctx, workers, func(ctx context.Context, yield func(po PO) (bool, error)) error {
+			for _, po := range rows {
+				if ok, err := yield(po); err != nil || !ok {
+					return err
+				}
+			}
+			return nil
+		}, r.iterateDomainObject(ctx, iteratee))
+	}
+
+	return r.poRepository.ForEachConcurrent(ctx, stmt, workers, r.iterateDomainObject(ctx, iteratee))
 }
 
 // Get retrieves a single domain object matching the query statement.
@@ -340,6 +1289,9 @@ func (r *DomainObjectRepository[ID, DO, PO]) Get(ctx context.Context, stmt *goqu
 	if err != nil {
 		var x DO
 		return x, err
+	} else if err := r.loadChildren(ctx, r.poRepository.db, po); err != nil {
+		var x DO
+		return x, err
 	}
 
 	return po.ToDomainObject()
@@ -350,6 +1302,8 @@ func (r *DomainObjectRepository[ID, DO, PO]) Query(ctx context.Context, stmt *go
 	rows, err := r.poRepository.Query(ctx, stmt)
 	if err != nil {
 		return nil, err
+	} else if err := r.loadChildren(ctx, r.poRepository.db, rows...); err != nil {
+		return nil, err
 	}
 
 	return r.ToDomainObjects(rows)
@@ -360,6 +1314,8 @@ func (r *DomainObjectRepository[ID, DO, PO]) PageQuery(ctx context.Context, stmt
 	rows, page, err := r.poRepository.PageQuery(ctx, stmt, currentPage, pageSize)
 	if err != nil {
 		return nil, Pagination{}, err
+	} else if err := r.loadChildren(ctx, r.poRepository.db, rows...); err != nil {
+		return nil, Pagination{}, err
 	}
 
 	items, err := r.ToDomainObjects(rows)
@@ -389,3 +1345,327 @@ func (r *DomainObjectRepository[ID, DO, PO]) NewPersistentObject(ctx context.Con
 	po := reflect.New(r.poType).Interface().(PO)
 	return po, po.Set(ctx, do)
 }
+
+// iterateDomainObject returns the iteratee of persistent objects calling the iteratee of domain objects.
+func (r *DomainObjectRepository[ID, DO, PO]) iterateDomainObject(ctx context.Context, iteratee func(do DO) (bool, error)) func(po PO) (bool, error) {
+	return func(po PO) (ok bool, err error) {
+		defer func() {
+			if err != nil {
+				err = fmt.Errorf("id %v, %w", po.GetID(), err)
+			}
+		}()
+
+		if do, err := r.toDomainObject(ctx, po); err != nil {
+			return false, err
+		} else if ok, err := iteratee(do); err != nil || !ok {
+			return false, err
+		}
+
+		return true, nil
+	}
+}
+
+// toDomainObject loads the children of aggregate and converts the persistent object to domain object.
+func (r *DomainObjectRepository[ID, DO, PO]) toDomainObject(ctx context.Context, po PO) (DO, error) {
+	if err := r.loadChildren(ctx, r.poRepository.db, po); err != nil {
+		var x DO
+		return x, err
+	}
+
+	do, err := po.ToDomainObject()
+	if err != nil {
+		return do, fmt.Errorf("convert to domain object, %w", err)
+	}
+	return do, nil
+}
+
+// apply loads the children of aggregate, applies the changes of domain object to the persistent object.
+// It returns the copies of the loaded children, which the changed children are compared with when written.
+func (r *DomainObjectRepository[ID, DO, PO]) apply(ctx context.Context, db DB, po PO, apply func(do DO) (bool, error)) (v DO, loaded []Entity, ok bool, err error) {
+	if err := r.loadChildren(ctx, db, po); err != nil {
+		return v, nil, false, err
+	}
+
+	if r.aggregate {
+		children := any(po).(Aggregate).Children()
+		loaded = make([]Entity, 0, len(children))
+		for _, child := range children {
+			loaded = append(loaded, snapshotEntity(child))
+		}
+	}
+
+	if v, err = po.ToDomainObject(); err != nil {
+		return v, nil, false, fmt.Errorf("convert to domain object, %w", err)
+	} else if ok, err := apply(v); err != nil || !ok {
+		return v, nil, false, err
+	} else if err := po.Set(ctx, v); err != nil {
+		return v, nil, false, fmt.Errorf("set persistent object, %w", err)
+	}
+	return v, loaded, true, nil
+}
+
+// transaction runs fn in a transaction of db for aggregates, so the root and children are read and written consistently.
+func (r *DomainObjectRepository[ID, DO, PO]) transaction(ctx context.Context, db DB, fn func(db DB) error) error {
+	if r.aggregate {
+		return tryTransaction(ctx, db, fn)
+	}
+	return fn(db)
+}
+
+// save writes the domain object and dispatches its domain events.
+func (r *DomainObjectRepository[ID, DO, PO]) save(ctx context.Context, kind OperationKind, do DO) error {
+	po, err := r.NewPersistentObject(ctx, do)
+	if err != nil {
+		return fmt.Errorf("new persistent object, %w", err)
+	}
+
+	events, err := r.write(ctx, r.poRepository.db, kind, po, do, nil)
+	if err != nil {
+		return err
+	}
+	return r.dispatchAfterCommit(ctx, events)
+}
+
+// write writes the persistent object and dispatches the domain events in transaction,
+// it returns the events to be dispatched after commit.
+// The children of aggregate are compared with loaded, or the stored ones if loaded is nil.
+func (r *DomainObjectRepository[ID, DO, PO]) write(ctx context.Context, db DB, kind OperationKind, po PO, do DO, loaded []Entity) ([]any, error) {
+	src, ok := any(do).(DomainEventSource)
+	if !ok || r.events == nil {
+		return nil, r.writePersistentObject(ctx, db, kind, po, loaded)
+	}
+
+	events := src.DomainEvents()
+	err := r.events.transaction(ctx, db, func(db DB) error {
+		if err := r.writePersistentObject(ctx, db, kind, po, loaded); err != nil {
+			return err
+		}
+
+		if err := r.events.dispatchInTransaction(ctx, db, events); err != nil {
+			return fmt.Errorf("dispatch domain events, %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	src.ClearDomainEvents()
+	return events, nil
+}
+
+func (r *DomainObjectRepository[ID, DO, PO]) writePersistentObject(ctx context.Context, db DB, kind OperationKind, po PO, loaded []Entity) error {
+	if r.aggregate {
+		return r.saveAggregate(ctx, db, kind, po, loaded)
+	}
+
+	repo := r.poRepository.WithDB(db)
+	switch kind {
+	case OperationInsert:
+		return repo.Create(ctx, po)
+	case OperationUpdate:
+		return repo.Update(ctx, po)
+	case OperationUpsert:
+		return repo.Upsert(ctx, po)
+	case OperationDelete:
+		return repo.Delete(ctx, po)
+	}
+	return fmt.Errorf("unsupported operation %s", kind)
+}
+
+func (r *DomainObjectRepository[ID, DO, PO]) dispatchAfterCommit(ctx context.Context, events []any) error {
+	if len(events) == 0 {
+		return nil
+	}
+	return r.events.dispatchAfterCommit(ctx, events)
+}
+
+func (r *DomainObjectRepository[ID, DO, PO]) loadChildren(ctx context.Context, db DB, rows ...PO) error {
+	if !r.aggregate {
+		return nil
+	}
+
+	for _, po := range rows {
+		if err := any(po).(Aggregate).LoadChildren(ctx, db); err != nil {
+			return fmt.Errorf("id %v, load children, %w", po.GetID(), err)
+		}
+	}
+	return nil
+}
+
+// saveAggregate writes the root and synchronizes the children in one transaction.
+// The children are compared with loaded, the ones stored in the database are read if loaded is nil.
+func (r *DomainObjectRepository[ID, DO, PO]) saveAggregate(ctx context.Context, db DB, kind OperationKind, po PO, loaded []Entity) error {
+	return tryTransaction(ctx, db, func(db DB) error {
+		repo := r.poRepository.WithDB(db)
+
+		stored := loaded
+		if stored == nil && kind != OperationInsert {
+			origin, err := repo.factory(po.GetID())
+			if err != nil {
+				return fmt.Errorf("new row, %w", err)
+			} else if err := r.loadChildren(ctx, db, origin); err != nil {
+				return fmt.Errorf("load stored children, %w", err)
+			}
+			stored = any(origin).(Aggregate).Children()
+		}
+
+		switch kind {
+		case OperationInsert:
+			lastID, err := Insert(ctx, po, db)
+			if err != nil {
+				return err
+			} else if err := setAutoIncrementID(po, lastID); err != nil {
+				return err
+			}
+		case OperationUpdate:
+			if err := repo.Update(ctx, po); err != nil {
+				return err
+			}
+		case OperationUpsert:
+			if err := repo.Upsert(ctx, po); err != nil {
+				return err
+			}
+		case OperationDelete:
+			// children are deleted before the root because of foreign key constraints
+			if err := syncChildren(ctx, db, stored, nil); err != nil {
+				return err
+			}
+			return repo.Delete(ctx, po)
+		}
+
+		return syncChildren(ctx, db, stored, any(po).(Aggregate).Children())
+	})
+}
+
+// setAutoIncrementID sets the last insert id to the auto increment primary key of root,
+// so the foreign keys of children can be filled.
+func setAutoIncrementID(ent Entity, id int64) error {
+	if id == 0 {
+		return nil
+	}
+
+	md, err := getMetadata(ent)
+	if err != nil {
+		return fmt.Errorf("get metadata, %w", err)
+	}
+
+	for _, col := range md.PrimaryKeys {
+		if !col.AutoIncrement {
+			continue
+		}
+
+		v := fieldByColumn(reflect.ValueOf(ent), col.DBField)
+		if v.IsValid() && v.CanSet() && v.IsZero() {
+			switch v.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				v.SetInt(id)
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				v.SetUint(uint64(id))
+			}
+		}
+	}
+	return nil
+}
+
+// syncChildren deletes the stored children missing in current, updates the changed ones and inserts the new ones.
+func syncChildren(ctx context.Context, db DB, stored, current []Entity) error {
+	storedByKey := make(map[string]Entity, len(stored))
+	for _, child := range stored {
+		key, ok, err := identityKey(child)
+		if err != nil {
+			return err
+		} else if ok {
+			storedByKey[key] = child
+		}
+	}
+
+	var inserts, updates []Entity
+	for _, child := range current {
+		key, ok, err := identityKey(child)
+		if err != nil {
+			return err
+		}
+
+		if origin, found := storedByKey[key]; ok && found {
+			delete(storedByKey, key)
+
+			if changed, err := columnsChanged(origin, child); err != nil {
+				return err
+			} else if changed {
+				updates = append(updates, child)
+			}
+		} else {
+			inserts = append(inserts, child)
+		}
+	}
+
+	// delete first to avoid conflicts of unique keys
+	deletes := make([]string, 0, len(storedByKey))
+	for key := range storedByKey {
+		deletes = append(deletes, key)
+	}
+	sort.Strings(deletes)
+
+	for _, key := range deletes {
+		if err := Delete(ctx, storedByKey[key], db); err != nil {
+			return fmt.Errorf("delete child %s, %w", key, err)
+		}
+	}
+	for _, child := range updates {
+		if err := Update(ctx, child, db); err != nil {
+			return fmt.Errorf("update child %T, %w", child, err)
+		}
+	}
+	for _, child := range inserts {
+		if _, err := Insert(ctx, child, db); err != nil {
+			return fmt.Errorf("insert child %T, %w", child, err)
+		}
+	}
+	return nil
+}
+
+// identityKey returns the type and primary key values of an entity,
+// false if any primary key is zero value, which means a new entity with auto increment primary key.
+func identityKey(ent Entity) (string, bool, error) {
+	md, err := getMetadata(ent)
+	if err != nil {
+		return "", false, fmt.Errorf("get metadata, %w", err)
+	}
+
+	rv := reflect.ValueOf(ent)
+	values := make([]string, 0, len(md.PrimaryKeys)+1)
+	values = append(values, md.Type.String())
+	for _, col := range md.PrimaryKeys {
+		v, valid := indirectValue(fieldByColumn(rv, col.DBField))
+		if !valid || v.IsZero() {
+			return "", false, nil
+		}
+		values = append(values, relationKey(v))
+	}
+	return strings.Join(values, ","), true, nil
+}
+
+// columnsChanged reports whether the updatable columns of entity are different from the stored one.
+func columnsChanged(stored, ent Entity) (bool, error) {
+	md, err := getMetadata(ent)
+	if err != nil {
+		return false, fmt.Errorf("get metadata, %w", err)
+	}
+
+	sv, cv := reflect.ValueOf(stored), reflect.ValueOf(ent)
+	for _, col := range md.Columns {
+		if col.RefuseUpdate || col.ReturningUpdate {
+			continue
+		}
+
+		a, aValid := indirectValue(fieldByColumn(sv, col.DBField))
+		b, bValid := indirectValue(fieldByColumn(cv, col.DBField))
+		if aValid != bValid {
+			return true, nil
+		} else if aValid && !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
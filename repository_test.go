@@ -0,0 +1,952 @@
+package entity
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestKeysetAfter(t *testing.T) {
+	md, _ := newTestMetadata(&GenernalEntity{})
+
+	where, err := keysetAfter("genernal", md.PrimaryKeys, map[string]any{"id": 1, "id2": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query, _, err := goqu.From("genernal").Where(where).ToSQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `SELECT * FROM "genernal" WHERE (("genernal"."id" > 1) OR (("genernal"."id" = 1) AND ("genernal"."id2" > 2)))`
+	if query != expected {
+		t.Fatalf("keyset condition, Expected=%s, Actual=%s", expected, query)
+	}
+
+	if _, err := keysetAfter("genernal", md.PrimaryKeys, map[string]any{"id": 1}); err == nil {
+		t.Fatal("keyset condition without id2, Expected=error, Actual=nil")
+	}
+}
+
+func TestRepositoryScopes(t *testing.T) {
+	repo := NewRepository[int, *scopedEntity](nil,
+		WithDefaultScopes(func(stmt *goqu.SelectDataset) *goqu.SelectDataset {
+			return stmt.Where(goqu.C("status").Neq("deleted"))
+		}),
+		WithNamedScope("recent", func(stmt *goqu.SelectDataset) *goqu.SelectDataset {
+			return stmt.Where(goqu.C("id").Gt(100))
+		}),
+	)
+
+	cases := []struct {
+		repo     *Repository[int, *scopedEntity]
+		expected string
+	}{
+		{
+			repo:     repo,
+			expected: `SELECT * FROM "scoped" WHERE ("status" != 'deleted')`,
+		},
+		{
+			repo:     repo.Scopes("recent"),
+			expected: `SELECT * FROM "scoped" WHERE (("status" != 'deleted') AND ("id" > 100))`,
+		},
+		{
+			repo:     repo.Unscoped(),
+			expected: `SELECT * FROM "scoped"`,
+		},
+		{
+			repo:     repo.Unscoped().Scopes("recent"),
+			expected: `SELECT * FROM "scoped" WHERE ("id" > 100)`,
+		},
+	}
+
+	for _, c := range cases {
+		query, _, err := c.repo.applyScopes(context.Background(), goqu.From("scoped")).ToSQL()
+		if err != nil {
+			t.Fatal(err)
+		} else if query != c.expected {
+			t.Fatalf("scoped query, Expected=%s, Actual=%s", c.expected, query)
+		}
+	}
+
+	undefined := repo.Scopes("recent", "undefined")
+	if _, _, err := undefined.applyScopes(context.Background(), goqu.From("scoped")).ToSQL(); err == nil {
+		t.Fatal("undefined scope, Expected=error, Actual=nil")
+	} else if _, err := undefined.WithDB(sqlx.NewDb(nil, driverPostgres)).Find(context.Background(), 1); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("find with undefined scope, Expected=undefined scope error, Actual=%v", err)
+	}
+}
+
+type scopedEntity struct {
+	ID     int    `db:"id,primaryKey"`
+	Status string `db:"status"`
+}
+
+func (se *scopedEntity) TableName() string {
+	return "scoped"
+}
+
+func (se *scopedEntity) SetID(id int) error {
+	se.ID = id
+	return nil
+}
+
+func TestRepositoryDataset(t *testing.T) {
+	cases := map[string]string{
+		driverMysql:    "SELECT `scoped`.`id`, `scoped`.`status` FROM `scoped` WHERE (`id` = 1)",
+		driverPostgres: `SELECT "scoped"."id", "scoped"."status" FROM "scoped" WHERE ("id" = 1)`,
+	}
+
+	for driver, expected := range cases {
+		repo := NewRepository[int, *scopedEntity](sqlx.NewDb(nil, driver))
+
+		query, _, err := repo.Dataset().Where(goqu.C("id").Eq(1)).ToSQL()
+		if err != nil {
+			t.Fatal(err)
+		} else if query != expected {
+			t.Fatalf("%q dataset, Expected=%s, Actual=%s", driver, expected, query)
+		}
+	}
+}
+
+func TestCompositeKey(t *testing.T) {
+	repo := NewRepository[memberKey, *memberEntity](sqlx.NewDb(nil, driverPostgres))
+
+	row, err := repo.NewEntity(memberKey{OrgID: 1, UserID: 2})
+	if err != nil {
+		t.Fatal(err)
+	} else if row.OrgID != 1 || row.UserID != 2 {
+		t.Fatalf("composite key, Expected={1 2}, Actual={%d %d}", row.OrgID, row.UserID)
+	}
+
+	md, _ := newTestMetadata(&memberEntity{})
+	where := primaryKeysIn(md.PrimaryKeys, []map[string]any{
+		{"org_id": 1, "user_id": 2},
+		{"org_id": 1, "user_id": 3},
+	})
+	query, _, err := goqu.From("members").Where(where).ToSQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `SELECT * FROM "members" WHERE ((("org_id" = 1) AND ("user_id" = 2)) OR (("org_id" = 1) AND ("user_id" = 3)))`
+	if query != expected {
+		t.Fatalf("primary keys condition, Expected=%s, Actual=%s", expected, query)
+	}
+
+	type partialKey struct {
+		OrgID int64 `db:"org_id"`
+	}
+	if _, err := newCompositeKey(reflect.TypeOf(partialKey{}), &memberEntity{}); err == nil {
+		t.Fatal("partial composite key, Expected=error, Actual=nil")
+	}
+}
+
+type memberKey struct {
+	OrgID  int64 `db:"org_id"`
+	UserID int64 `db:"user_id"`
+}
+
+type memberEntity struct {
+	CompositeKey[memberKey]
+
+	OrgID  int64  `db:"org_id,primaryKey"`
+	UserID int64  `db:"user_id,primaryKey"`
+	Role   string `db:"role"`
+}
+
+func (me *memberEntity) TableName() string {
+	return "members"
+}
+
+func TestIdentityKey(t *testing.T) {
+	if _, ok, err := identityKey(&versionedEntity{}); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("child with zero primary key should be new")
+	}
+
+	stored := &versionedEntity{ID: 1, Name: "a", Version: 1}
+	key, ok, err := identityKey(stored)
+	if err != nil {
+		t.Fatal(err)
+	} else if expected := "entity.versionedEntity,1"; !ok || key != expected {
+		t.Fatalf("child key, Expected=%s, Actual=%s", expected, key)
+	}
+
+	// version is not updatable
+	if changed, _ := columnsChanged(stored, &versionedEntity{ID: 1, Name: "a", Version: 2}); changed {
+		t.Fatal("child should not be changed")
+	} else if changed, _ := columnsChanged(stored, &versionedEntity{ID: 1, Name: "b", Version: 1}); !changed {
+		t.Fatal("child should be changed")
+	}
+}
+
+type chunkRow struct {
+	ID int64 `db:"id,primaryKey"`
+}
+
+func (cr *chunkRow) TableName() string {
+	return "chunk"
+}
+
+func (cr *chunkRow) SetID(id int64) error {
+	cr.ID = id
+	return nil
+}
+
+// chunkStub returns a batch to each SelectContext and records the arguments.
+type chunkStub struct {
+	DB
+
+	batches [][]int64
+	queries []string
+	args    [][]any
+}
+
+func (s *chunkStub) SelectContext(_ context.Context, dest any, query string, args ...any) error {
+	s.queries = append(s.queries, query)
+	s.args = append(s.args, args)
+
+	rows := dest.(*[]*chunkRow)
+	if len(s.batches) > 0 {
+		for _, id := range s.batches[0] {
+			*rows = append(*rows, &chunkRow{ID: id})
+		}
+		s.batches = s.batches[1:]
+	}
+	return nil
+}
+
+func TestRepositoryChunk(t *testing.T) {
+	db := &chunkStub{batches: [][]int64{{1, 2}, {3, 4}, {5}}}
+	repo := NewRepository[int64, *chunkRow](db)
+
+	var (
+		handled     []int64
+		checkpoints []ChunkCheckpoint
+	)
+	err := repo.Chunk(context.Background(), goqu.From("chunk"), 2,
+		func(rows []*chunkRow) error {
+			for _, row := range rows {
+				handled = append(handled, row.ID)
+			}
+			return nil
+		},
+		WithChunkProgress(func(_ context.Context, checkpoint ChunkCheckpoint) error {
+			checkpoints = append(checkpoints, checkpoint)
+			return nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	} else if expected := []int64{1, 2, 3, 4, 5}; !reflect.DeepEqual(handled, expected) {
+		t.Fatalf("handled rows, Expected=%v, Actual=%v", expected, handled)
+	} else if len(checkpoints) != 3 {
+		t.Fatalf("checkpoints, Expected=3, Actual=%d", len(checkpoints))
+	}
+
+	last := checkpoints[2]
+	if last.Batches != 3 || last.Rows != 5 || last.LastKey["id"] != int64(5) {
+		t.Fatalf("last checkpoint, Actual=%+v", last)
+	}
+
+	// the batches after the first one start after the last key of previous batch
+	expectedArgs := [][]any{{int64(2)}, {int64(4)}}
+	for i, expected := range expectedArgs {
+		if args := db.args[i+1]; !reflect.DeepEqual(args[:len(expected)], expected) {
+			t.Fatalf("batch %d args, Expected=%v, Actual=%v", i+2, expected, args)
+		}
+	}
+
+	// the primary key columns are qualified by the table, so the joined tables are not ambiguous
+	if expected := `SELECT * FROM "chunk" WHERE ("chunk"."id" > ?) ORDER BY "chunk"."id" ASC LIMIT ?`; db.queries[1] != expected {
+		t.Fatalf("batch 2 query, Expected=%s, Actual=%s", expected, db.queries[1])
+	}
+
+	for _, stmt := range []*goqu.SelectDataset{
+		goqu.From("chunk").Order(goqu.C("id").Desc()),
+		goqu.From("chunk").Limit(10),
+		goqu.From("chunk").Offset(10),
+	} {
+		if err := repo.Chunk(context.Background(), stmt, 2, func([]*chunkRow) error { return nil }); err == nil {
+			t.Fatal("chunk with order, limit or offset, Expected=error, Actual=nil")
+		}
+	}
+}
+
+func TestRepositoryChunkResume(t *testing.T) {
+	// larger than 2^53, it loses precision as float64
+	const lastID = int64(9007199254740993)
+
+	data, err := json.Marshal(ChunkCheckpoint{Batches: 1, Rows: 2, LastKey: map[string]any{"id": lastID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var checkpoint ChunkCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		t.Fatal(err)
+	}
+
+	db := &chunkStub{}
+	repo := NewRepository[int64, *chunkRow](db)
+	err = repo.Chunk(context.Background(), goqu.From("chunk"), 2,
+		func([]*chunkRow) error { return nil },
+		WithChunkResume(checkpoint),
+	)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(db.args) != 1 || len(db.args[0]) == 0 || db.args[0][0] != lastID {
+		t.Fatalf("resumed args, Expected=[%d ...], Actual=%v", lastID, db.args)
+	}
+}
+
+// idsConnector is a driver returning its ids to any query, and affecting a row per id by any execution.
+type idsConnector struct {
+	ids []int64
+}
+
+func (c idsConnector) Connect(context.Context) (driver.Conn, error) {
+	return idsConn(c), nil
+}
+
+func (c idsConnector) Driver() driver.Driver {
+	return c
+}
+
+func (c idsConnector) Open(string) (driver.Conn, error) {
+	return idsConn(c), nil
+}
+
+type idsConn idsConnector
+
+func (c idsConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c idsConn) Close() error {
+	return nil
+}
+
+func (c idsConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not implemented")
+}
+
+// ExecContext affects a row per id.
+func (c idsConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(len(c.ids)), nil
+}
+
+func (c idsConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return &idsRows{ids: c.ids}, nil
+}
+
+type idsRows struct {
+	ids []int64
+}
+
+func (r *idsRows) Columns() []string {
+	return []string{"id"}
+}
+
+func (r *idsRows) Close() error {
+	return nil
+}
+
+func (r *idsRows) Next(dest []driver.Value) error {
+	if len(r.ids) == 0 {
+		return io.EOF
+	}
+
+	dest[0], r.ids = r.ids[0], r.ids[1:]
+	return nil
+}
+
+func TestRepositoryForEachConcurrent(t *testing.T) {
+	ids := make([]int64, 100)
+	for i := range ids {
+		ids[i] = int64(i + 1)
+	}
+
+	db := sqlx.NewDb(sql.OpenDB(idsConnector{ids: ids}), driverPostgres)
+	defer db.Close()
+
+	repo := NewRepository[int64, *chunkRow](db)
+	stmt := goqu.From("chunk")
+
+	t.Run("all rows", func(t *testing.T) {
+		var sum int64
+		err := repo.ForEachConcurrent(context.Background(), stmt, 4, func(row *chunkRow) (bool, error) {
+			atomic.AddInt64(&sum, row.ID)
+			return true, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		} else if sum != 5050 {
+			t.Fatalf("sum of ids, Expected=5050, Actual=%d", sum)
+		}
+	})
+
+	t.Run("early stop", func(t *testing.T) {
+		var calls int64
+		err := repo.ForEachConcurrent(context.Background(), stmt, 1, func(row *chunkRow) (bool, error) {
+			atomic.AddInt64(&calls, 1)
+			return row.ID < 3, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		} else if calls != 3 {
+			t.Fatalf("iteratee calls, Expected=3, Actual=%d", calls)
+		}
+	})
+
+	t.Run("iteratee error", func(t *testing.T) {
+		errIteratee := errors.New("iteratee")
+
+		var calls int64
+		err := repo.ForEachConcurrent(context.Background(), stmt, 1, func(row *chunkRow) (bool, error) {
+			atomic.AddInt64(&calls, 1)
+			if row.ID == 5 {
+				return false, errIteratee
+			}
+			return true, nil
+		})
+		if !errors.Is(err, errIteratee) {
+			t.Fatalf("iteratee error, Expected=%v, Actual=%v", errIteratee, err)
+		} else if calls != 5 {
+			t.Fatalf("iteratee calls, Expected=5, Actual=%d", calls)
+		}
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var calls int64
+		err := repo.ForEachConcurrent(ctx, stmt, 1, func(row *chunkRow) (bool, error) {
+			atomic.AddInt64(&calls, 1)
+			if row.ID == 3 {
+				cancel()
+			}
+			return true, nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("cancelled iteration, Expected=%v, Actual=%v", context.Canceled, err)
+		} else if calls != 3 {
+			t.Fatalf("iteratee calls, Expected=3, Actual=%d", calls)
+		}
+	})
+
+	if err := repo.ForEachConcurrent(context.Background(), stmt, 0, nil); err == nil {
+		t.Fatal("zero workers, Expected=error, Actual=nil")
+	}
+}
+
+func TestRepositoryDeleteByQuery(t *testing.T) {
+	errBegin := errors.New("begin")
+
+	db := sqlx.NewDb(sql.OpenDB(errConnector{err: errBegin}), driverPostgres)
+	defer db.Close()
+
+	// the rows are queried and deleted in a transaction
+	repo := NewRepository[int64, *chunkRow](db)
+	if n, err := repo.DeleteByQuery(context.Background(), goqu.From("chunk")); !errors.Is(err, errBegin) {
+		t.Fatalf("delete by query, Expected=%v, Actual=%v", errBegin, err)
+	} else if n != 0 {
+		t.Fatalf("deleted rows, Expected=0, Actual=%d", n)
+	}
+
+	// the rows are deleted without transaction if the database can't begin one
+	for _, db := range []DB{struct{ DB }{db}, NewShardedDB([]DB{db})} {
+		repo = NewRepository[int64, *chunkRow](db)
+		if _, err := repo.DeleteByQuery(context.Background(), goqu.From("chunk")); !errors.Is(err, errBegin) {
+			t.Fatalf("delete by query on %T, Expected=%v, Actual=%v", db, errBegin, err)
+		} else if !strings.Contains(err.Error(), "query rows") {
+			t.Fatalf("delete by query on %T, Expected=query rows error, Actual=%v", db, err)
+		}
+	}
+}
+
+func TestRepositoryCreateOrIgnore(t *testing.T) {
+	db := newSQLiteDB(t, sqliteUserTable)
+	repo := NewRepository[int64, *sqliteUser](db)
+	ctx := context.Background()
+
+	if created, err := repo.CreateOrIgnore(ctx, &sqliteUser{ID: 1, Name: "foo"}); err != nil {
+		t.Fatal(err)
+	} else if !created {
+		t.Fatal("create new row, Expected=true, Actual=false")
+	}
+
+	if created, err := repo.CreateOrIgnore(ctx, &sqliteUser{ID: 1, Name: "bar"}); err != nil {
+		t.Fatal(err)
+	} else if created {
+		t.Fatal("create existing row, Expected=false, Actual=true")
+	}
+
+	if row, err := repo.Find(ctx, 1); err != nil {
+		t.Fatal(err)
+	} else if row.Name != "foo" {
+		t.Fatalf("existing row is overwritten, Expected=foo, Actual=%s", row.Name)
+	}
+}
+
+func TestRepositoryFindOrCreate(t *testing.T) {
+	db := newSQLiteDB(t, sqliteUserTable)
+	repo := NewRepository[int64, *sqliteUser](db)
+	ctx := context.Background()
+
+	init := func(name string) func(*sqliteUser) error {
+		return func(row *sqliteUser) error {
+			row.Name = name
+			return nil
+		}
+	}
+
+	t.Run("created", func(t *testing.T) {
+		row, err := repo.FindOrCreate(ctx, 1, init("foo"))
+		if err != nil {
+			t.Fatal(err)
+		} else if row.ID != 1 || row.Name != "foo" {
+			t.Fatalf("created row, Actual=%+v", row)
+		}
+	})
+
+	t.Run("already exists", func(t *testing.T) {
+		row, err := repo.FindOrCreate(ctx, 1, func(*sqliteUser) error {
+			t.Fatal("init is called for the existing row")
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		} else if row.Name != "foo" {
+			t.Fatalf("existing row, Expected=foo, Actual=%s", row.Name)
+		}
+	})
+
+	t.Run("concurrent create", func(t *testing.T) {
+		const n = 8
+
+		var (
+			wg      sync.WaitGroup
+			results = make([]*sqliteUser, n)
+			errs    = make([]error, n)
+		)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i], errs[i] = repo.FindOrCreate(ctx, 2, init(fmt.Sprintf("user%d", i)))
+			}(i)
+		}
+		wg.Wait()
+
+		for i, err := range errs {
+			if err != nil {
+				t.Fatalf("goroutine %d, %v", i, err)
+			}
+		}
+
+		// every caller gets the same row, whoever created it
+		for i, row := range results {
+			if row.Name != results[0].Name {
+				t.Fatalf("goroutine %d, Expected=%s, Actual=%s", i, results[0].Name, row.Name)
+			}
+		}
+
+		var count int
+		if err := db.Get(&count, "SELECT COUNT(*) FROM users WHERE id = 2"); err != nil {
+			t.Fatal(err)
+		} else if count != 1 {
+			t.Fatalf("created rows, Expected=1, Actual=%d", count)
+		}
+	})
+}
+
+func TestRepositoryCountExists(t *testing.T) {
+	db := newSQLiteDB(t, sqliteUserTable,
+		`INSERT INTO users (id, tenant_id, name) VALUES (1, 1, 'foo'), (2, 1, 'bar'), (3, 2, 'foo')`,
+	)
+	ctx := context.Background()
+
+	repo := NewRepository[int64, *sqliteUser](db)
+	scoped := NewRepository[int64, *sqliteUser](db, WithDefaultScopes(func(stmt *goqu.SelectDataset) *goqu.SelectDataset {
+		return stmt.Where(goqu.C("name").Eq("foo"))
+	}))
+	tenant := NewRepository[int64, *sqliteTenantUser](db)
+	tenantCtx := WithTenant(ctx, int64(1))
+
+	tests := []struct {
+		name   string
+		count  func() (int, error)
+		exists func() (bool, error)
+		byID   func() (bool, error)
+		total  int
+		found  bool
+	}{
+		{
+			name:   "match",
+			count:  func() (int, error) { return repo.Count(ctx, repo.Dataset().Where(goqu.C("name").Eq("foo"))) },
+			exists: func() (bool, error) { return repo.Exists(ctx, repo.Dataset().Where(goqu.C("name").Eq("foo"))) },
+			byID:   func() (bool, error) { return repo.ExistsID(ctx, 2) },
+			total:  2,
+			found:  true,
+		},
+		{
+			name:   "no match",
+			count:  func() (int, error) { return repo.Count(ctx, repo.Dataset().Where(goqu.C("name").Eq("baz"))) },
+			exists: func() (bool, error) { return repo.Exists(ctx, repo.Dataset().Where(goqu.C("name").Eq("baz"))) },
+			byID:   func() (bool, error) { return repo.ExistsID(ctx, 4) },
+			total:  0,
+			found:  false,
+		},
+		{
+			name:   "scopes applied",
+			count:  func() (int, error) { return scoped.Count(ctx, scoped.Dataset().Where(goqu.C("id").Gt(1))) },
+			exists: func() (bool, error) { return scoped.Exists(ctx, scoped.Dataset().Where(goqu.C("id").Eq(2))) },
+			byID:   func() (bool, error) { return scoped.ExistsID(ctx, 2) },
+			total:  1,
+			found:  false,
+		},
+		{
+			name:   "tenant applied",
+			count:  func() (int, error) { return tenant.Count(tenantCtx, tenant.Dataset().Where(goqu.C("name").Eq("foo"))) },
+			exists: func() (bool, error) { return tenant.Exists(tenantCtx, tenant.Dataset().Where(goqu.C("id").Eq(3))) },
+			byID:   func() (bool, error) { return tenant.ExistsID(tenantCtx, 3) },
+			total:  1,
+			found:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if total, err := tt.count(); err != nil {
+				t.Fatal(err)
+			} else if total != tt.total {
+				t.Fatalf("Count, Expected=%d, Actual=%d", tt.total, total)
+			}
+
+			if found, err := tt.exists(); err != nil {
+				t.Fatal(err)
+			} else if found != tt.found {
+				t.Fatalf("Exists, Expected=%v, Actual=%v", tt.found, found)
+			}
+
+			if found, err := tt.byID(); err != nil {
+				t.Fatal(err)
+			} else if found != tt.found {
+				t.Fatalf("ExistsID, Expected=%v, Actual=%v", tt.found, found)
+			}
+		})
+	}
+
+	if _, err := tenant.Count(ctx, tenant.Dataset()); !errors.Is(err, ErrTenantRequired) {
+		t.Fatalf("Count without tenant, Expected=ErrTenantRequired, Actual=%v", err)
+	}
+}
+
+func newOrderRepository(t *testing.T, hooks DBHooks, opts ...DomainObjectRepositoryOption) (*sqlx.DB, *DomainObjectRepository[int64, *sqliteOrder, *sqliteOrderRow]) {
+	db := newSQLiteDB(t, sqliteOrderTables,
+		`INSERT INTO orders (id, note) VALUES (1, 'a'), (2, 'b')`,
+		`INSERT INTO order_lines (id, order_id, sku, qty) VALUES (1, 1, 'x', 1), (2, 1, 'y', 1), (3, 2, 'x', 1)`,
+	)
+	return db, NewDomainObjectRepository[int64, *sqliteOrder](NewRepository[int64, *sqliteOrderRow](WrapDB(db, hooks)), opts...)
+}
+
+func TestDomainObjectRepositoryForEachAggregate(t *testing.T) {
+	db, repo := newOrderRepository(t, DBHooks{})
+
+	// the children are not queried while the cursor holds the only connection
+	db.SetMaxOpenConns(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lines := map[int64]int{}
+	err := repo.ForEach(ctx, goqu.From("orders"), func(do *sqliteOrder) (bool, error) {
+		lines[do.ID] = len(do.Lines)
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if expected := map[int64]int{1: 2, 2: 1}; !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("ForEach lines, Expected=%v, Actual=%v", expected, lines)
+	}
+
+	var mu sync.Mutex
+	lines = map[int64]int{}
+	err = repo.ForEachConcurrent(ctx, goqu.From("orders"), 2, func(do *sqliteOrder) (bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		lines[do.ID] = len(do.Lines)
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if expected := map[int64]int{1: 2, 2: 1}; !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("ForEachConcurrent lines, Expected=%v, Actual=%v", expected, lines)
+	}
+}
+
+func TestDomainObjectRepositoryUpdateByQueryAggregate(t *testing.T) {
+	var childReads int
+	db, repo := newOrderRepository(t, DBHooks{
+		After: func(_ context.Context, call *DBCall) {
+			if strings.HasPrefix(call.Query, "SELECT") && strings.Contains(call.Query, "order_lines") {
+				childReads++
+			}
+		},
+	})
+	ctx := context.Background()
+
+	err := repo.UpdateByQuery(ctx, goqu.From("orders").Where(goqu.C("id").Eq(1)), func(do *sqliteOrder) (bool, error) {
+		do.Note = "changed"
+		do.Lines = []sqliteOrderLine{
+			{ID: 1, SKU: "x", Qty: 2},
+			{SKU: "z", Qty: 1},
+		}
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the children are compared with the loaded ones, not read again before writing
+	if childReads != 1 {
+		t.Fatalf("children reads, Expected=1, Actual=%d", childReads)
+	}
+
+	var lines []string
+	if err := db.Select(&lines, "SELECT sku || qty FROM order_lines WHERE order_id = 1 ORDER BY sku"); err != nil {
+		t.Fatal(err)
+	} else if expected := []string{"x2", "z1"}; !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("order lines, Expected=%v, Actual=%v", expected, lines)
+	}
+
+	// each aggregate is written in its own transaction, the root of failed one is rolled back
+	err = repo.UpdateByQuery(ctx, goqu.From("orders").Order(goqu.C("id").Asc()), func(do *sqliteOrder) (bool, error) {
+		do.Note = "updated"
+		if do.ID == 2 {
+			do.Lines[0].Qty = 0
+		}
+		return true, nil
+	})
+	if err == nil {
+		t.Fatal("violate check constraint, Expected=error, Actual=nil")
+	}
+
+	var notes []string
+	if err := db.Select(&notes, "SELECT note FROM orders ORDER BY id"); err != nil {
+		t.Fatal(err)
+	} else if expected := []string{"updated", "b"}; !reflect.DeepEqual(notes, expected) {
+		t.Fatalf("order notes, Expected=%v, Actual=%v", expected, notes)
+	}
+}
+
+func TestDomainObjectRepositoryUpdateByQueryEvents(t *testing.T) {
+	bus := NewDomainEventBus()
+	db, repo := newOrderRepository(t, DBHooks{}, WithDomainEventBus(bus))
+	ctx := context.Background()
+
+	notes := func() []string {
+		var notes []string
+		if err := db.Select(&notes, "SELECT note FROM orders ORDER BY id"); err != nil {
+			t.Fatal(err)
+		}
+		return notes
+	}
+
+	// the notes of all orders when each event is handled after commit
+	var handled [][]string
+	bus.Subscribe(func(context.Context, any) error {
+		handled = append(handled, notes())
+		return nil
+	})
+
+	ship := func(note string) func(do *sqliteOrder) (bool, error) {
+		return func(do *sqliteOrder) (bool, error) {
+			do.Note = note
+			do.Raise(orderShipped{ID: do.ID})
+			return true, nil
+		}
+	}
+
+	if err := repo.UpdateByQuery(ctx, goqu.From("orders").Order(goqu.C("id").Asc()), ship("shipped")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the events are dispatched once after all the rows are written
+	expected := [][]string{{"shipped", "shipped"}, {"shipped", "shipped"}}
+	if !reflect.DeepEqual(handled, expected) {
+		t.Fatalf("notes when handled, Expected=%v, Actual=%v", expected, handled)
+	}
+
+	bus.SubscribeInTransaction(HandleEventInTransaction(func(_ context.Context, _ DB, event orderShipped) error {
+		if event.ID == 2 {
+			return errors.New("failed")
+		}
+		return nil
+	}))
+
+	// the error of handler in transaction rolls back all the rows, and no event is dispatched after commit
+	if err := repo.UpdateByQuery(ctx, goqu.From("orders").Order(goqu.C("id").Asc()), ship("rolled back")); err == nil {
+		t.Fatal("handler in transaction, Expected=error, Actual=nil")
+	} else if expected := []string{"shipped", "shipped"}; !reflect.DeepEqual(notes(), expected) {
+		t.Fatalf("order notes, Expected=%v, Actual=%v", expected, notes())
+	} else if len(handled) != 2 {
+		t.Fatalf("handled after commit, Expected=2, Actual=%d", len(handled))
+	}
+}
+
+func TestRepositoryWhere(t *testing.T) {
+	db := newSQLiteDB(t, sqliteUserTable,
+		`INSERT INTO users (id, tenant_id, name) VALUES (1, 1, 'foo'), (2, 1, 'bar'), (3, 2, 'foo')`,
+	)
+	ctx := context.Background()
+
+	repo := NewRepository[int64, *sqliteUser](db)
+	scoped := NewRepository[int64, *sqliteUser](db, WithDefaultScopes(func(stmt *goqu.SelectDataset) *goqu.SelectDataset {
+		return stmt.Where(goqu.C("tenant_id").Eq(1))
+	}))
+
+	ids := func(rows []*sqliteUser) []int64 {
+		result := []int64{}
+		for _, row := range rows {
+			result = append(result, row.ID)
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+		return result
+	}
+
+	// the expressions are ANDed
+	if rows, err := repo.Where(ctx, goqu.C("name").Eq("foo"), goqu.C("tenant_id").Eq(2)); err != nil {
+		t.Fatal(err)
+	} else if expected := []int64{3}; !reflect.DeepEqual(ids(rows), expected) {
+		t.Fatalf("Where, Expected=%v, Actual=%v", expected, ids(rows))
+	}
+
+	if rows, err := scoped.Where(ctx, goqu.C("name").Eq("foo")); err != nil {
+		t.Fatal(err)
+	} else if expected := []int64{1}; !reflect.DeepEqual(ids(rows), expected) {
+		t.Fatalf("scoped Where, Expected=%v, Actual=%v", expected, ids(rows))
+	}
+
+	if row, err := repo.GetWhere(ctx, goqu.C("name").Eq("foo"), goqu.C("tenant_id").Eq(2)); err != nil {
+		t.Fatal(err)
+	} else if row.ID != 3 {
+		t.Fatalf("GetWhere, Expected=3, Actual=%d", row.ID)
+	}
+
+	if _, err := repo.GetWhere(ctx, goqu.C("name").Eq("baz")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetWhere not found, Expected=ErrNotFound, Actual=%v", err)
+	} else if _, err := scoped.GetWhere(ctx, goqu.C("id").Eq(3)); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("scoped GetWhere, Expected=ErrNotFound, Actual=%v", err)
+	}
+}
+
+func TestRepositoryFindMany(t *testing.T) {
+	db := newSQLiteDB(t, sqliteUserTable,
+		`CREATE TABLE members (org_id INTEGER NOT NULL, user_id INTEGER NOT NULL, role TEXT NOT NULL, PRIMARY KEY (org_id, user_id))`,
+		`INSERT INTO users (id, name) VALUES (1, 'foo'), (2, 'bar'), (3, 'baz')`,
+		`INSERT INTO members (org_id, user_id, role) VALUES (1, 1, 'owner'), (1, 2, 'member'), (2, 1, 'member')`,
+	)
+	ctx := context.Background()
+
+	users := NewRepository[int64, *sqliteUser](db)
+	members := NewRepository[memberKey, *memberEntity](db)
+
+	t.Run("single key", func(t *testing.T) {
+		rows, err := users.FindMany(ctx, 1, 3, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the missing ids are skipped
+		names := []string{}
+		for _, row := range rows {
+			names = append(names, row.Name)
+		}
+		sort.Strings(names)
+		if expected := []string{"baz", "foo"}; !reflect.DeepEqual(names, expected) {
+			t.Fatalf("FindMany, Expected=%v, Actual=%v", expected, names)
+		}
+	})
+
+	t.Run("composite key", func(t *testing.T) {
+		rows, err := members.FindMany(ctx, memberKey{OrgID: 1, UserID: 2}, memberKey{OrgID: 2, UserID: 1}, memberKey{OrgID: 2, UserID: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys := []memberKey{}
+		for _, row := range rows {
+			keys = append(keys, memberKey{OrgID: row.OrgID, UserID: row.UserID})
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].OrgID < keys[j].OrgID })
+		if expected := []memberKey{{OrgID: 1, UserID: 2}, {OrgID: 2, UserID: 1}}; !reflect.DeepEqual(keys, expected) {
+			t.Fatalf("FindMany, Expected=%v, Actual=%v", expected, keys)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		if rows, err := users.FindMany(ctx); err != nil {
+			t.Fatal(err)
+		} else if len(rows) != 0 {
+			t.Fatalf("FindMany without ids, Expected=0, Actual=%d", len(rows))
+		}
+	})
+}
+
+func TestRepositoryChunkTransaction(t *testing.T) {
+	db := newSQLiteDB(t, sqliteUserTable,
+		`INSERT INTO users (id, name) VALUES (1, 'a'), (2, 'b'), (3, 'c')`,
+	)
+	repo := NewRepository[int64, *sqliteUser](db)
+	ctx := context.Background()
+
+	// each batch is written in its own transaction, the failed batch is rolled back
+	var tx DB
+	err := repo.Chunk(ctx, repo.Dataset(), 2, func(rows []*sqliteUser) error {
+		if _, ok := tx.(*sqlx.Tx); !ok {
+			return fmt.Errorf("batch db, Expected=*sqlx.Tx, Actual=%T", tx)
+		}
+
+		for _, row := range rows {
+			row.Name += "!"
+			if err := repo.WithDB(tx).Update(ctx, row); err != nil {
+				return err
+			}
+		}
+
+		if rows[0].ID == 3 {
+			return errors.New("failed batch")
+		}
+		return nil
+	}, WithChunkTransaction[*sqlx.Tx](nil, &tx))
+	if err == nil {
+		t.Fatal("failed batch, Expected=error, Actual=nil")
+	}
+
+	var names []string
+	if err := db.Select(&names, "SELECT name FROM users ORDER BY id"); err != nil {
+		t.Fatal(err)
+	} else if expected := []string{"a!", "b!", "c"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("names, Expected=%v, Actual=%v", expected, names)
+	}
+}
@@ -0,0 +1,191 @@
+package entity
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+)
+
+var (
+	tableResolver   TableResolver = QualifyBySchema
+	tableResolverMu sync.RWMutex
+)
+
+// TableResolver returns the table of the statements of entity with ctx, from the table name of entity,
+// so the same entity type can target different tables, such as "tenant_a.users" and "tenant_b.users".
+type TableResolver func(ctx context.Context, table string) string
+
+// SetTableResolver sets the process-wide table resolver, nil restores the default QualifyBySchema.
+//
+// The resolved table is used by the CRUD functions, the prepared statements, Preload and the Repository reads,
+// whose FROM tables are resolved and joined tables must be resolved already. The statements are cached per entity type
+// with the table substituted when used, so the cache doesn't grow with tenants, and the cache keys of Cacheable entities are prefixed by the schema of resolved table, or the table if not qualified.
+//
+// It should be called during initialization, before any entity operation.
+func SetTableResolver(resolver TableResolver) {
+	tableResolverMu.Lock()
+	defer tableResolverMu.Unlock()
+
+	if resolver == nil {
+		resolver = QualifyBySchema
+	}
+	tableResolver = resolver
+}
+
+// ResolveTable returns the table resolved by the table resolver with ctx.
+func ResolveTable(ctx context.Context, table string) string {
+	tableResolverMu.RLock()
+	resolver := tableResolver
+	tableResolverMu.RUnlock()
+
+	return resolver(ctx, table)
+}
+
+type schemaKey struct{}
+
+// WithSchema returns a copy of ctx with the schema qualifying the tables by QualifyBySchema.
+func WithSchema(ctx context.Context, schema string) context.Context {
+	return context.WithValue(ctx, schemaKey{}, schema)
+}
+
+// SchemaFromContext returns the schema of ctx.
+func SchemaFromContext(ctx context.Context) (string, bool) {
+	schema, ok := ctx.Value(schemaKey{}).(string)
+	return schema, ok && schema != ""
+}
+
+// QualifyBySchema is the default TableResolver, it qualifies the table by the schema of ctx set by WithSchema,
+// the tables already qualified are not changed.
+func QualifyBySchema(ctx context.Context, table string) string {
+	if schema, ok := SchemaFromContext(ctx); ok && !strings.Contains(table, ".") {
+		return schema + "." + table
+	}
+	return table
+}
+
+// resolveFrom resolves the tables in the FROM of stmt with ctx, and returns the name referencing the table of entity,
+// which is the alias if it's aliased.
+//
+// The table of entity is located by the shard key of ctx for ShardedTable, and resolved by the table resolver,
+// the other tables are resolved by the table resolver, the aliases are kept.
+// If the table name is changed, not only the schema, the resolved table is aliased as the original one,
+// so the columns qualified by the table, such as the ones selected by Repository.Dataset, are still valid.
+//
+// The joined tables can't be replaced in stmt, an error is set to stmt if any of them is resolved to another table,
+// they should be resolved by ResolveTable when the statement is built. The subqueries and literals are not changed.
+func resolveFrom(ctx context.Context, ent Entity, md *Metadata, stmt *goqu.SelectDataset) (*goqu.SelectDataset, string) {
+	ref := TableIdentifier(md.TableName).GetTable()
+
+	table, err := shardTable(ctx, ent)
+	if err != nil {
+		return stmt.SetError(err), ref
+	}
+
+	entityTable := ResolveTable(ctx, table)
+	resolve := func(name string) string {
+		if name == md.TableName {
+			return entityTable
+		}
+		return ResolveTable(ctx, name)
+	}
+
+	for _, join := range stmt.GetClauses().Joins() {
+		if name, _, ok := tableOf(join.Table()); ok {
+			if resolved := resolve(name); resolved != name {
+				return stmt.SetError(fmt.Errorf("joined table %q should be resolved as %q by ResolveTable", name, resolved)), ref
+			}
+		}
+	}
+
+	if stmt.GetClauses().From() == nil {
+		return stmt, ref
+	}
+
+	replaced := false
+	from := stmt.GetClauses().From().Columns()
+	tables := make([]any, 0, len(from))
+	for _, v := range from {
+		name, alias, ok := tableOf(v)
+		if !ok {
+			tables = append(tables, v)
+			continue
+		}
+
+		if name == md.TableName && alias != "" {
+			ref = alias
+		}
+
+		resolved := resolve(name)
+		if resolved == name {
+			tables = append(tables, v)
+			continue
+		}
+
+		if alias == "" && TableIdentifier(resolved).GetTable() != TableIdentifier(name).GetTable() {
+			alias = TableIdentifier(name).GetTable()
+		}
+		if alias != "" {
+			v = TableIdentifier(resolved).As(alias)
+		} else {
+			v = TableIdentifier(resolved)
+		}
+		tables = append(tables, v)
+		replaced = true
+	}
+
+	if !replaced {
+		return stmt, ref
+	}
+	return stmt.From(tables...), ref
+}
+
+// tableOf returns the table name and alias of the table identifier in FROM or JOIN, false for the other expressions.
+func tableOf(v any) (string, string, bool) {
+	switch e := v.(type) {
+	case exp.IdentifierExpression:
+		return fromTableName(e), "", true
+	case exp.AliasedExpression:
+		if id, ok := e.Aliased().(exp.IdentifierExpression); ok {
+			return fromTableName(id), fromTableName(e.GetAs()), true
+		}
+	}
+	return "", "", false
+}
+
+// fromTableName returns the table name of identifier in FROM, qualified by schema if any,
+// goqu.From("users") is parsed as the column "users", while goqu.T("users") is the table "users".
+func fromTableName(id exp.IdentifierExpression) string {
+	parts := []string{id.GetSchema(), id.GetTable()}
+	if col, ok := id.GetCol().(string); ok {
+		parts = append(parts, col)
+	}
+
+	names := parts[:0]
+	for _, v := range parts {
+		if v != "" {
+			names = append(names, v)
+		}
+	}
+	return strings.Join(names, ".")
+}
+
+// cacheNamespace returns the prefix of cache key of entity with ctx, empty if the table is not resolved to another.
+func cacheNamespace(ctx context.Context, ent Cacheable) string {
+	v, ok := ent.(Entity)
+	if !ok {
+		return ""
+	}
+
+	table := v.TableName()
+	resolved := ResolveTable(ctx, table)
+	if resolved == table {
+		return ""
+	} else if i := strings.LastIndex(resolved, "."); i > 0 {
+		return resolved[:i]
+	}
+	return resolved
+}
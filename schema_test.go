@@ -0,0 +1,182 @@
+package entity
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/jmoiron/sqlx"
+)
+
+type schemaEntity struct {
+	ID   int64  `db:"id,primaryKey"`
+	Name string `db:"name"`
+}
+
+func (se *schemaEntity) TableName() string {
+	return "users"
+}
+
+func (se *schemaEntity) SetID(id int64) error {
+	se.ID = id
+	return nil
+}
+
+func (se *schemaEntity) CacheOption() CacheOption {
+	return CacheOption{Cacher: &memoryCacher{}, Key: "user:1"}
+}
+
+type memoryCacher struct {
+	Cacher
+}
+
+func TestQualifyBySchema(t *testing.T) {
+	ctx := WithSchema(context.Background(), "tenant_a")
+
+	cases := map[string]string{
+		"users":          "tenant_a.users",
+		"public.users":   "public.users",
+		"tenant_b.users": "tenant_b.users",
+	}
+	for table, expected := range cases {
+		if actual := QualifyBySchema(ctx, table); actual != expected {
+			t.Fatalf("QualifyBySchema(%q), Expected=%s, Actual=%s", table, expected, actual)
+		}
+	}
+
+	if actual := QualifyBySchema(context.Background(), "users"); actual != "users" {
+		t.Fatalf("QualifyBySchema without schema, Expected=users, Actual=%s", actual)
+	}
+}
+
+func TestSchemaStatement(t *testing.T) {
+	for _, schema := range []string{"tenant_a", "tenant_b"} {
+		ctx := WithSchema(context.Background(), schema)
+
+		md, err := getTableMetadata(ctx, &schemaEntity{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stmt := getStatement(commandDelete, md, driverPostgres)
+		if expected := `DELETE FROM "` + schema + `"."users" WHERE "id" = :id`; stmt != expected {
+			t.Fatalf("statement of %s, Expected=%s, Actual=%s", schema, expected, stmt)
+		}
+
+		opt, err := getCacheOption(ctx, &schemaEntity{})
+		if err != nil {
+			t.Fatal(err)
+		} else if expected := schema + ":user:1"; opt.Key != expected {
+			t.Fatalf("cache key of %s, Expected=%s, Actual=%s", schema, expected, opt.Key)
+		}
+	}
+
+	if opt, err := getCacheOption(context.Background(), &schemaEntity{}); err != nil {
+		t.Fatal(err)
+	} else if opt.Key != "user:1" {
+		t.Fatalf("cache key without schema, Expected=user:1, Actual=%s", opt.Key)
+	}
+}
+
+func TestResolveFrom(t *testing.T) {
+	repo := NewRepository[int64, *schemaEntity](sqlx.NewDb(nil, driverPostgres))
+	ctx := WithSchema(context.Background(), "tenant_a")
+
+	cases := []struct {
+		stmt     *goqu.SelectDataset
+		expected string
+	}{
+		{
+			stmt:     repo.Dataset().Where(goqu.C("id").Eq(1)),
+			expected: `SELECT "users"."id", "users"."name" FROM "tenant_a"."users" WHERE ("id" = 1)`,
+		},
+		{
+			stmt:     goqu.From("users", "orders"),
+			expected: `SELECT * FROM "tenant_a"."users", "tenant_a"."orders"`,
+		},
+		{
+			stmt:     goqu.From(goqu.T("users").As("u")),
+			expected: `SELECT * FROM "tenant_a"."users" AS "u"`,
+		},
+		{
+			stmt: goqu.From("users").Join(TableIdentifier(ResolveTable(ctx, "orders")),
+				goqu.On(goqu.I("orders.user_id").Eq(goqu.I("users.id")))),
+			expected: `SELECT * FROM "tenant_a"."users" INNER JOIN "tenant_a"."orders" ON ("orders"."user_id" = "users"."id")`,
+		},
+	}
+
+	for _, c := range cases {
+		query, _, err := repo.applyScopes(ctx, c.stmt).ToSQL()
+		if err != nil {
+			t.Fatal(err)
+		} else if query != c.expected {
+			t.Fatalf("resolved query, Expected=%s, Actual=%s", c.expected, query)
+		}
+	}
+
+	// the joined tables can't be replaced
+	for _, table := range []exp.Expression{goqu.T("orders"), goqu.T("orders").As("o"), goqu.T("users").As("u2")} {
+		stmt := goqu.From("users").Join(table, goqu.On(goqu.L("true")))
+		if _, _, err := repo.applyScopes(ctx, stmt).ToSQL(); err == nil {
+			t.Fatalf("unresolved joined table %v, Expected=error, Actual=nil", table)
+		}
+	}
+}
+
+func TestSetTableResolver(t *testing.T) {
+	SetTableResolver(func(ctx context.Context, table string) string {
+		return table + "_archive"
+	})
+	defer SetTableResolver(nil)
+
+	md, err := getTableMetadata(context.Background(), &schemaEntity{})
+	if err != nil {
+		t.Fatal(err)
+	} else if md.TableName != "users_archive" {
+		t.Fatalf("resolved table, Expected=users_archive, Actual=%s", md.TableName)
+	}
+
+	if opt, err := getCacheOption(context.Background(), &schemaEntity{}); err != nil {
+		t.Fatal(err)
+	} else if expected := "users_archive:user:1"; opt.Key != expected {
+		t.Fatalf("cache key, Expected=%s, Actual=%s", expected, opt.Key)
+	}
+}
+
+func TestSchemaStatementCache(t *testing.T) {
+	countStatements := func() int {
+		n := 0
+		statements.Range(func(_, _ any) bool {
+			n++
+			return true
+		})
+		return n
+	}
+
+	md, err := getTableMetadata(context.Background(), &schemaEntity{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	getStatement(commandDelete, md, driverPostgres)
+	before := countStatements()
+
+	// the statements of every schema share the cache entry of entity type
+	for i := 0; i < 100; i++ {
+		schema := fmt.Sprintf("tenant_%d", i)
+		md, err := getTableMetadata(WithSchema(context.Background(), schema), &schemaEntity{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stmt := getStatement(commandDelete, md, driverPostgres)
+		if expected := `DELETE FROM "` + schema + `"."users" WHERE "id" = :id`; stmt != expected {
+			t.Fatalf("statement of %s, Expected=%s, Actual=%s", schema, expected, stmt)
+		}
+	}
+
+	if after := countStatements(); after != before {
+		t.Fatalf("cached statements, Expected=%d, Actual=%d", before, after)
+	}
+}
@@ -0,0 +1,473 @@
+package entity
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	// ErrShardKeyRequired is returned when a statement can't be routed by ShardedDB without shard key.
+	ErrShardKeyRequired = errors.New("shard key required")
+
+	// interface assert
+	_ DB              = (*ShardedDB)(nil)
+	_ TxInitiator[Tx] = (*ShardedDB)(nil)
+)
+
+// Sharded is implemented by the entities stored across the shards of ShardedDB.
+//
+// The table-suffix sharding is supported by the TableName method returning the table of entity,
+// such as "orders_07" computed from the user id of entity, the statements are cached per entity type.
+// Such entities should implement ShardedTable for the Repository reads.
+type Sharded interface {
+	Entity
+
+	// ShardKey returns the key locating the shard of entity, such as the user id.
+	// nil means unknown, then the shard key of context is used, zero values such as user id 0 are valid keys.
+	ShardKey() any
+}
+
+// ShardedTable is implemented by the Sharded entities stored in table-suffix shards,
+// so the table can be located by the shard key of context when the entity doesn't carry it,
+// such as the entity created by Repository.Find and the statements of Repository reads.
+type ShardedTable interface {
+	Sharded
+
+	// ShardTable returns the table of the shard key, such as "orders_07" for the user id 7.
+	ShardTable(key any) string
+}
+
+type shardKeyKey struct{}
+
+// WithShardKey returns a copy of ctx with the shard key routing the statements executed by ShardedDB,
+// it's required by the Repository methods taking no entity, such as Find and Get.
+func WithShardKey(ctx context.Context, key any) context.Context {
+	return context.WithValue(ctx, shardKeyKey{}, key)
+}
+
+// ShardKeyFromContext returns the shard key of ctx.
+func ShardKeyFromContext(ctx context.Context) (any, bool) {
+	key := ctx.Value(shardKeyKey{})
+	return key, key != nil
+}
+
+// withEntityShardKey sets the shard key of entity into ctx, if any.
+func withEntityShardKey(ctx context.Context, ent Entity) context.Context {
+	if v, ok := ent.(Sharded); ok {
+		if key := v.ShardKey(); key != nil {
+			return WithShardKey(ctx, key)
+		}
+	}
+	return ctx
+}
+
+// shardTable returns the table of entity, located by the shard key of ctx if the entity is ShardedTable without shard key.
+func shardTable(ctx context.Context, ent Entity) (string, error) {
+	v, ok := ent.(ShardedTable)
+	if !ok {
+		return ent.TableName(), nil
+	} else if v.ShardKey() != nil {
+		return ent.TableName(), nil
+	}
+
+	key, ok := ShardKeyFromContext(ctx)
+	if !ok {
+		return "", fmt.Errorf("table of %T, %w", ent, ErrShardKeyRequired)
+	}
+	return v.ShardTable(key), nil
+}
+
+// ShardFunc returns the index of the shard for key, in the range [0, shards).
+type ShardFunc func(key any, shards int) (int, error)
+
+// ModuloShard is the default ShardFunc, integer keys are located by modulo,
+// the others are located by the FNV-1a hash of their string form.
+func ModuloShard(key any, shards int) (int, error) {
+	if shards <= 0 {
+		return 0, fmt.Errorf("invalid shards %d", shards)
+	}
+
+	v := reflect.ValueOf(key)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := v.Int() % int64(shards)
+		if n < 0 {
+			n = -n
+		}
+		return int(n), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int(v.Uint() % uint64(shards)), nil
+	case reflect.Invalid:
+		return 0, ErrShardKeyRequired
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(fmt.Sprint(key)))
+	return int(h.Sum32() % uint32(shards)), nil
+}
+
+// ShardedDBOption is an option for NewShardedDB.
+type ShardedDBOption func(*ShardedDB)
+
+// WithShardFunc sets the function locating the shards, default ModuloShard.
+func WithShardFunc(fn ShardFunc) ShardedDBOption {
+	return func(s *ShardedDB) {
+		s.shardFunc = fn
+	}
+}
+
+// ShardedDB is a DB routing the statements to the shard located by the shard key of context.
+//
+// Load, Insert, Update, Delete and the other entity operations use the shard key of Sharded entity,
+// or the one of context set by WithShardKey, which is also used by Repository.
+// Without shard key, SelectContext, used by Repository.Query and GetRecords, queries all the shards
+// concurrently and gathers the results in the order of shards, the statements with order, limit or offset
+// fail with ErrShardKeyRequired like the other statements.
+//
+// The shards should use the same driver, a shard can be ClusterDB.
+type ShardedDB struct {
+	shards    []DB
+	shardFunc ShardFunc
+}
+
+// NewShardedDB creates a ShardedDB, the shards should implement TxInitiator to begin transactions.
+// It panics if no shard is given.
+func NewShardedDB(shards []DB, opts ...ShardedDBOption) *ShardedDB {
+	if len(shards) == 0 {
+		panic(errors.New("sharded db requires at least one shard"))
+	}
+
+	s := &ShardedDB{
+		shards:    shards,
+		shardFunc: ModuloShard,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Shards returns all the shards.
+func (s *ShardedDB) Shards() []DB {
+	return s.shards
+}
+
+// Shard returns the shard located by key.
+func (s *ShardedDB) Shard(key any) (DB, error) {
+	i, err := s.shardFunc(key, len(s.shards))
+	if err != nil {
+		return nil, fmt.Errorf("locate shard of %v, %w", key, err)
+	} else if i < 0 || i >= len(s.shards) {
+		return nil, fmt.Errorf("shard %d of %v out of range", i, key)
+	}
+	return s.shards[i], nil
+}
+
+// shard returns the shard located by the shard key of ctx.
+func (s *ShardedDB) shard(ctx context.Context) (DB, error) {
+	key, ok := ShardKeyFromContext(ctx)
+	if !ok {
+		return nil, ErrShardKeyRequired
+	}
+	return s.Shard(key)
+}
+
+// Query implements DB.
+func (s *ShardedDB) Query(query string, args ...any) (*sql.Rows, error) {
+	return s.QueryContext(context.Background(), query, args...)
+}
+
+// QueryContext implements DB.
+func (s *ShardedDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	db, err := s.shard(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.QueryContext(ctx, query, args...)
+}
+
+// Queryx implements DB.
+func (s *ShardedDB) Queryx(query string, args ...any) (*sqlx.Rows, error) {
+	return s.QueryxContext(context.Background(), query, args...)
+}
+
+// QueryxContext implements DB.
+func (s *ShardedDB) QueryxContext(ctx context.Context, query string, args ...any) (*sqlx.Rows, error) {
+	db, err := s.shard(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.QueryxContext(ctx, query, args...)
+}
+
+// QueryRowx implements DB.
+func (s *ShardedDB) QueryRowx(query string, args ...any) *sqlx.Row {
+	return s.QueryRowxContext(context.Background(), query, args...)
+}
+
+// QueryRowxContext implements DB.
+func (s *ShardedDB) QueryRowxContext(ctx context.Context, query string, args ...any) *sqlx.Row {
+	db, err := s.shard(ctx)
+	if err != nil {
+		return errRow(err)
+	}
+	return db.QueryRowxContext(ctx, query, args...)
+}
+
+// Get implements DB.
+func (s *ShardedDB) Get(dest any, query string, args ...any) error {
+	return s.GetContext(context.Background(), dest, query, args...)
+}
+
+// GetContext implements DB.
+func (s *ShardedDB) GetContext(ctx context.Context, dest any, query string, args ...any) error {
+	db, err := s.shard(ctx)
+	if err != nil {
+		return err
+	}
+	return db.GetContext(ctx, dest, query, args...)
+}
+
+// Select implements DB.
+func (s *ShardedDB) Select(dest any, query string, args ...any) error {
+	return s.SelectContext(context.Background(), dest, query, args...)
+}
+
+// SelectContext implements DB, it queries all the shards if there is no shard key in ctx.
+// The results of shards can't be ordered or limited together, so such query fails
+// if it has ORDER BY, LIMIT, OFFSET or FETCH out of subqueries.
+func (s *ShardedDB) SelectContext(ctx context.Context, dest any, query string, args ...any) error {
+	if _, ok := ShardKeyFromContext(ctx); ok {
+		db, err := s.shard(ctx)
+		if err != nil {
+			return err
+		}
+		return db.SelectContext(ctx, dest, query, args...)
+	}
+
+	if clause, ok := orderedOrLimited(query); ok {
+		return fmt.Errorf("query all shards with %s, %w", clause, ErrShardKeyRequired)
+	}
+
+	value := reflect.ValueOf(dest)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("dest must be a pointer to slice, got %T", dest)
+	}
+	slice := value.Elem()
+
+	parts := make([]reflect.Value, len(s.shards))
+	errs := make([]error, len(s.shards))
+
+	var wg sync.WaitGroup
+	for i, db := range s.shards {
+		wg.Add(1)
+		go func(i int, db DB) {
+			defer wg.Done()
+
+			part := reflect.New(slice.Type())
+			if err := db.SelectContext(ctx, part.Interface(), query, args...); err != nil {
+				errs[i] = fmt.Errorf("shard %d, %w", i, err)
+				return
+			}
+			parts[i] = part.Elem()
+		}(i, db)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, part := range parts {
+		slice = reflect.AppendSlice(slice, part)
+	}
+	value.Elem().Set(slice)
+	return nil
+}
+
+// orderedOrLimited returns the ORDER BY, LIMIT, OFFSET or FETCH clause of query,
+// the ones in parentheses, quotes and comments are skipped.
+func orderedOrLimited(query string) (string, bool) {
+	var (
+		depth int
+		prev  string
+	)
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			// the escaped quotes, such as 'it''s', are parsed as two quoted strings
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				return "", false
+			}
+			i += end + 2
+			continue
+		case strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return "", false
+			}
+			i += end + 1
+			continue
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return "", false
+			}
+			i += end + 4
+			continue
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(query) && (query[j] == '_' || unicode.IsLetter(rune(query[j])) || unicode.IsDigit(rune(query[j]))) {
+				j++
+			}
+
+			word := strings.ToUpper(query[i:j])
+			if depth == 0 {
+				switch {
+				case prev == "ORDER" && word == "BY":
+					return "ORDER BY", true
+				case word == "LIMIT" || word == "OFFSET" || word == "FETCH":
+					return word, true
+				}
+			}
+			prev = word
+			i = j
+			continue
+		}
+		i++
+	}
+	return "", false
+}
+
+// Exec implements DB.
+func (s *ShardedDB) Exec(query string, args ...any) (sql.Result, error) {
+	return s.ExecContext(context.Background(), query, args...)
+}
+
+// ExecContext implements DB.
+func (s *ShardedDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	db, err := s.shard(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.ExecContext(ctx, query, args...)
+}
+
+// NamedExec implements DB.
+func (s *ShardedDB) NamedExec(query string, arg any) (sql.Result, error) {
+	return s.NamedExecContext(context.Background(), query, arg)
+}
+
+// NamedExecContext implements DB.
+func (s *ShardedDB) NamedExecContext(ctx context.Context, query string, arg any) (sql.Result, error) {
+	db, err := s.shard(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.NamedExecContext(ctx, query, arg)
+}
+
+// NamedQuery implements DB, it always fails because there is no context to locate the shard.
+func (s *ShardedDB) NamedQuery(string, any) (*sqlx.Rows, error) {
+	return nil, ErrShardKeyRequired
+}
+
+// Prepare implements DB.
+func (s *ShardedDB) Prepare(query string) (*sql.Stmt, error) {
+	return s.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext implements DB.
+func (s *ShardedDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	db, err := s.shard(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.PrepareContext(ctx, query)
+}
+
+// Preparex implements DB.
+func (s *ShardedDB) Preparex(query string) (*sqlx.Stmt, error) {
+	return s.PreparexContext(context.Background(), query)
+}
+
+// PreparexContext implements DB.
+func (s *ShardedDB) PreparexContext(ctx context.Context, query string) (*sqlx.Stmt, error) {
+	db, err := s.shard(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.PreparexContext(ctx, query)
+}
+
+// PrepareNamed implements DB.
+func (s *ShardedDB) PrepareNamed(query string) (*sqlx.NamedStmt, error) {
+	return s.PrepareNamedContext(context.Background(), query)
+}
+
+// PrepareNamedContext implements DB.
+func (s *ShardedDB) PrepareNamedContext(ctx context.Context, query string) (*sqlx.NamedStmt, error) {
+	db, err := s.shard(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.PrepareNamedContext(ctx, query)
+}
+
+// DriverName implements DB.
+func (s *ShardedDB) DriverName() string {
+	return s.shards[0].DriverName()
+}
+
+// Rebind implements DB.
+func (s *ShardedDB) Rebind(query string) string {
+	return s.shards[0].Rebind(query)
+}
+
+// BindNamed implements DB.
+func (s *ShardedDB) BindNamed(query string, arg any) (string, []any, error) {
+	return s.shards[0].BindNamed(query, arg)
+}
+
+// BeginTxx begins a transaction on the shard located by the shard key of ctx.
+func (s *ShardedDB) BeginTxx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	db, err := s.shard(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return beginTx(ctx, db, opts)
+}
+
+// beginTx begins a transaction of *sqlx.Tx or Tx.
+func beginTx(ctx context.Context, db DB, opts *sql.TxOptions) (Tx, error) {
+	switch v := db.(type) {
+	case TxInitiator[*sqlx.Tx]:
+		tx, err := v.BeginTxx(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		return tx, nil
+	case TxInitiator[Tx]:
+		return v.BeginTxx(ctx, opts)
+	}
+	return nil, fmt.Errorf("db %T can not begin transaction", db)
+}
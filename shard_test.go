@@ -0,0 +1,241 @@
+package entity
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+)
+
+type shardedOrder struct {
+	ID     int64 `db:"id,primaryKey"`
+	UserID int64 `db:"user_id"`
+}
+
+func (o *shardedOrder) TableName() string {
+	return o.ShardTable(o.UserID)
+}
+
+func (o *shardedOrder) ShardTable(key any) string {
+	n, _ := ModuloShard(key, 16)
+	return fmt.Sprintf("orders_%02d", n)
+}
+
+func (o *shardedOrder) SetID(id int64) error {
+	o.ID = id
+	return nil
+}
+
+func (o *shardedOrder) ShardKey() any {
+	if o.UserID == 0 {
+		return nil
+	}
+	return o.UserID
+}
+
+// queryStub records the queries and fails them.
+type queryStub struct {
+	DB
+
+	queries []string
+}
+
+func (s *queryStub) DriverName() string {
+	return driverPostgres
+}
+
+func (s *queryStub) QueryxContext(_ context.Context, query string, _ ...any) (*sqlx.Rows, error) {
+	s.queries = append(s.queries, query)
+	return nil, errQueryStub
+}
+
+func (s *queryStub) SelectContext(_ context.Context, _ any, query string, _ ...any) error {
+	s.queries = append(s.queries, query)
+	return errQueryStub
+}
+
+var errQueryStub = errors.New("query stub")
+
+// shardStub returns its rows to SelectContext.
+type shardStub struct {
+	DB
+
+	rows []int
+}
+
+func (s *shardStub) SelectContext(_ context.Context, dest any, _ string, _ ...any) error {
+	*(dest.(*[]int)) = append([]int{}, s.rows...)
+	return nil
+}
+
+func TestModuloShard(t *testing.T) {
+	cases := []struct {
+		key      any
+		expected int
+	}{
+		{key: int64(7), expected: 3},
+		{key: -7, expected: 3},
+		{key: uint32(9), expected: 1},
+	}
+
+	for _, c := range cases {
+		if actual, err := ModuloShard(c.key, 4); err != nil {
+			t.Fatalf("ModuloShard(%v), %v", c.key, err)
+		} else if actual != c.expected {
+			t.Fatalf("ModuloShard(%v), Expected=%d, Actual=%d", c.key, c.expected, actual)
+		}
+	}
+
+	a, _ := ModuloShard("user-1", 4)
+	b, _ := ModuloShard("user-1", 4)
+	if a != b || a < 0 || a >= 4 {
+		t.Fatalf("ModuloShard of string, %d, %d", a, b)
+	}
+
+	if _, err := ModuloShard(nil, 4); !errors.Is(err, ErrShardKeyRequired) {
+		t.Fatalf("ModuloShard(nil), Expected=%v, Actual=%v", ErrShardKeyRequired, err)
+	}
+	if _, err := ModuloShard(int64(1), 0); err == nil {
+		t.Fatal("ModuloShard without shards, Expected=error, Actual=nil")
+	}
+}
+
+func TestShardedDB(t *testing.T) {
+	errShards := []error{errors.New("shard 0"), errors.New("shard 1")}
+
+	var shards []DB
+	for _, err := range errShards {
+		db := sqlx.NewDb(sql.OpenDB(errConnector{err: err}), driverPostgres)
+		defer db.Close()
+
+		shards = append(shards, db)
+	}
+	db := NewShardedDB(shards)
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("NewShardedDB without shards, Expected=panic")
+			}
+		}()
+		NewShardedDB(nil)
+	}()
+
+	ctx := context.Background()
+	if err := Load(ctx, &shardedOrder{ID: 1, UserID: 3}, db); !errors.Is(err, errShards[1]) {
+		t.Fatalf("load by entity shard key, Expected=%v, Actual=%v", errShards[1], err)
+	}
+	if err := Load(WithShardKey(ctx, int64(2)), &shardedOrder{ID: 1}, db); !errors.Is(err, errShards[0]) {
+		t.Fatalf("load by context shard key, Expected=%v, Actual=%v", errShards[0], err)
+	}
+	if err := Load(ctx, &shardedOrder{ID: 1}, db); !errors.Is(err, ErrShardKeyRequired) {
+		t.Fatalf("load without shard key, Expected=%v, Actual=%v", ErrShardKeyRequired, err)
+	}
+	if _, err := db.BeginTxx(WithShardKey(ctx, 1), nil); !errors.Is(err, errShards[1]) {
+		t.Fatalf("begin, Expected=%v, Actual=%v", errShards[1], err)
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM orders"); !errors.Is(err, ErrShardKeyRequired) {
+		t.Fatalf("exec without shard key, Expected=%v, Actual=%v", ErrShardKeyRequired, err)
+	}
+
+	t.Run("scatter gather", func(t *testing.T) {
+		db := NewShardedDB([]DB{&shardStub{rows: []int{1, 2}}, &shardStub{rows: []int{3}}})
+
+		var rows []int
+		if err := db.SelectContext(ctx, &rows, "SELECT id FROM orders"); err != nil {
+			t.Fatal(err)
+		} else if expected := []int{1, 2, 3}; !reflect.DeepEqual(rows, expected) {
+			t.Fatalf("gathered rows, Expected=%v, Actual=%v", expected, rows)
+		}
+
+		rows = nil
+		if err := db.SelectContext(WithShardKey(ctx, 1), &rows, "SELECT id FROM orders"); err != nil {
+			t.Fatal(err)
+		} else if expected := []int{3}; !reflect.DeepEqual(rows, expected) {
+			t.Fatalf("routed rows, Expected=%v, Actual=%v", expected, rows)
+		}
+
+		// the zero value is a valid shard key
+		rows = nil
+		if err := db.SelectContext(WithShardKey(ctx, 0), &rows, "SELECT id FROM orders"); err != nil {
+			t.Fatal(err)
+		} else if expected := []int{1, 2}; !reflect.DeepEqual(rows, expected) {
+			t.Fatalf("rows of shard key 0, Expected=%v, Actual=%v", expected, rows)
+		}
+
+		// the ordered or limited results of shards can't be gathered
+		for _, query := range []string{
+			"SELECT id FROM orders ORDER BY id DESC LIMIT 10",
+			"SELECT id FROM orders LIMIT 10",
+			"SELECT id FROM orders OFFSET 10",
+		} {
+			if err := db.SelectContext(ctx, &rows, query); !errors.Is(err, ErrShardKeyRequired) {
+				t.Fatalf("%s, Expected=%v, Actual=%v", query, ErrShardKeyRequired, err)
+			}
+		}
+
+		// the clauses of subqueries, quotes and comments are not the ones of query
+		for _, query := range []string{
+			"SELECT id FROM orders WHERE id IN (SELECT id FROM items ORDER BY id LIMIT 10)",
+			"SELECT id FROM orders WHERE note = 'order by ''limit'''",
+			"SELECT id FROM orders /* LIMIT 10 */",
+		} {
+			rows = nil
+			if err := db.SelectContext(ctx, &rows, query); err != nil {
+				t.Fatalf("%s, %v", query, err)
+			} else if len(rows) != 3 {
+				t.Fatalf("%s, Expected=3 rows, Actual=%v", query, rows)
+			}
+		}
+	})
+
+	t.Run("table suffix", func(t *testing.T) {
+		for _, userID := range []int64{7, 23, 8} {
+			md, err := getTableMetadata(ctx, &shardedOrder{UserID: userID})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			table := fmt.Sprintf(`"orders_%02d"`, userID%16)
+			if stmt := getStatement(commandSelect, md, driverPostgres); !strings.Contains(stmt, table) {
+				t.Fatalf("statement of user %d, Expected=%s, Actual=%s", userID, table, stmt)
+			}
+		}
+	})
+}
+
+func TestRepositoryShardTable(t *testing.T) {
+	// the metadata keeps the table of the first instance, the columns of Dataset are qualified by it
+	md, err := getMetadata(&shardedOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := &queryStub{}
+	repo := NewRepository[int64, *shardedOrder](db)
+	ctx := WithShardKey(context.Background(), int64(7))
+
+	if _, err := repo.Find(ctx, 1); !errors.Is(err, errQueryStub) {
+		t.Fatalf("find, Expected=%v, Actual=%v", errQueryStub, err)
+	} else if !strings.Contains(db.queries[0], `FROM "orders_07"`) {
+		t.Fatalf("find query, Expected=FROM \"orders_07\", Actual=%s", db.queries[0])
+	}
+
+	if _, err := repo.Query(ctx, repo.Dataset().Where(goqu.C("user_id").Eq(7))); !errors.Is(err, errQueryStub) {
+		t.Fatalf("query, Expected=%v, Actual=%v", errQueryStub, err)
+	} else if expected := `FROM "orders_07" AS "` + md.TableName + `"`; !strings.Contains(db.queries[1], expected) {
+		t.Fatalf("query, Expected=%s, Actual=%s", expected, db.queries[1])
+	}
+
+	if _, err := repo.Find(context.Background(), 1); !errors.Is(err, ErrShardKeyRequired) {
+		t.Fatalf("find without shard key, Expected=%v, Actual=%v", ErrShardKeyRequired, err)
+	} else if _, err := repo.Query(context.Background(), repo.Dataset()); !errors.Is(err, ErrShardKeyRequired) {
+		t.Fatalf("query without shard key, Expected=%v, Actual=%v", ErrShardKeyRequired, err)
+	}
+}
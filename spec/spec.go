@@ -0,0 +1,240 @@
+// Package spec implements the specification pattern, domain code describes the selection of domain objects
+// by the fields of domain objects, without depending on the SQL builder.
+//
+// The specifications are translated into SQL by the repository, field names are mapped to columns
+// by the mapping registered on the repository.
+//
+// Example:
+//
+//	s := spec.Where(
+//		spec.Eq("Status", "active"),
+//		spec.Or(spec.Gte("Age", 18), spec.IsNotNull("GuardianID")),
+//	).OrderBy(spec.Desc("CreatedAt")).Take(10)
+package spec
+
+// Operator is the operator of comparison.
+type Operator string
+
+// Operators of comparison.
+const (
+	OpEq        Operator = "eq"
+	OpNeq       Operator = "neq"
+	OpGt        Operator = "gt"
+	OpGte       Operator = "gte"
+	OpLt        Operator = "lt"
+	OpLte       Operator = "lte"
+	OpIn        Operator = "in"
+	OpNotIn     Operator = "notIn"
+	OpLike      Operator = "like"
+	OpIsNull    Operator = "isNull"
+	OpIsNotNull Operator = "isNotNull"
+)
+
+// Criterion is a condition of specification, it's one of Comparison, Composite and Negation.
+type Criterion interface {
+	criterion()
+}
+
+// Comparison compares a field with a value.
+type Comparison struct {
+	Field    string
+	Operator Operator
+	Value    any
+}
+
+func (Comparison) criterion() {}
+
+// Composite combines criteria with AND or OR.
+type Composite struct {
+	// Or combines criteria with OR if true, otherwise AND.
+	Or       bool
+	Criteria []Criterion
+}
+
+func (Composite) criterion() {}
+
+// Negation negates a criterion, the negation of nil criterion matches nothing.
+type Negation struct {
+	Criterion Criterion
+}
+
+func (Negation) criterion() {}
+
+// Eq means field = value.
+func Eq(field string, value any) Criterion {
+	return Comparison{Field: field, Operator: OpEq, Value: value}
+}
+
+// Neq means field != value.
+func Neq(field string, value any) Criterion {
+	return Comparison{Field: field, Operator: OpNeq, Value: value}
+}
+
+// Gt means field > value.
+func Gt(field string, value any) Criterion {
+	return Comparison{Field: field, Operator: OpGt, Value: value}
+}
+
+// Gte means field >= value.
+func Gte(field string, value any) Criterion {
+	return Comparison{Field: field, Operator: OpGte, Value: value}
+}
+
+// Lt means field < value.
+func Lt(field string, value any) Criterion {
+	return Comparison{Field: field, Operator: OpLt, Value: value}
+}
+
+// Lte means field <= value.
+func Lte(field string, value any) Criterion {
+	return Comparison{Field: field, Operator: OpLte, Value: value}
+}
+
+// In means field IN (values...), values should be a slice.
+func In(field string, values any) Criterion {
+	return Comparison{Field: field, Operator: OpIn, Value: values}
+}
+
+// NotIn means field NOT IN (values...), values should be a slice.
+func NotIn(field string, values any) Criterion {
+	return Comparison{Field: field, Operator: OpNotIn, Value: values}
+}
+
+// Like means field LIKE pattern.
+func Like(field string, pattern string) Criterion {
+	return Comparison{Field: field, Operator: OpLike, Value: pattern}
+}
+
+// IsNull means field IS NULL.
+func IsNull(field string) Criterion {
+	return Comparison{Field: field, Operator: OpIsNull}
+}
+
+// IsNotNull means field IS NOT NULL.
+func IsNotNull(field string) Criterion {
+	return Comparison{Field: field, Operator: OpIsNotNull}
+}
+
+// And combines criteria with AND, nil criteria are ignored.
+func And(criteria ...Criterion) Criterion {
+	return combine(false, criteria)
+}
+
+// Or combines criteria with OR, nil criteria are ignored.
+func Or(criteria ...Criterion) Criterion {
+	return combine(true, criteria)
+}
+
+// Not negates the criterion.
+func Not(c Criterion) Criterion {
+	if c == nil {
+		return nil
+	} else if n, ok := c.(Negation); ok {
+		return n.Criterion
+	}
+	return Negation{Criterion: c}
+}
+
+func combine(or bool, criteria []Criterion) Criterion {
+	result := make([]Criterion, 0, len(criteria))
+	for _, c := range criteria {
+		if c == nil {
+			continue
+		}
+
+		// flatten the nested composite of the same operator
+		if v, ok := c.(Composite); ok && v.Or == or {
+			result = append(result, v.Criteria...)
+		} else {
+			result = append(result, c)
+		}
+	}
+
+	switch len(result) {
+	case 0:
+		return nil
+	case 1:
+		return result[0]
+	}
+	return Composite{Or: or, Criteria: result}
+}
+
+// Order is the ordering by a field.
+type Order struct {
+	Field string
+	Desc  bool
+}
+
+// Asc orders by field in ascending order.
+func Asc(field string) Order {
+	return Order{Field: field}
+}
+
+// Desc orders by field in descending order.
+func Desc(field string) Order {
+	return Order{Field: field, Desc: true}
+}
+
+// Specification describes a selection of domain objects, the zero value selects all.
+//
+// The methods return new specifications, so a specification can be shared and extended safely.
+type Specification struct {
+	// Criterion is the condition, nil means no condition.
+	Criterion Criterion
+	Orders    []Order
+	// Limit is the max number of results, 0 means no limit.
+	Limit  uint
+	Offset uint
+}
+
+// Where creates a specification matching all the criteria.
+func Where(criteria ...Criterion) Specification {
+	return Specification{Criterion: And(criteria...)}
+}
+
+// And returns a specification matching the specification and all the criteria.
+func (s Specification) And(criteria ...Criterion) Specification {
+	s.Criterion = And(append([]Criterion{s.Criterion}, criteria...)...)
+	return s
+}
+
+// Or returns a specification matching the specification or any of the criteria.
+func (s Specification) Or(criteria ...Criterion) Specification {
+	if s.Criterion == nil {
+		// no condition matches all
+		return s
+	}
+
+	s.Criterion = Or(append([]Criterion{s.Criterion}, criteria...)...)
+	return s
+}
+
+// Not returns a specification matching the opposite of the condition, the ordering and limit are kept.
+// The opposite of the empty specification matches nothing.
+func (s Specification) Not() Specification {
+	if s.Criterion == nil {
+		s.Criterion = Negation{}
+		return s
+	}
+
+	s.Criterion = Not(s.Criterion)
+	return s
+}
+
+// OrderBy returns a specification appending the orders.
+func (s Specification) OrderBy(orders ...Order) Specification {
+	s.Orders = append(append([]Order{}, s.Orders...), orders...)
+	return s
+}
+
+// Take returns a specification limiting the number of results.
+func (s Specification) Take(limit uint) Specification {
+	s.Limit = limit
+	return s
+}
+
+// Skip returns a specification skipping the first offset results.
+func (s Specification) Skip(offset uint) Specification {
+	s.Offset = offset
+	return s
+}
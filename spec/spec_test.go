@@ -0,0 +1,48 @@
+package spec
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpecification(t *testing.T) {
+	a, b, c := Eq("A", 1), Eq("B", 2), Eq("C", 3)
+
+	if v := And(And(a, b), nil, c); !reflect.DeepEqual(v, Composite{Criteria: []Criterion{a, b, c}}) {
+		t.Fatalf("flatten and, Actual=%v", v)
+	}
+	if v := Or(a, And(b, c)); !reflect.DeepEqual(v, Composite{Or: true, Criteria: []Criterion{a, Composite{Criteria: []Criterion{b, c}}}}) {
+		t.Fatalf("nested or, Actual=%v", v)
+	}
+	if v := Not(Not(a)); !reflect.DeepEqual(v, a) {
+		t.Fatalf("double negation, Actual=%v", v)
+	}
+
+	base := Where(a).OrderBy(Asc("A"))
+	extended := base.And(b).OrderBy(Desc("B")).Take(10)
+	if !reflect.DeepEqual(base, Where(a).OrderBy(Asc("A"))) {
+		t.Fatal("specification should not be changed by extending")
+	}
+
+	expected := Specification{
+		Criterion: Composite{Criteria: []Criterion{a, b}},
+		Orders:    []Order{Asc("A"), Desc("B")},
+		Limit:     10,
+	}
+	if !reflect.DeepEqual(extended, expected) {
+		t.Fatalf("extended specification, Expected=%v, Actual=%v", expected, extended)
+	}
+
+	if v := (Specification{}).Or(a); v.Criterion != nil {
+		t.Fatal("empty specification matches all, or should not narrow it")
+	}
+	if v := (Specification{}).Not(); !reflect.DeepEqual(v.Criterion, Negation{}) {
+		t.Fatalf("opposite of empty specification should match nothing, Actual=%v", v.Criterion)
+	}
+	if v := (Specification{}).Not().Not(); v.Criterion != nil {
+		t.Fatalf("double negation of empty specification, Actual=%v", v.Criterion)
+	}
+	if v := Where(a).Or(b).Not(); !reflect.DeepEqual(v.Criterion, Negation{Criterion: Composite{Or: true, Criteria: []Criterion{a, b}}}) {
+		t.Fatalf("negation of or, Actual=%v", v.Criterion)
+	}
+}
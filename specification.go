@@ -0,0 +1,183 @@
+package entity
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/joyparty/entity/spec"
+)
+
+// WithFieldMapping maps the field names of domain object used by specifications to columns.
+//
+// The fields not in the mapping are mapped to the columns of persistent object with the same struct field name.
+func WithFieldMapping(mapping map[string]string) DomainObjectRepositoryOption {
+	return func(o *domainObjectRepositoryOptions) {
+		o.fields = mapping
+	}
+}
+
+// GetBySpec retrieves a single domain object matching the specification.
+func (r *DomainObjectRepository[ID, DO, PO]) GetBySpec(ctx context.Context, s spec.Specification) (DO, error) {
+	stmt, err := r.selectBySpec(s.Take(1))
+	if err != nil {
+		var x DO
+		return x, err
+	}
+	return r.Get(ctx, stmt)
+}
+
+// QueryBySpec retrieves a list of domain objects matching the specification.
+func (r *DomainObjectRepository[ID, DO, PO]) QueryBySpec(ctx context.Context, s spec.Specification) ([]DO, error) {
+	stmt, err := r.selectBySpec(s)
+	if err != nil {
+		return nil, err
+	}
+	return r.Query(ctx, stmt)
+}
+
+// PageQueryBySpec retrieves a paginated list of domain objects matching the specification,
+// the limit and offset of specification are replaced by the pagination.
+func (r *DomainObjectRepository[ID, DO, PO]) PageQueryBySpec(ctx context.Context, s spec.Specification, currentPage, pageSize int) ([]DO, Pagination, error) {
+	s.Limit, s.Offset = 0, 0
+
+	stmt, err := r.selectBySpec(s)
+	if err != nil {
+		return nil, Pagination{}, err
+	}
+	return r.PageQuery(ctx, stmt, currentPage, pageSize)
+}
+
+// ForEachBySpec iterates over domain objects matching the specification.
+func (r *DomainObjectRepository[ID, DO, PO]) ForEachBySpec(ctx context.Context, s spec.Specification, iteratee func(do DO) (bool, error)) error {
+	stmt, err := r.selectBySpec(s)
+	if err != nil {
+		return err
+	}
+	return r.ForEach(ctx, stmt, iteratee)
+}
+
+// UpdateBySpec queries for domain objects matching the specification and updates them using the apply function.
+func (r *DomainObjectRepository[ID, DO, PO]) UpdateBySpec(ctx context.Context, s spec.Specification, apply func(do DO) (bool, error)) error {
+	stmt, err := r.selectBySpec(s)
+	if err != nil {
+		return err
+	}
+	return r.UpdateByQuery(ctx, stmt, apply)
+}
+
+// selectBySpec translates the specification into select statement.
+func (r *DomainObjectRepository[ID, DO, PO]) selectBySpec(s spec.Specification) (*goqu.SelectDataset, error) {
+	md, err := getMetadata(reflect.New(r.poType).Interface().(PO))
+	if err != nil {
+		return nil, fmt.Errorf("get metadata, %w", err)
+	}
+
+	table := goqu.T(TableIdentifier(md.TableName).GetTable())
+	column := func(field string) (exp.IdentifierExpression, error) {
+		if col, ok := r.fields[field]; ok {
+			return table.Col(col), nil
+		}
+
+		for _, col := range md.Columns {
+			if col.StructField == field {
+				return table.Col(col.DBField), nil
+			}
+		}
+		return nil, fmt.Errorf("unknown field %q", field)
+	}
+
+	stmt := r.poRepository.Dataset()
+	if s.Criterion != nil {
+		expr, err := criterionExpression(s.Criterion, column)
+		if err != nil {
+			return nil, fmt.Errorf("translate specification, %w", err)
+		}
+		stmt = stmt.Where(expr)
+	}
+
+	for _, order := range s.Orders {
+		col, err := column(order.Field)
+		if err != nil {
+			return nil, fmt.Errorf("translate specification, %w", err)
+		}
+
+		if order.Desc {
+			stmt = stmt.OrderAppend(col.Desc())
+		} else {
+			stmt = stmt.OrderAppend(col.Asc())
+		}
+	}
+
+	if s.Limit > 0 {
+		stmt = stmt.Limit(s.Limit)
+	}
+	if s.Offset > 0 {
+		stmt = stmt.Offset(s.Offset)
+	}
+	return stmt, nil
+}
+
+func criterionExpression(c spec.Criterion, column func(field string) (exp.IdentifierExpression, error)) (exp.Expression, error) {
+	switch v := c.(type) {
+	case spec.Comparison:
+		col, err := column(v.Field)
+		if err != nil {
+			return nil, err
+		}
+
+		switch v.Operator {
+		case spec.OpEq:
+			return col.Eq(v.Value), nil
+		case spec.OpNeq:
+			return col.Neq(v.Value), nil
+		case spec.OpGt:
+			return col.Gt(v.Value), nil
+		case spec.OpGte:
+			return col.Gte(v.Value), nil
+		case spec.OpLt:
+			return col.Lt(v.Value), nil
+		case spec.OpLte:
+			return col.Lte(v.Value), nil
+		case spec.OpIn:
+			return col.In(v.Value), nil
+		case spec.OpNotIn:
+			return col.NotIn(v.Value), nil
+		case spec.OpLike:
+			return col.Like(v.Value), nil
+		case spec.OpIsNull:
+			return col.IsNull(), nil
+		case spec.OpIsNotNull:
+			return col.IsNotNull(), nil
+		}
+		return nil, fmt.Errorf("unsupported operator %q", v.Operator)
+	case spec.Composite:
+		exprs := make([]exp.Expression, 0, len(v.Criteria))
+		for _, c := range v.Criteria {
+			expr, err := criterionExpression(c, column)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, expr)
+		}
+
+		if v.Or {
+			return goqu.Or(exprs...), nil
+		}
+		return goqu.And(exprs...), nil
+	case spec.Negation:
+		if v.Criterion == nil {
+			// the opposite of no condition matches nothing
+			return goqu.L("1 = 0"), nil
+		}
+
+		expr, err := criterionExpression(v.Criterion, column)
+		if err != nil {
+			return nil, err
+		}
+		return goqu.L("NOT (?)", expr), nil
+	}
+	return nil, fmt.Errorf("unsupported criterion %T", c)
+}
@@ -0,0 +1,112 @@
+package entity
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/joyparty/entity/spec"
+)
+
+type specObject struct {
+	ID     int
+	Status string
+	Score  int
+}
+
+type specRow struct {
+	ID     int    `db:"id,primaryKey"`
+	Status string `db:"status"`
+	Score  int    `db:"score_value"`
+}
+
+func (sr *specRow) TableName() string {
+	return "spec_rows"
+}
+
+func (sr *specRow) SetID(id int) error {
+	sr.ID = id
+	return nil
+}
+
+func (sr *specRow) GetID() int {
+	return sr.ID
+}
+
+func (sr *specRow) Set(_ context.Context, so *specObject) error {
+	sr.ID, sr.Status, sr.Score = so.ID, so.Status, so.Score
+	return nil
+}
+
+func (sr *specRow) ToDomainObject() (*specObject, error) {
+	return &specObject{ID: sr.ID, Status: sr.Status, Score: sr.Score}, nil
+}
+
+func TestSelectBySpec(t *testing.T) {
+	repo := NewDomainObjectRepository[int, *specObject, *specRow](
+		NewRepository[int, *specRow](sqlx.NewDb(nil, driverPostgres)),
+		WithFieldMapping(map[string]string{"Points": "score_value"}),
+	)
+
+	prefix := `SELECT "spec_rows"."id", "spec_rows"."status", "spec_rows"."score_value" FROM "spec_rows"`
+	cases := []struct {
+		spec     spec.Specification
+		expected string
+	}{
+		{
+			spec:     spec.Specification{},
+			expected: prefix,
+		},
+		{
+			spec: spec.Where(spec.Eq("Status", "active"), spec.Gte("Points", 10)).
+				OrderBy(spec.Desc("Score"), spec.Asc("ID")).
+				Take(10).
+				Skip(20),
+			expected: prefix + ` WHERE (("spec_rows"."status" = 'active') AND ("spec_rows"."score_value" >= 10))` +
+				` ORDER BY "spec_rows"."score_value" DESC, "spec_rows"."id" ASC LIMIT 10 OFFSET 20`,
+		},
+		{
+			spec: spec.Where(spec.Eq("Status", "active")).
+				Or(spec.And(spec.In("ID", []int{1, 2}), spec.Not(spec.IsNull("Score")))),
+			expected: prefix + ` WHERE (("spec_rows"."status" = 'active') OR (("spec_rows"."id" IN (1, 2)) AND NOT (("spec_rows"."score_value" IS NULL))))`,
+		},
+		{
+			spec:     spec.Where(spec.Like("Status", "a%")).Not(),
+			expected: prefix + ` WHERE NOT (("spec_rows"."status" LIKE 'a%'))`,
+		},
+		{
+			spec:     spec.Where(spec.Eq("Status", "active")).Or(spec.Gte("Points", 10)).Not(),
+			expected: prefix + ` WHERE NOT ((("spec_rows"."status" = 'active') OR ("spec_rows"."score_value" >= 10)))`,
+		},
+		{
+			spec:     spec.Specification{}.Not(),
+			expected: prefix + ` WHERE 1 = 0`,
+		},
+		{
+			spec:     spec.Specification{}.Not().Not(),
+			expected: prefix,
+		},
+		{
+			spec:     spec.Specification{}.Not().Or(spec.Eq("ID", 1)),
+			expected: prefix + ` WHERE (1 = 0 OR ("spec_rows"."id" = 1))`,
+		},
+	}
+
+	for _, c := range cases {
+		stmt, err := repo.selectBySpec(c.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		query, _, err := stmt.ToSQL()
+		if err != nil {
+			t.Fatal(err)
+		} else if query != c.expected {
+			t.Fatalf("select by spec, Expected=%s, Actual=%s", c.expected, query)
+		}
+	}
+
+	if _, err := repo.selectBySpec(spec.Where(spec.Eq("Unknown", 1))); err == nil {
+		t.Fatal("unknown field, Expected=error, Actual=nil")
+	}
+}
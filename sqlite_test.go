@@ -0,0 +1,150 @@
+package entity
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newSQLiteDB opens a sqlite database in a temporary file, and creates the tables by the statements.
+func newSQLiteDB(t *testing.T, ddl ...string) *sqlx.DB {
+	t.Helper()
+
+	dsn := filepath.Join(t.TempDir(), "entity.db") + "?_busy_timeout=5000&_journal_mode=WAL"
+	db, err := sqlx.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, stmt := range ddl {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+type sqliteUser struct {
+	ID       int64  `db:"id,primaryKey"`
+	TenantID int64  `db:"tenant_id"`
+	Name     string `db:"name"`
+}
+
+func (u *sqliteUser) TableName() string {
+	return "users"
+}
+
+func (u *sqliteUser) SetID(id int64) error {
+	u.ID = id
+	return nil
+}
+
+const sqliteUserTable = `CREATE TABLE users (id INTEGER PRIMARY KEY, tenant_id INTEGER NOT NULL DEFAULT 0, name TEXT NOT NULL)`
+
+type sqliteTenantUser struct {
+	ID       int64  `db:"id,primaryKey"`
+	TenantID int64  `db:"tenant_id,tenant"`
+	Name     string `db:"name"`
+}
+
+func (u *sqliteTenantUser) TableName() string {
+	return "users"
+}
+
+func (u *sqliteTenantUser) SetID(id int64) error {
+	u.ID = id
+	return nil
+}
+
+const sqliteOrderTables = `
+CREATE TABLE orders (id INTEGER PRIMARY KEY, note TEXT NOT NULL);
+CREATE TABLE order_lines (
+	id INTEGER PRIMARY KEY,
+	order_id INTEGER NOT NULL REFERENCES orders (id),
+	sku TEXT NOT NULL,
+	qty INTEGER NOT NULL CHECK (qty > 0)
+);`
+
+// sqliteOrder is the domain object of the order aggregate.
+type sqliteOrder struct {
+	EventRecorder
+
+	ID    int64
+	Note  string
+	Lines []sqliteOrderLine
+}
+
+type sqliteOrderLine struct {
+	ID  int64
+	SKU string
+	Qty int
+}
+
+type sqliteOrderLineRow struct {
+	ID      int64  `db:"id,primaryKey,autoIncrement"`
+	OrderID int64  `db:"order_id"`
+	SKU     string `db:"sku"`
+	Qty     int    `db:"qty"`
+}
+
+func (l *sqliteOrderLineRow) TableName() string {
+	return "order_lines"
+}
+
+// sqliteOrderRow is the persistent object of the order aggregate.
+type sqliteOrderRow struct {
+	ID   int64  `db:"id,primaryKey"`
+	Note string `db:"note"`
+
+	lines []*sqliteOrderLineRow
+}
+
+func (o *sqliteOrderRow) TableName() string {
+	return "orders"
+}
+
+func (o *sqliteOrderRow) SetID(id int64) error {
+	o.ID = id
+	return nil
+}
+
+func (o *sqliteOrderRow) GetID() int64 {
+	return o.ID
+}
+
+func (o *sqliteOrderRow) Set(_ context.Context, do *sqliteOrder) error {
+	o.ID = do.ID
+	o.Note = do.Note
+
+	o.lines = make([]*sqliteOrderLineRow, 0, len(do.Lines))
+	for _, l := range do.Lines {
+		o.lines = append(o.lines, &sqliteOrderLineRow{ID: l.ID, OrderID: do.ID, SKU: l.SKU, Qty: l.Qty})
+	}
+	return nil
+}
+
+func (o *sqliteOrderRow) ToDomainObject() (*sqliteOrder, error) {
+	do := &sqliteOrder{ID: o.ID, Note: o.Note}
+	for _, l := range o.lines {
+		do.Lines = append(do.Lines, sqliteOrderLine{ID: l.ID, SKU: l.SKU, Qty: l.Qty})
+	}
+	return do, nil
+}
+
+func (o *sqliteOrderRow) LoadChildren(ctx context.Context, db DB) error {
+	o.lines = nil
+	return GetRecords(ctx, &o.lines, db, goqu.From("order_lines").Where(goqu.C("order_id").Eq(o.ID)).Order(goqu.C("id").Asc()))
+}
+
+func (o *sqliteOrderRow) Children() []Entity {
+	children := make([]Entity, 0, len(o.lines))
+	for _, l := range o.lines {
+		children = append(children, l)
+	}
+	return children
+}
@@ -0,0 +1,208 @@
+package entity
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Redacted replaces the arguments of the columns declared with the "sensitive" tag option in StatementLog.
+const Redacted = "[REDACTED]"
+
+var (
+	statementLogger   StatementLogger
+	statementLoggerMu sync.RWMutex
+
+	namedParam = regexp.MustCompile(`(^|[^:]):([A-Za-z_][A-Za-z0-9_.]*)`)
+)
+
+// StatementLog describes an executed statement.
+type StatementLog struct {
+	// Statement is the SQL executed, the statements of CRUD functions use named parameters like ":id".
+	Statement string
+	// Args are the arguments bound before the execution in the order of parameters,
+	// nil if the statement is not sampled by StatementSampler.
+	Args         []any
+	Duration     time.Duration
+	RowsAffected int64
+	Err          error
+}
+
+// StatementLogger logs the statements executed by the CRUD functions, the prepared statements,
+// and the helper functions ExecInsert, ExecUpdate, ExecDelete, GetRecord, GetRecords and QueryBy.
+type StatementLogger interface {
+	// LogStatement is called after the statement is executed, RowsAffected is -1 if unknown.
+	LogStatement(ctx context.Context, log StatementLog)
+}
+
+// StatementSampler is optionally implemented by StatementLogger to decide before the execution
+// whether the arguments of a statement are needed, they are copied only for the sampled statements.
+type StatementSampler interface {
+	SampleStatement(ctx context.Context) bool
+}
+
+// StatementLoggerFunc is an adapter to allow the use of ordinary functions as StatementLogger.
+type StatementLoggerFunc func(ctx context.Context, log StatementLog)
+
+// LogStatement calls f(ctx, log).
+func (f StatementLoggerFunc) LogStatement(ctx context.Context, log StatementLog) {
+	f(ctx, log)
+}
+
+// SetStatementLogger sets the process-wide statement logger, nil disables logging.
+//
+// The arguments of CRUD functions are resolved from the entity when the statement is bound,
+// the values of the columns declared with the "sensitive" tag option are replaced by Redacted.
+// The arguments of helper functions are replaced by Redacted unless ctx is returned by LogStatementArgs,
+// because their columns are unknown. The values of a dataset not prepared are inlined into the logged SQL,
+// logging doesn't change the generated statements, use Prepared(true) to keep them out of the log.
+func SetStatementLogger(logger StatementLogger) {
+	statementLoggerMu.Lock()
+	defer statementLoggerMu.Unlock()
+
+	statementLogger = logger
+}
+
+func getStatementLogger() StatementLogger {
+	statementLoggerMu.RLock()
+	defer statementLoggerMu.RUnlock()
+
+	return statementLogger
+}
+
+type statementArgsKey struct{}
+
+// LogStatementArgs returns a copy of ctx with which the arguments of helper functions are logged as is,
+// make sure no sensitive value is bound in the statements executed with it.
+func LogStatementArgs(ctx context.Context) context.Context {
+	return context.WithValue(ctx, statementArgsKey{}, true)
+}
+
+type statementLogKey struct{}
+
+type statementLog struct {
+	logger    StatementLogger
+	statement string
+	args      []any
+	start     time.Time
+	rows      int64
+}
+
+// startStatementLog starts logging a statement if the logger is set,
+// args is called before the execution and only if the statement is sampled.
+func startStatementLog(ctx context.Context, stmt string, args func() []any) context.Context {
+	logger := getStatementLogger()
+	if logger == nil {
+		return ctx
+	}
+
+	sl := &statementLog{
+		logger:    logger,
+		statement: stmt,
+		rows:      -1,
+	}
+	if sampler, ok := logger.(StatementSampler); !ok || sampler.SampleStatement(ctx) {
+		sl.args = args()
+	}
+	sl.start = time.Now()
+
+	return context.WithValue(ctx, statementLogKey{}, sl)
+}
+
+func endStatementLog(ctx context.Context, err error) {
+	sl, ok := ctx.Value(statementLogKey{}).(*statementLog)
+	if !ok {
+		return
+	}
+
+	sl.logger.LogStatement(ctx, StatementLog{
+		Statement:    sl.statement,
+		Args:         sl.args,
+		Duration:     time.Since(sl.start),
+		RowsAffected: sl.rows,
+		Err:          err,
+	})
+}
+
+func logRows(ctx context.Context, n int64) {
+	if sl, ok := ctx.Value(statementLogKey{}).(*statementLog); ok {
+		sl.rows = n
+	}
+}
+
+func logResult(ctx context.Context, result sql.Result) {
+	if sl, ok := ctx.Value(statementLogKey{}).(*statementLog); ok && result != nil {
+		if n, err := result.RowsAffected(); err == nil {
+			sl.rows = n
+		}
+	}
+}
+
+// positionalArgs copies the arguments of helper functions, they are redacted unless ctx is returned by LogStatementArgs.
+func positionalArgs(ctx context.Context, args []any) func() []any {
+	return func() []any {
+		logged, _ := ctx.Value(statementArgsKey{}).(bool)
+
+		result := make([]any, len(args))
+		for i, arg := range args {
+			if logged {
+				result[i] = arg
+			} else {
+				result[i] = Redacted
+			}
+		}
+		return result
+	}
+}
+
+// namedArgs resolves the arguments of named parameters from the entity.
+func namedArgs(md *Metadata, stmt string, ent Entity) func() []any {
+	return func() []any {
+		sensitive := map[string]bool{}
+		for _, col := range md.Columns {
+			if col.Sensitive {
+				sensitive[col.DBField] = true
+			}
+		}
+
+		rv := reflect.ValueOf(ent)
+		matches := namedParam.FindAllStringSubmatch(stmt, -1)
+		args := make([]any, 0, len(matches))
+		for _, m := range matches {
+			name := m[2]
+			if sensitive[name] {
+				args = append(args, Redacted)
+				continue
+			}
+
+			args = append(args, argValue(fieldByColumn(rv, name)))
+		}
+		return args
+	}
+}
+
+func argValue(v reflect.Value) any {
+	if !v.IsValid() || !v.CanInterface() {
+		return nil
+	}
+
+	if valuer, ok := v.Interface().(driver.Valuer); ok {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return nil
+		} else if value, err := valuer.Value(); err == nil {
+			return value
+		}
+	}
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	return v.Interface()
+}
@@ -0,0 +1,136 @@
+package entity
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type sensitiveEntity struct {
+	ID       int64          `db:"id,primaryKey"`
+	Email    sql.NullString `db:"email"`
+	Password string         `db:"password,sensitive"`
+	Nickname *string        `db:"nickname"`
+}
+
+func (se *sensitiveEntity) TableName() string {
+	return "sensitive"
+}
+
+func TestNamedArgs(t *testing.T) {
+	md, err := getMetadata(&sensitiveEntity{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ent := &sensitiveEntity{
+		ID:       1,
+		Email:    sql.NullString{String: "a@b.c", Valid: true},
+		Password: "secret",
+	}
+	stmt := `UPDATE "sensitive" SET "email" = :email, "password" = :password, "nickname" = :nickname WHERE "id" = :id`
+
+	expected := []any{"a@b.c", Redacted, nil, int64(1)}
+	if args := namedArgs(md, stmt, ent)(); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("named args, Expected=%v, Actual=%v", expected, args)
+	} else if ent.Nickname != nil {
+		t.Fatal("nil pointer field should not be allocated")
+	}
+}
+
+func TestPositionalArgs(t *testing.T) {
+	args := []any{1, "secret"}
+
+	expected := []any{Redacted, Redacted}
+	if actual := positionalArgs(context.Background(), args)(); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("positional args, Expected=%v, Actual=%v", expected, actual)
+	}
+
+	if actual := positionalArgs(LogStatementArgs(context.Background()), args)(); !reflect.DeepEqual(actual, args) {
+		t.Fatalf("positional args, Expected=%v, Actual=%v", args, actual)
+	}
+}
+
+type statementSampler struct {
+	sampled bool
+	logs    []StatementLog
+}
+
+func (ss *statementSampler) SampleStatement(_ context.Context) bool {
+	return ss.sampled
+}
+
+func (ss *statementSampler) LogStatement(_ context.Context, log StatementLog) {
+	ss.logs = append(ss.logs, log)
+}
+
+func TestStatementLogger(t *testing.T) {
+	defer SetStatementLogger(nil)
+
+	var logs []StatementLog
+	SetStatementLogger(StatementLoggerFunc(func(_ context.Context, log StatementLog) {
+		logs = append(logs, log)
+	}))
+
+	errFailed := errors.New("failed")
+	ctx := startStatementLog(context.Background(), "SELECT 1", positionalArgs(LogStatementArgs(context.Background()), []any{1}))
+	logRows(ctx, 3)
+	endStatementLog(ctx, errFailed)
+
+	if len(logs) != 1 {
+		t.Fatalf("logs, Expected=1, Actual=%d", len(logs))
+	} else if log := logs[0]; log.Statement != "SELECT 1" || log.RowsAffected != 3 || !errors.Is(log.Err, errFailed) {
+		t.Fatalf("unexpected log %+v", log)
+	} else if !reflect.DeepEqual(log.Args, []any{1}) {
+		t.Fatalf("args, Expected=%v, Actual=%v", []any{1}, log.Args)
+	}
+
+	t.Run("bound args", func(t *testing.T) {
+		logs = nil
+
+		md, err := getMetadata(&sensitiveEntity{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ent := &sensitiveEntity{ID: 1}
+		stmt := `SELECT * FROM "sensitive" WHERE "id" = :id`
+		ctx := startStatementLog(context.Background(), stmt, namedArgs(md, stmt, ent))
+		// changed by the execution, such as scanning the returning columns
+		ent.ID = 2
+		endStatementLog(ctx, nil)
+
+		if expected := []any{int64(1)}; !reflect.DeepEqual(logs[0].Args, expected) {
+			t.Fatalf("args, Expected=%v, Actual=%v", expected, logs[0].Args)
+		}
+	})
+
+	t.Run("sampler", func(t *testing.T) {
+		for _, sampled := range []bool{true, false} {
+			sampler := &statementSampler{sampled: sampled}
+			SetStatementLogger(sampler)
+
+			var resolved bool
+			ctx := startStatementLog(context.Background(), "SELECT 1", func() []any {
+				resolved = true
+				return []any{1}
+			})
+			endStatementLog(ctx, nil)
+
+			if resolved != sampled {
+				t.Fatalf("sampled=%v, args resolved=%v", sampled, resolved)
+			} else if len(sampler.logs) != 1 {
+				t.Fatalf("logs, Expected=1, Actual=%d", len(sampler.logs))
+			} else if sampled != (sampler.logs[0].Args != nil) {
+				t.Fatalf("sampled=%v, args=%v", sampled, sampler.logs[0].Args)
+			}
+		}
+	})
+
+	SetStatementLogger(nil)
+	if ctx := startStatementLog(context.Background(), "SELECT 1", nil); ctx.Value(statementLogKey{}) != nil {
+		t.Fatal("statement should not be logged without logger")
+	}
+}
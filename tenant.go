@@ -0,0 +1,147 @@
+package entity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+)
+
+var (
+	// ErrTenantRequired is returned when an entity with tenant column is accessed without tenant in context.
+	ErrTenantRequired = errors.New("tenant required")
+	// ErrTenantConflict is returned when Upsert conflicts with the row of another tenant, it's also ErrConflict.
+	// It's not detected on MySQL, which reports no affected rows for unchanged rows as well.
+	ErrTenantConflict = fmt.Errorf("%w with other tenant", ErrConflict)
+)
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx with the tenant id.
+//
+// The tenant id is required to access the entities declared with the "tenant" tag option:
+// it's set to the tenant column on insert, and added to the WHERE of the load, update, upsert
+// and delete statements, and the Repository reads. The operations fail with ErrTenantRequired without it.
+//
+// The statements built by the helper functions, such as GetRecords and ExecUpdate, are not changed.
+func WithTenant(ctx context.Context, tenant any) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant id of ctx.
+func TenantFromContext(ctx context.Context) (any, bool) {
+	tenant := ctx.Value(tenantKey{})
+	return tenant, tenant != nil
+}
+
+// contextTenant returns the tenant of ctx converted to the type of tenant column.
+func contextTenant(ctx context.Context, md *Metadata, t reflect.Type) (reflect.Value, error) {
+	tenant, ok := TenantFromContext(ctx)
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("entity %q, %w", md.Type, ErrTenantRequired)
+	}
+
+	v := reflect.ValueOf(tenant)
+	if !convertibleTenant(v.Type(), t) {
+		return reflect.Value{}, fmt.Errorf("entity %q, tenant %T can't be converted to %s", md.Type, tenant, t)
+	}
+	return v.Convert(t), nil
+}
+
+// convertibleTenant reports whether the tenant of type from can be converted to type to,
+// the conversions between numbers and strings are not allowed.
+func convertibleTenant(from, to reflect.Type) bool {
+	if from == to {
+		return true
+	} else if !from.ConvertibleTo(to) {
+		return false
+	}
+	return isNumberKind(from.Kind()) == isNumberKind(to.Kind())
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// assignTenant sets the tenant of ctx to the tenant column of entity.
+func assignTenant(ctx context.Context, md *Metadata, ent Entity) error {
+	if md.tenant == nil {
+		return nil
+	}
+
+	field := fieldByColumn(reflect.ValueOf(ent), md.tenant.DBField)
+	if !field.CanSet() {
+		return fmt.Errorf("entity %q, tenant column %q can't be set", md.Type, md.tenant.DBField)
+	}
+
+	tenant, err := contextTenant(ctx, md, field.Type())
+	if err != nil {
+		return err
+	}
+
+	field.Set(tenant)
+	return nil
+}
+
+// tenantMatched reports whether the tenant column of entity is the tenant of ctx.
+func tenantMatched(ctx context.Context, md *Metadata, ent Entity) bool {
+	if md.tenant == nil {
+		return true
+	}
+
+	field := fieldByColumn(reflect.ValueOf(ent), md.tenant.DBField)
+	if !field.IsValid() {
+		return false
+	}
+
+	tenant, err := contextTenant(ctx, md, field.Type())
+	return err == nil && tenant.Interface() == field.Interface()
+}
+
+// cacheTenant returns the tenant of entity with tenant column, it prefixes the cache key of entity,
+// so the entities of different tenants with the same primary key are cached separately.
+func cacheTenant(ent Cacheable) (any, bool) {
+	v, ok := ent.(Entity)
+	if !ok {
+		return nil, false
+	}
+
+	md, err := getMetadata(v)
+	if err != nil || md.tenant == nil {
+		return nil, false
+	}
+
+	tenant, valid := indirectValue(fieldByColumn(reflect.ValueOf(ent), md.tenant.DBField))
+	if !valid || !tenant.IsValid() {
+		return nil, true
+	}
+	return tenant.Interface(), true
+}
+
+// tenantCondition returns the condition of the tenant of ctx for the reads from table, nil if md has no tenant column.
+func tenantCondition(ctx context.Context, md *Metadata, table string) (exp.Expression, error) {
+	if md.tenant == nil {
+		return nil, nil
+	}
+
+	tenant, ok := TenantFromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("entity %q, %w", md.Type, ErrTenantRequired)
+	}
+	return goqu.T(table).Col(md.tenant.DBField).Eq(tenant), nil
+}
+
+// tenantGuarded reports whether the upsert statement doesn't update the conflicting row of another tenant
+// by the WHERE of conflict update, nothing is affected in that case.
+func tenantGuarded(md *Metadata, driver string) bool {
+	return md.tenant != nil && !md.tenant.PrimaryKey && driver != driverMysql
+}
@@ -0,0 +1,192 @@
+package entity
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+)
+
+type tenantEntity struct {
+	ID       int64  `db:"id,primaryKey"`
+	TenantID int64  `db:"tenant_id,tenant"`
+	Name     string `db:"name"`
+}
+
+func (te *tenantEntity) TableName() string {
+	return "tenant_entity"
+}
+
+func (te *tenantEntity) SetID(id int64) error {
+	te.ID = id
+	return nil
+}
+
+func TestTenantStatement(t *testing.T) {
+	md, err := newTestMetadata(&tenantEntity{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		stmt     string
+		expected string
+	}{
+		{
+			stmt:     newSelectStatement(md, driverPostgres),
+			expected: `SELECT "id", "name", "tenant_id" FROM "tenant_entity" WHERE "id" = :id AND "tenant_id" = :tenant_id LIMIT 1`,
+		},
+		{
+			stmt:     newInsertStatement(md, driverPostgres),
+			expected: `INSERT INTO "tenant_entity" ("id", "name", "tenant_id") VALUES (:id, :name, :tenant_id)`,
+		},
+		{
+			stmt:     newUpdateStatement(md, driverPostgres),
+			expected: `UPDATE "tenant_entity" SET "name" = :name WHERE "id" = :id AND "tenant_id" = :tenant_id`,
+		},
+		{
+			stmt:     newDeleteStatement(md, driverPostgres),
+			expected: `DELETE FROM "tenant_entity" WHERE "id" = :id AND "tenant_id" = :tenant_id`,
+		},
+		{
+			stmt:     newUpsertStatement(md, driverPostgres),
+			expected: `INSERT INTO "tenant_entity" ("id", "name", "tenant_id") VALUES (:id, :name, :tenant_id) ON CONFLICT ("id") DO UPDATE SET "name" = :name WHERE "tenant_entity"."tenant_id" = EXCLUDED."tenant_id"`,
+		},
+		{
+			stmt:     newUpsertStatement(md, driverMysql),
+			expected: "INSERT INTO `tenant_entity` (`id`, `name`, `tenant_id`) VALUES (:id, :name, :tenant_id) ON CONFLICT KEY UPDATE `name` = IF(`tenant_id` = :tenant_id, :name, `name`)",
+		},
+	}
+
+	for _, c := range cases {
+		if c.stmt != c.expected {
+			t.Fatalf("tenant statement, Expected=%s, Actual=%s", c.expected, c.stmt)
+		}
+	}
+}
+
+func TestTenant(t *testing.T) {
+	md, err := getMetadata(&tenantEntity{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ent := &tenantEntity{ID: 1, TenantID: 2}
+	if err := assignTenant(context.Background(), md, ent); !errors.Is(err, ErrTenantRequired) {
+		t.Fatalf("assign without tenant, Expected=%v, Actual=%v", ErrTenantRequired, err)
+	} else if err := assignTenant(WithTenant(context.Background(), "a"), md, ent); err == nil {
+		t.Fatal("assign string tenant to int64 column, Expected=error, Actual=nil")
+	}
+
+	ctx := WithTenant(context.Background(), 7)
+	if tenantMatched(ctx, md, ent) {
+		t.Fatal("tenant should not match before assigned")
+	} else if err := assignTenant(ctx, md, ent); err != nil {
+		t.Fatal(err)
+	} else if ent.TenantID != 7 {
+		t.Fatalf("assigned tenant, Expected=7, Actual=%d", ent.TenantID)
+	} else if !tenantMatched(ctx, md, ent) {
+		t.Fatal("tenant should match after assigned")
+	}
+
+	db := &sqlx.DB{}
+	if err := Load(context.Background(), &tenantEntity{ID: 1}, db); !errors.Is(err, ErrTenantRequired) {
+		t.Fatalf("load without tenant, Expected=%v, Actual=%v", ErrTenantRequired, err)
+	}
+
+	repo := NewRepository[int64, *tenantEntity](db).Unscoped()
+	if _, _, err := repo.applyScopes(context.Background(), goqu.From("tenant_entity")).ToSQL(); !errors.Is(err, ErrTenantRequired) {
+		t.Fatalf("query without tenant, Expected=%v, Actual=%v", ErrTenantRequired, err)
+	}
+
+	query, _, err := repo.applyScopes(ctx, goqu.From("tenant_entity")).ToSQL()
+	if err != nil {
+		t.Fatal(err)
+	} else if expected := `SELECT * FROM "tenant_entity" WHERE ("tenant_entity"."tenant_id" = 7)`; query != expected {
+		t.Fatalf("tenant query, Expected=%s, Actual=%s", expected, query)
+	}
+
+	query, _, err = repo.applyScopes(ctx, goqu.From(goqu.T("tenant_entity").As("t"))).ToSQL()
+	if err != nil {
+		t.Fatal(err)
+	} else if expected := `SELECT * FROM "tenant_entity" AS "t" WHERE ("t"."tenant_id" = 7)`; query != expected {
+		t.Fatalf("aliased tenant query, Expected=%s, Actual=%s", expected, query)
+	}
+}
+
+type cachedTenantEntity struct {
+	tenantEntity
+
+	cacher Cacher
+}
+
+func (ce *cachedTenantEntity) CacheOption() CacheOption {
+	return CacheOption{Cacher: ce.cacher, Key: fmt.Sprintf("tenant_entity:%d", ce.ID)}
+}
+
+type mapCacher map[string][]byte
+
+func (mc mapCacher) Get(_ context.Context, key string) ([]byte, error) {
+	return mc[key], nil
+}
+
+func (mc mapCacher) Put(_ context.Context, key string, data []byte, _ time.Duration) error {
+	mc[key] = data
+	return nil
+}
+
+func (mc mapCacher) Delete(_ context.Context, key string) error {
+	delete(mc, key)
+	return nil
+}
+
+func TestTenantUpsert(t *testing.T) {
+	ctx := WithTenant(context.Background(), int64(7))
+
+	// the row of other tenant is not updated on conflict
+	db := sqlx.NewDb(sql.OpenDB(idsConnector{}), driverPostgres)
+	defer db.Close()
+	if err := Upsert(ctx, &tenantEntity{ID: 1}, db); !errors.Is(err, ErrTenantConflict) {
+		t.Fatalf("upsert, Expected=%v, Actual=%v", ErrTenantConflict, err)
+	} else if !errors.Is(err, ErrConflict) {
+		t.Fatal("tenant conflict should be conflict")
+	}
+
+	db = sqlx.NewDb(sql.OpenDB(idsConnector{ids: []int64{1}}), driverPostgres)
+	defer db.Close()
+	if err := Upsert(ctx, &tenantEntity{ID: 1}, db); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTenantCache(t *testing.T) {
+	cacher := mapCacher{}
+
+	cached := &cachedTenantEntity{tenantEntity: tenantEntity{ID: 1, TenantID: 1, Name: "tenant 1"}, cacher: cacher}
+	if err := SaveCache(WithTenant(context.Background(), int64(1)), cached); err != nil {
+		t.Fatal(err)
+	} else if _, ok := cacher["tenant:1:tenant_entity:1"]; !ok {
+		t.Fatalf("cache keys, Expected=tenant:1:tenant_entity:1, Actual=%v", cacher)
+	}
+
+	// the entity cached for another tenant is not loaded
+	db := &queryStub{}
+	ent := &cachedTenantEntity{tenantEntity: tenantEntity{ID: 1}, cacher: cacher}
+	if err := Load(WithTenant(context.Background(), int64(2)), ent, db); !errors.Is(err, errQueryStub) {
+		t.Fatalf("load of tenant 2, Expected=%v, Actual=%v", errQueryStub, err)
+	} else if ent.Name != "" || ent.TenantID != 2 {
+		t.Fatalf("entity of tenant 2, Actual=%+v", ent.tenantEntity)
+	}
+
+	ent = &cachedTenantEntity{tenantEntity: tenantEntity{ID: 1}, cacher: cacher}
+	if err := Load(WithTenant(context.Background(), int64(1)), ent, db); err != nil {
+		t.Fatal(err)
+	} else if ent.Name != "tenant 1" || len(db.queries) != 1 {
+		t.Fatalf("entity of tenant 1 from cache, Actual=%+v, queries=%d", ent.tenantEntity, len(db.queries))
+	}
+}
@@ -0,0 +1,484 @@
+package entity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// UnitOfWork tracks the entities loaded and changed within a business transaction,
+// and writes all the changes to the database in a single transaction on Commit.
+//
+// Entities loaded by the unit are kept in an identity map, repeated loads of the same primary key
+// return the same instance. Changes of the loaded entities are detected by comparing with the snapshot
+// taken when loaded, so RegisterDirty is only required for changes not visible to the comparison,
+// such as the changes through pointers shared with the snapshot.
+//
+// UnitOfWork is safe for concurrent use, but the tracked entities are not.
+type UnitOfWork struct {
+	db DB
+
+	// commitMu serializes Commit, mu is not held during the transaction,
+	// so the hooks and middleware of the writes can use the unit.
+	commitMu   sync.Mutex
+	mu         sync.Mutex
+	identities map[string]*trackedEntity
+	// keys of identities in the order of tracking
+	keys    []string
+	news    []Entity
+	dirties []Entity
+	removed []Entity
+	// changes written into the transaction of caller, waiting for AfterCommit or AfterRollback
+	flushed *flushedChanges
+}
+
+type trackedEntity struct {
+	entity   Entity
+	snapshot Entity
+}
+
+// flushedChanges are the entities written by Commit.
+type flushedChanges struct {
+	news, dirties, removed []Entity
+	// the new and changed entities, and their values before Commit
+	written, origins []Entity
+}
+
+// restore sets the written entities back to the values before Commit.
+func (c *flushedChanges) restore() {
+	for i, ent := range c.written {
+		restoreEntity(ent, c.origins[i])
+	}
+}
+
+// NewUnitOfWork creates a unit of work bound to the database.
+func NewUnitOfWork(db DB) *UnitOfWork {
+	return &UnitOfWork{
+		db:         db,
+		identities: map[string]*trackedEntity{},
+	}
+}
+
+// DB returns the database of the unit.
+func (u *UnitOfWork) DB() DB {
+	return u.db
+}
+
+// Load retrieves an entity by its primary key, the tracked instance is returned if it has been loaded,
+// otherwise ent is loaded from the database and tracked.
+func (u *UnitOfWork) Load(ctx context.Context, ent Entity) (Entity, error) {
+	if tracked, ok := u.lookup(ent); ok {
+		return tracked, nil
+	}
+
+	if err := Load(ctx, ent, u.db); err != nil {
+		return nil, err
+	}
+	return u.attach(ent)
+}
+
+func (u *UnitOfWork) lookup(ent Entity) (Entity, bool) {
+	key, ok, err := identityKey(ent)
+	if err != nil || !ok {
+		return nil, false
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if tracked, ok := u.identities[key]; ok {
+		return tracked.entity, true
+	}
+	return nil, false
+}
+
+// attach tracks a loaded entity, the tracked instance is returned if the key has been tracked.
+func (u *UnitOfWork) attach(ent Entity) (Entity, error) {
+	key, ok, err := identityKey(ent)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return ent, nil
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if tracked, ok := u.identities[key]; ok {
+		return tracked.entity, nil
+	}
+
+	u.track(key, ent)
+	return ent, nil
+}
+
+func (u *UnitOfWork) track(key string, ent Entity) {
+	if _, ok := u.identities[key]; !ok {
+		u.keys = append(u.keys, key)
+	}
+
+	u.identities[key] = &trackedEntity{
+		entity:   ent,
+		snapshot: snapshotEntity(ent),
+	}
+}
+
+// RegisterNew registers an entity to be inserted on Commit.
+func (u *UnitOfWork) RegisterNew(ent Entity) error {
+	if _, err := getMetadata(ent); err != nil {
+		return fmt.Errorf("get metadata, %w", err)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if !containsEntity(u.news, ent) {
+		u.news = append(u.news, ent)
+	}
+	return nil
+}
+
+// RegisterDirty registers an entity to be updated on Commit, whether it's changed or not.
+func (u *UnitOfWork) RegisterDirty(ent Entity) error {
+	if _, err := getMetadata(ent); err != nil {
+		return fmt.Errorf("get metadata, %w", err)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if containsEntity(u.removed, ent) {
+		return errors.New("entity has been removed")
+	} else if !containsEntity(u.news, ent) && !containsEntity(u.dirties, ent) {
+		u.dirties = append(u.dirties, ent)
+	}
+	return nil
+}
+
+// RegisterRemoved registers an entity to be deleted on Commit,
+// a new entity that has not been inserted is just discarded.
+func (u *UnitOfWork) RegisterRemoved(ent Entity) error {
+	if _, err := getMetadata(ent); err != nil {
+		return fmt.Errorf("get metadata, %w", err)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if containsEntity(u.news, ent) {
+		u.news = removeEntity(u.news, ent)
+		return nil
+	}
+
+	u.dirties = removeEntity(u.dirties, ent)
+	if !containsEntity(u.removed, ent) {
+		u.removed = append(u.removed, ent)
+	}
+	return nil
+}
+
+// Commit writes all the changes in a single transaction, in the dependency order declared by relations:
+// new entities are inserted with the referenced ones first, then the changed entities are updated,
+// and the removed entities are deleted with the referencing ones first.
+// The caches of the updated and deleted entities are invalidated again after the transaction is committed.
+//
+// If the transaction fails, the new and changed entities are restored to the values before Commit,
+// such as the auto increment IDs and versions set by the writes, so the changes can be committed again.
+//
+// If the unit is bound to a transaction, the changes are written into it, and it's up to the caller to commit.
+// The caches are not invalidated again and the written entities are not tracked as unchanged until AfterCommit is called
+// after the transaction is committed, call AfterRollback instead if it's rolled back, and discard the unit.
+// Commit fails until either of them is called.
+func (u *UnitOfWork) Commit(ctx context.Context) error {
+	u.commitMu.Lock()
+	defer u.commitMu.Unlock()
+
+	if u.flushed != nil {
+		return errors.New("the changes written into the transaction are waiting for AfterCommit or AfterRollback")
+	}
+
+	news, dirties, removed, err := u.pending()
+	if err != nil {
+		return err
+	}
+
+	changes := &flushedChanges{
+		news:    news,
+		dirties: dirties,
+		removed: removed,
+		written: append(append([]Entity{}, news...), dirties...),
+	}
+	for _, ent := range changes.written {
+		changes.origins = append(changes.origins, snapshotEntity(ent))
+	}
+
+	err = tryTransaction(ctx, u.db, func(db DB) error {
+		for _, ent := range news {
+			lastID, err := Insert(ctx, ent, db)
+			if err != nil {
+				return fmt.Errorf("insert %T, %w", ent, err)
+			} else if err := setAutoIncrementID(ent, lastID); err != nil {
+				return err
+			}
+		}
+
+		for _, ent := range dirties {
+			if err := Update(ctx, ent, db); err != nil {
+				return fmt.Errorf("update %T, %w", ent, err)
+			}
+		}
+
+		for _, ent := range removed {
+			if err := Delete(ctx, ent, db); err != nil {
+				return fmt.Errorf("delete %T, %w", ent, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		changes.restore()
+		return err
+	} else if _, ok := u.db.(Tx); ok && len(news)+len(dirties)+len(removed) > 0 {
+		u.flushed = changes
+		return nil
+	}
+	return u.finish(ctx, changes)
+}
+
+// AfterCommit completes the Commit of a unit bound to a transaction, it should be called after the transaction is committed.
+// The caches of the updated and deleted entities are invalidated, and the written entities are tracked as unchanged.
+func (u *UnitOfWork) AfterCommit(ctx context.Context) error {
+	u.commitMu.Lock()
+	defer u.commitMu.Unlock()
+
+	changes := u.flushed
+	if changes == nil {
+		return nil
+	}
+
+	u.flushed = nil
+	return u.finish(ctx, changes)
+}
+
+// AfterRollback restores the entities written by the Commit of a unit bound to a transaction,
+// such as the auto increment IDs and versions, it should be called after the transaction is rolled back.
+// The unit is bound to the rolled back transaction, so the entities should be registered to a new unit to write again.
+func (u *UnitOfWork) AfterRollback() {
+	u.commitMu.Lock()
+	defer u.commitMu.Unlock()
+
+	if u.flushed != nil {
+		u.flushed.restore()
+		u.flushed = nil
+	}
+}
+
+// finish invalidates the caches of the committed changes and tracks the written entities.
+func (u *UnitOfWork) finish(ctx context.Context, changes *flushedChanges) error {
+	// the caches may be refilled by other readers before the transaction is committed
+	for _, ents := range [][]Entity{changes.dirties, changes.removed} {
+		for _, ent := range ents {
+			if v, ok := ent.(Cacheable); ok {
+				if err := DeleteCache(ctx, v); err != nil {
+					return fmt.Errorf("delete cache, %w", err)
+				}
+			}
+		}
+	}
+
+	u.committed(changes.news, changes.dirties, changes.removed)
+	return nil
+}
+
+// pending returns the entities to be written by Commit in the dependency order.
+func (u *UnitOfWork) pending() (news, dirties, removed []Entity, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	news = sortByDependency(u.news, false)
+	removed = sortByDependency(u.removed, true)
+
+	dirties = append([]Entity{}, u.dirties...)
+	for _, key := range u.keys {
+		tracked := u.identities[key]
+		if containsEntity(dirties, tracked.entity) || containsEntity(u.removed, tracked.entity) {
+			continue
+		}
+
+		if changed, err := columnsChanged(tracked.snapshot, tracked.entity); err != nil {
+			return nil, nil, nil, err
+		} else if changed {
+			dirties = append(dirties, tracked.entity)
+		}
+	}
+	dirties = sortByDependency(dirties, false)
+
+	return news, dirties, removed, nil
+}
+
+// committed tracks the written entities, the ones registered during Commit are kept for the next one.
+func (u *UnitOfWork) committed(news, dirties, removed []Entity) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, ent := range removed {
+		if key, ok, _ := identityKey(ent); ok {
+			delete(u.identities, key)
+		}
+		u.removed = removeEntity(u.removed, ent)
+	}
+	keys := u.keys[:0]
+	for _, key := range u.keys {
+		if _, ok := u.identities[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	u.keys = keys
+
+	for _, ents := range [][]Entity{news, dirties} {
+		for _, ent := range ents {
+			if key, ok, _ := identityKey(ent); ok {
+				u.track(key, ent)
+			}
+		}
+	}
+	for _, ent := range news {
+		u.news = removeEntity(u.news, ent)
+	}
+	for _, ent := range dirties {
+		u.dirties = removeEntity(u.dirties, ent)
+	}
+}
+
+// snapshotEntity returns a shallow copy of the entity.
+func snapshotEntity(ent Entity) Entity {
+	rv := reflect.ValueOf(ent)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ent
+	}
+
+	snapshot := reflect.New(rv.Elem().Type())
+	snapshot.Elem().Set(rv.Elem())
+	return snapshot.Interface().(Entity)
+}
+
+// restoreEntity sets the values of snapshot back to the entity.
+func restoreEntity(ent, snapshot Entity) {
+	if rv := reflect.ValueOf(ent); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv.Elem().Set(reflect.ValueOf(snapshot).Elem())
+	}
+}
+
+func containsEntity(ents []Entity, ent Entity) bool {
+	for _, v := range ents {
+		if v == ent {
+			return true
+		}
+	}
+	return false
+}
+
+func removeEntity(ents []Entity, ent Entity) []Entity {
+	result := ents[:0]
+	for _, v := range ents {
+		if v != ent {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// sortByDependency sorts entities by the dependency of their types declared by relations,
+// the referenced types come first, or last if reverse. Entities of the same or unrelated types keep their order.
+func sortByDependency(ents []Entity, reverse bool) []Entity {
+	var types []reflect.Type
+	groups := map[reflect.Type][]Entity{}
+	for _, ent := range ents {
+		t := reflect.TypeOf(ent)
+		if _, ok := groups[t]; !ok {
+			types = append(types, t)
+		}
+		groups[t] = append(groups[t], ent)
+	}
+
+	// dependencies[a][b] means a references b, b should be inserted first
+	dependencies := map[reflect.Type]map[reflect.Type]bool{}
+	depends := func(a, b reflect.Type) {
+		if a == b {
+			return
+		}
+		if dependencies[a] == nil {
+			dependencies[a] = map[reflect.Type]bool{}
+		}
+		dependencies[a][b] = true
+	}
+
+	for _, t := range types {
+		md, err := getMetadata(groups[t][0])
+		if err != nil {
+			continue
+		}
+
+		for _, rel := range md.Relations {
+			target := reflect.PtrTo(rel.target)
+			if _, ok := groups[target]; !ok {
+				continue
+			}
+
+			switch rel.Kind {
+			case BelongsTo:
+				depends(t, target)
+			case HasOne, HasMany:
+				depends(target, t)
+			}
+		}
+	}
+
+	// stable topological sort, the remaining types of cycles keep their order
+	var sorted []reflect.Type
+	done := map[reflect.Type]bool{}
+	for len(sorted) < len(types) {
+		progress := false
+		for _, t := range types {
+			if done[t] {
+				continue
+			}
+
+			ready := true
+			for dep := range dependencies[t] {
+				if !done[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				sorted = append(sorted, t)
+				done[t] = true
+				progress = true
+			}
+		}
+
+		if !progress {
+			for _, t := range types {
+				if !done[t] {
+					sorted = append(sorted, t)
+					done[t] = true
+				}
+			}
+		}
+	}
+
+	if reverse {
+		for i, j := 0, len(sorted)-1; i < j; i, j = i+1, j-1 {
+			sorted[i], sorted[j] = sorted[j], sorted[i]
+		}
+	}
+
+	result := make([]Entity, 0, len(ents))
+	for _, t := range sorted {
+		result = append(result, groups[t]...)
+	}
+	return result
+}
@@ -0,0 +1,356 @@
+package entity
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestSortByDependency(t *testing.T) {
+	order := &relOrder{ID: 1}
+	profile := &relProfile{ID: 1}
+	user := &relUser{ID: 1}
+	group := &relGroup{ID: 1}
+
+	ents := []Entity{order, group, profile, user}
+
+	expected := []Entity{group, user, order, profile}
+	if sorted := sortByDependency(ents, false); !reflect.DeepEqual(sorted, expected) {
+		t.Fatalf("sort by dependency, Expected=%v, Actual=%v", expected, sorted)
+	}
+
+	expected = []Entity{profile, order, user, group}
+	if sorted := sortByDependency(ents, true); !reflect.DeepEqual(sorted, expected) {
+		t.Fatalf("reverse sort by dependency, Expected=%v, Actual=%v", expected, sorted)
+	}
+}
+
+type uowAuthor struct {
+	ID   int64  `db:"id,primaryKey"`
+	Name string `db:"name"`
+}
+
+func (*uowAuthor) TableName() string { return "authors" }
+
+func (a *uowAuthor) SetID(id int64) error {
+	a.ID = id
+	return nil
+}
+
+type uowBook struct {
+	ID       int64      `db:"id,primaryKey"`
+	AuthorID int64      `db:"author_id"`
+	Author   *uowAuthor `db:"-" rel:"belongsTo,fk=author_id"`
+
+	// registered on insert
+	unit    *UnitOfWork
+	related Entity
+}
+
+func (*uowBook) TableName() string { return "books" }
+
+func (b *uowBook) AfterInsert(context.Context) error {
+	if b.unit != nil && b.related != nil {
+		return b.unit.RegisterNew(b.related)
+	}
+	return nil
+}
+
+type uowEdition struct {
+	ID      int64 `db:"id,primaryKey"`
+	Version int64 `db:"version,version"`
+}
+
+func (*uowEdition) TableName() string { return "editions" }
+
+// uowConnector is a driver recording the executed statements, queries return the int64 arguments as the "id" column.
+type uowConnector struct {
+	statements *[]string
+	// executions containing it fail
+	fail *string
+}
+
+func (c uowConnector) Connect(context.Context) (driver.Conn, error) {
+	return uowConn(c), nil
+}
+
+func (c uowConnector) Driver() driver.Driver {
+	return c
+}
+
+func (c uowConnector) Open(string) (driver.Conn, error) {
+	return uowConn(c), nil
+}
+
+type uowConn uowConnector
+
+func (c uowConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c uowConn) Close() error {
+	return nil
+}
+
+func (c uowConn) Begin() (driver.Tx, error) {
+	*c.statements = append(*c.statements, "BEGIN")
+	return uowTx(c), nil
+}
+
+func (c uowConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	*c.statements = append(*c.statements, strings.Join(strings.Fields(query)[:3], " "))
+	if *c.fail != "" && strings.Contains(query, *c.fail) {
+		return nil, errors.New("failed")
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c uowConn) QueryContext(_ context.Context, _ string, args []driver.NamedValue) (driver.Rows, error) {
+	*c.statements = append(*c.statements, "SELECT")
+
+	rows := &idsRows{}
+	for _, arg := range args {
+		if id, ok := arg.Value.(int64); ok {
+			rows.ids = append(rows.ids, id)
+		}
+	}
+	return rows, nil
+}
+
+type uowTx uowConn
+
+func (tx uowTx) Commit() error {
+	*tx.statements = append(*tx.statements, "COMMIT")
+	return nil
+}
+
+func (tx uowTx) Rollback() error {
+	*tx.statements = append(*tx.statements, "ROLLBACK")
+	return nil
+}
+
+func TestUnitOfWork(t *testing.T) {
+	var (
+		statements []string
+		fail       string
+	)
+	db := sqlx.NewDb(sql.OpenDB(uowConnector{statements: &statements, fail: &fail}), driverPostgres)
+	defer db.Close()
+
+	ctx := context.Background()
+	u := NewUnitOfWork(db)
+	expect := func(name string, expected ...string) {
+		t.Helper()
+		if !reflect.DeepEqual(statements, expected) {
+			t.Fatalf("%s, Expected=%v, Actual=%v", name, expected, statements)
+		}
+		statements = nil
+	}
+
+	t.Run("identity map", func(t *testing.T) {
+		authors := NewRepository[int64, *uowAuthor](db).WithUnit(u)
+
+		author, err := authors.Find(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		} else if again, err := authors.Find(ctx, 1); err != nil {
+			t.Fatal(err)
+		} else if again != author {
+			t.Fatal("repeated find should return the tracked instance")
+		}
+		expect("find twice", "SELECT")
+
+		if tracked, err := u.Load(ctx, &uowAuthor{ID: 1}); err != nil {
+			t.Fatal(err)
+		} else if tracked != author {
+			t.Fatal("load should return the tracked instance")
+		}
+		expect("load tracked")
+
+		// unchanged entities are not written
+		if err := u.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+		expect("commit unchanged", "BEGIN", "COMMIT")
+
+		author.Name = "changed"
+		if err := u.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+		expect("commit changed", "BEGIN", "UPDATE \"authors\" SET", "COMMIT")
+	})
+
+	t.Run("commit order", func(t *testing.T) {
+		author := &uowAuthor{ID: 2}
+		book := &uowBook{ID: 1, AuthorID: 2}
+		removed := &uowAuthor{ID: 3}
+
+		// a new entity removed before commit is discarded
+		discarded := &uowBook{ID: 2}
+		for _, err := range []error{
+			u.RegisterNew(book),
+			u.RegisterNew(author),
+			u.RegisterNew(discarded),
+			u.RegisterRemoved(discarded),
+			u.RegisterRemoved(removed),
+		} {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := u.RegisterDirty(removed); err == nil {
+			t.Fatal("removed entity should not be registered as dirty")
+		}
+
+		if err := u.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+		expect("commit",
+			"BEGIN",
+			"INSERT INTO \"authors\"",
+			"INSERT INTO \"books\"",
+			"DELETE FROM \"authors\"",
+			"COMMIT",
+		)
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		edition := &uowEdition{ID: 1, Version: 1}
+		if err := u.RegisterNew(&uowAuthor{ID: 4}); err != nil {
+			t.Fatal(err)
+		} else if err := u.RegisterDirty(edition); err != nil {
+			t.Fatal(err)
+		} else if err := u.RegisterRemoved(&uowBook{ID: 3}); err != nil {
+			t.Fatal(err)
+		}
+
+		fail = "DELETE"
+		if err := u.Commit(ctx); err == nil {
+			t.Fatal("commit should fail")
+		}
+		expect("failed commit", "BEGIN", "INSERT INTO \"authors\"", "UPDATE \"editions\" SET", "DELETE FROM \"books\"", "ROLLBACK")
+
+		// the version increased by the rolled back update is restored
+		if edition.Version != 1 {
+			t.Fatalf("version after rollback, Expected=1, Actual=%d", edition.Version)
+		}
+
+		// the changes are kept after rollback
+		fail = ""
+		if err := u.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+		expect("retry", "BEGIN", "INSERT INTO \"authors\"", "UPDATE \"editions\" SET", "DELETE FROM \"books\"", "COMMIT")
+
+		if edition.Version != 2 {
+			t.Fatalf("version after commit, Expected=2, Actual=%d", edition.Version)
+		}
+	})
+
+	t.Run("register by hook", func(t *testing.T) {
+		// the hooks can use the unit during commit, the registered entities are written by the next commit
+		book := &uowBook{ID: 4, unit: u, related: &uowAuthor{ID: 5}}
+		if err := u.RegisterNew(book); err != nil {
+			t.Fatal(err)
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- u.Commit(ctx)
+		}()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("commit deadlocked")
+		}
+		expect("commit", "BEGIN", "INSERT INTO \"books\"", "COMMIT")
+
+		if err := u.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+		expect("next commit", "BEGIN", "INSERT INTO \"authors\"", "COMMIT")
+	})
+}
+
+func TestUnitOfWorkBoundToTx(t *testing.T) {
+	var (
+		statements []string
+		fail       string
+	)
+	db := sqlx.NewDb(sql.OpenDB(uowConnector{statements: &statements, fail: &fail}), driverPostgres)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	t.Run("commit", func(t *testing.T) {
+		tx, err := db.Beginx()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u := NewUnitOfWork(tx)
+		edition := &uowEdition{ID: 1, Version: 1}
+		if err := u.RegisterDirty(edition); err != nil {
+			t.Fatal(err)
+		} else if err := u.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		// the written changes are kept until the caller confirms the transaction
+		if err := u.Commit(ctx); err == nil {
+			t.Fatal("commit before AfterCommit, Expected=error, Actual=nil")
+		}
+
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		} else if err := u.AfterCommit(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		statements = nil
+		if err := u.Commit(ctx); err != nil {
+			t.Fatal(err)
+		} else if len(statements) != 0 {
+			t.Fatalf("commit after AfterCommit, Expected=[], Actual=%v", statements)
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		tx, err := db.Beginx()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u := NewUnitOfWork(tx)
+		edition := &uowEdition{ID: 1, Version: 1}
+		if err := u.RegisterDirty(edition); err != nil {
+			t.Fatal(err)
+		} else if err := u.Commit(ctx); err != nil {
+			t.Fatal(err)
+		} else if edition.Version != 2 {
+			t.Fatalf("version after commit, Expected=2, Actual=%d", edition.Version)
+		}
+
+		if err := tx.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+		u.AfterRollback()
+
+		// the version increased by the rolled back update is restored
+		if edition.Version != 1 {
+			t.Fatalf("version after rollback, Expected=1, Actual=%d", edition.Version)
+		}
+	})
+}
@@ -0,0 +1,270 @@
+package entity
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validator is an interface for entities that validate themselves before written to the database.
+//
+// Validate is called after the before hooks of Insert, InsertOrIgnore, Update, Upsert and the prepared statements,
+// op is the before event of the operation, such as EventBeforeInsert.
+type Validator interface {
+	Validate(ctx context.Context, op Event) error
+}
+
+// FieldError describes a validation rule that a column fails.
+type FieldError struct {
+	Field  string
+	Column string
+	Rule   string
+}
+
+func (fe FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", fe.Column, fe.Rule)
+}
+
+// ValidationError is returned when an entity fails the validation rules declared by struct tags,
+// it lists all the failed columns.
+type ValidationError struct {
+	Entity string
+	Fields []FieldError
+}
+
+func (ve *ValidationError) Error() string {
+	messages := make([]string, 0, len(ve.Fields))
+	for _, fe := range ve.Fields {
+		messages = append(messages, fe.Error())
+	}
+	return fmt.Sprintf("invalid %s, %s", ve.Entity, strings.Join(messages, "; "))
+}
+
+type columnValidator struct {
+	column Column
+	rules  []validationRule
+	// omitEmpty skips the rules if the value is NULL or zero value
+	omitEmpty bool
+}
+
+type validationRule struct {
+	name string
+	// check is called with the dereferenced field value, valid is false if the value is NULL.
+	check func(v reflect.Value, valid bool) bool
+}
+
+// newColumnValidators parses the validation rules declared by the "validate" tag and the "notNull" option.
+//
+// The tag is shared with other validators, such as go-playground/validator, so the rules not supported are skipped,
+// and so are the ones after "dive" or "keys", which apply to the elements. The malformed parameters of
+// the supported rules are errors.
+//
+// Supported rules:
+//   - required: not NULL and not zero value
+//   - min=n, max=n, len=n: length of strings, slices and maps, or value of numbers
+//   - oneof=a b c: one of the space separated values
+//   - omitempty: the other rules are skipped if the value is NULL or zero value
+func newColumnValidators(columns []Column) ([]columnValidator, error) {
+	var result []columnValidator
+
+	for _, col := range columns {
+		cv := columnValidator{column: col}
+		if col.NotNull {
+			cv.rules = append(cv.rules, validationRule{name: "notNull", check: checkNotNull})
+		}
+
+	parse:
+		for _, s := range strings.Split(col.Validate, ",") {
+			switch s = strings.TrimSpace(s); s {
+			case "":
+				continue
+			case "dive", "keys":
+				break parse
+			case "omitempty":
+				cv.omitEmpty = true
+				continue
+			}
+
+			rule, ok, err := newValidationRule(s)
+			if err != nil {
+				return nil, fmt.Errorf("column %q, %w", col.DBField, err)
+			} else if ok {
+				cv.rules = append(cv.rules, rule)
+			}
+		}
+
+		if len(cv.rules) > 0 {
+			result = append(result, cv)
+		}
+	}
+
+	return result, nil
+}
+
+// newValidationRule parses a rule, ok is false if the rule is not supported, such as the "or" rules like "rgb|rgba".
+func newValidationRule(s string) (rule validationRule, ok bool, err error) {
+	name, param, _ := strings.Cut(s, "=")
+	rule = validationRule{name: s}
+	if strings.Contains(s, "|") {
+		return rule, false, nil
+	}
+
+	switch name {
+	case "required":
+		rule.check = func(v reflect.Value, valid bool) bool {
+			return valid && !v.IsZero()
+		}
+	case "notNull", "not_null":
+		rule.check = checkNotNull
+	case "min", "max", "len":
+		n, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return rule, false, fmt.Errorf("invalid rule %q", s)
+		}
+
+		rule.check = func(v reflect.Value, valid bool) bool {
+			if !valid {
+				return true
+			}
+
+			size, ok := valueSize(v)
+			if !ok {
+				return false
+			}
+
+			switch name {
+			case "min":
+				return size >= n
+			case "max":
+				return size <= n
+			default:
+				return size == n
+			}
+		}
+	case "oneof":
+		options := strings.Fields(param)
+		rule.check = func(v reflect.Value, valid bool) bool {
+			if !valid {
+				return true
+			}
+
+			s := fmt.Sprint(v.Interface())
+			for _, option := range options {
+				if s == option {
+					return true
+				}
+			}
+			return false
+		}
+	default:
+		return rule, false, nil
+	}
+
+	return rule, true, nil
+}
+
+func checkNotNull(_ reflect.Value, valid bool) bool {
+	return valid
+}
+
+// valueSize returns the length of strings, slices and maps, or the value of numbers.
+func valueSize(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// indirectValue dereferences pointers and driver.Valuer, it returns false if the value is NULL.
+func indirectValue(v reflect.Value) (reflect.Value, bool) {
+	for {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			if v.IsNil() {
+				return v, false
+			}
+		case reflect.Invalid:
+			return v, false
+		}
+
+		var valuer driver.Valuer
+		if v.CanInterface() {
+			valuer, _ = v.Interface().(driver.Valuer)
+		}
+		if valuer == nil && v.CanAddr() && v.Addr().CanInterface() {
+			valuer, _ = v.Addr().Interface().(driver.Valuer)
+		}
+
+		if valuer != nil {
+			value, err := valuer.Value()
+			if err != nil || value == nil {
+				return v, false
+			}
+			return reflect.ValueOf(value), true
+		}
+
+		if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			v = v.Elem()
+			continue
+		}
+		return v, true
+	}
+}
+
+// validate checks the columns written by the operation, then calls the Validator implemented by the entity.
+func validate(ctx context.Context, op *Operation, ev Event) error {
+	var failures []FieldError
+
+	rv := reflect.ValueOf(op.Entity)
+	for _, cv := range op.Metadata.validators {
+		col := cv.column
+		if ev == EventBeforeUpdate {
+			if col.RefuseUpdate || col.ReturningUpdate {
+				continue
+			}
+		} else if col.AutoIncrement || col.ReturningInsert {
+			continue
+		}
+
+		v, valid := indirectValue(fieldByColumn(rv, col.DBField))
+		if cv.omitEmpty && (!valid || v.IsZero()) {
+			continue
+		}
+
+		for _, rule := range cv.rules {
+			if !rule.check(v, valid) {
+				failures = append(failures, FieldError{
+					Field:  col.StructField,
+					Column: col.DBField,
+					Rule:   rule.name,
+				})
+				break
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return &ValidationError{
+			Entity: op.Metadata.Type.String(),
+			Fields: failures,
+		}
+	}
+
+	if v, ok := op.Entity.(Validator); ok {
+		return v.Validate(ctx, ev)
+	}
+	return nil
+}
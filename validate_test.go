@@ -0,0 +1,136 @@
+package entity
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type validatedEntity struct {
+	ID    int64          `db:"id,primaryKey,autoIncrement" validate:"required"`
+	Name  string         `db:"name" validate:"required,max=4"`
+	Email sql.NullString `db:"email,notNull"`
+	Role  string         `db:"role" validate:"oneof=admin member"`
+	Tags  *string        `db:"tags" validate:"min=2"`
+	Score int            `db:"score,refuseUpdate" validate:"min=1,max=10"`
+
+	validated Event
+}
+
+func (ve *validatedEntity) TableName() string {
+	return "validated"
+}
+
+func (ve *validatedEntity) Validate(_ context.Context, ev Event) error {
+	ve.validated = ev
+	return nil
+}
+
+type sharedTagEntity struct {
+	ID    int64    `db:"id,primaryKey"`
+	Email string   `db:"email" validate:"required,email"`
+	Phone string   `db:"phone" validate:"omitempty,e164,min=8"`
+	Color string   `db:"color" validate:"rgb|rgba"`
+	Tags  []string `db:"tags" validate:"max=2,dive,min=3"`
+}
+
+func (se *sharedTagEntity) TableName() string {
+	return "shared_tag"
+}
+
+func TestValidate(t *testing.T) {
+	validateOp := func(ent Entity, ev Event) error {
+		md, err := getMetadata(ent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return validate(context.Background(), &Operation{Metadata: md, Entity: ent}, ev)
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		ent := &validatedEntity{
+			Name:  "张三李四",
+			Email: sql.NullString{String: "a@b.c", Valid: true},
+			Role:  "admin",
+			Score: 10,
+		}
+		if err := validateOp(ent, EventBeforeInsert); err != nil {
+			t.Fatalf("validate, %v", err)
+		} else if ent.validated != EventBeforeInsert {
+			t.Fatalf("Validator, Expected=%v, Actual=%v", EventBeforeInsert, ent.validated)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		tags := "a"
+		ent := &validatedEntity{
+			Name:  "too long",
+			Role:  "guest",
+			Tags:  &tags,
+			Score: 0,
+		}
+
+		err := validateOp(ent, EventBeforeInsert)
+
+		var ve *ValidationError
+		if !errors.As(err, &ve) {
+			t.Fatalf("validate, Expected=ValidationError, Actual=%v", err)
+		}
+
+		expected := []FieldError{
+			{Field: "Name", Column: "name", Rule: "max=4"},
+			{Field: "Email", Column: "email", Rule: "notNull"},
+			{Field: "Role", Column: "role", Rule: "oneof=admin member"},
+			{Field: "Tags", Column: "tags", Rule: "min=2"},
+			{Field: "Score", Column: "score", Rule: "min=1"},
+		}
+		if !reflect.DeepEqual(ve.Fields, expected) {
+			t.Fatalf("validation errors, Expected=%v, Actual=%v", expected, ve.Fields)
+		} else if ent.validated != 0 {
+			t.Fatal("Validator should not be called")
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		ent := &validatedEntity{
+			Name:  "name",
+			Email: sql.NullString{Valid: true},
+			Role:  "member",
+		}
+		if err := validateOp(ent, EventBeforeUpdate); err != nil {
+			t.Fatalf("validate, %v", err)
+		}
+	})
+
+	t.Run("invalid rule", func(t *testing.T) {
+		if _, err := newColumnValidators([]Column{{DBField: "name", Validate: "max=x"}}); err == nil {
+			t.Fatal("newColumnValidators(max=x), Expected=error, Actual=nil")
+		}
+	})
+	t.Run("shared tag", func(t *testing.T) {
+		// the rules of other validators are skipped, and so are the rules of elements
+		ent := &sharedTagEntity{Email: "not an email", Color: "blue", Tags: []string{"a", "b"}}
+		if err := validateOp(ent, EventBeforeInsert); err != nil {
+			t.Fatalf("validate, Expected=nil, Actual=%v", err)
+		}
+
+		ent = &sharedTagEntity{Phone: "+1", Tags: []string{"a", "b", "c"}}
+		err := validateOp(ent, EventBeforeInsert)
+
+		var ve *ValidationError
+		if !errors.As(err, &ve) {
+			t.Fatalf("validate, Expected=ValidationError, Actual=%v", err)
+		}
+
+		expected := []FieldError{
+			{Field: "Email", Column: "email", Rule: "required"},
+			{Field: "Phone", Column: "phone", Rule: "min=8"},
+			{Field: "Tags", Column: "tags", Rule: "max=2"},
+		}
+		if !reflect.DeepEqual(ve.Fields, expected) {
+			t.Fatalf("validation errors, Expected=%v, Actual=%v", expected, ve.Fields)
+		}
+	})
+}